@@ -0,0 +1,93 @@
+package main
+
+// Files like the command store are replaced as a whole instead of being overwritten in place,
+// so a crash or a full disk in the middle of writing leaves the old content and not a truncated file
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/gofrs/flock"
+)
+
+// the lock is on a file of its own, because the locked file itself is replaced by a new one on every
+// write and a lock on the replaced one would not keep anyone out of the new one
+func lockOfFile(pathToFile string) *flock.Flock {
+	return flock.New(pathToFile + ".lock")
+}
+
+// writes the data to a temporary file next to the path and renames it over the path once it is on disk,
+// the previous content is kept as the given number of backups, the newest being backup 1,
+// but only if isValid says it is worth keeping, nil keeps any content
+func writeFileAtomically(pathToFile string, data []byte, numberOfBackups int, isValid func(data []byte) bool) error {
+	directory := filepath.Dir(pathToFile)
+
+	// only readable and writeable by own user
+	temporaryFile, err := ioutil.TempFile(directory, filepath.Base(pathToFile)+".tmp-")
+	if err != nil {
+		return err
+	}
+	// fails harmlessly once the file was renamed
+	defer os.Remove(temporaryFile.Name())
+
+	if _, err := temporaryFile.Write(data); err != nil {
+		temporaryFile.Close()
+		return err
+	}
+	// the new content has to be on disk before it replaces the old one, otherwise a crash
+	// could leave an empty file behind
+	if err := temporaryFile.Sync(); err != nil {
+		temporaryFile.Close()
+		return err
+	}
+	if err := temporaryFile.Close(); err != nil {
+		return err
+	}
+
+	if err := rotateBackupsOfFile(pathToFile, numberOfBackups, isValid); err != nil {
+		return fmt.Errorf("could not keep backup of %v: %w", pathToFile, err)
+	}
+	if err := os.Rename(temporaryFile.Name(), pathToFile); err != nil {
+		return err
+	}
+	// the rename is only durable once the directory is on disk as well
+	return syncDirectory(directory)
+}
+
+// like commandStore.json.1.bak, 1 is the newest
+func pathOfBackup(pathToFile string, generation int) string {
+	return fmt.Sprintf("%v.%v.bak", pathToFile, generation)
+}
+
+// the current content becomes backup 1, backup 1 becomes backup 2 and so on, the oldest is dropped,
+// a corrupted current content is not kept, otherwise a few writes over it would push out all valid backups
+func rotateBackupsOfFile(pathToFile string, numberOfBackups int, isValid func(data []byte) bool) error {
+	if numberOfBackups <= 0 {
+		return nil
+	}
+	currentData, err := ioutil.ReadFile(pathToFile)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if isValid != nil && !isValid(currentData) {
+		return nil
+	}
+
+	if err := os.Remove(pathOfBackup(pathToFile, numberOfBackups)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	for generation := numberOfBackups - 1; generation >= 1; generation-- {
+		if err := os.Rename(pathOfBackup(pathToFile, generation), pathOfBackup(pathToFile, generation+1)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	// a copy keeps the current file where it is until the new one replaces it, so there is no moment
+	// without a file, a hard link would do the same but be corrupted together with the current file
+	return ioutil.WriteFile(pathOfBackup(pathToFile, 1), currentData, 0600)
+}
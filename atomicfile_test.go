@@ -0,0 +1,90 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func isNotCorrupted(data []byte) bool {
+	return string(data) != "corrupted"
+}
+
+func TestWriteFileAtomicallyRotatesBackups(t *testing.T) {
+	testCases := []struct {
+		name            string
+		writes          []string
+		numberOfBackups int
+		isValid         func(data []byte) bool
+		expectedCurrent string
+		// by generation, starting with backup 1, empty for no backup
+		expectedBackups []string
+	}{
+		{"first write", []string{"a"}, 3, nil, "a", []string{"", "", ""}},
+		{"second write", []string{"a", "b"}, 3, nil, "b", []string{"a", "", ""}},
+		{"oldest is dropped", []string{"a", "b", "c", "d", "e"}, 3, nil, "e", []string{"d", "c", "b"}},
+		{"no backups", []string{"a", "b"}, 0, nil, "b", []string{"", ""}},
+		{"corrupted content is kept without check", []string{"a", "b", "corrupted", "c"}, 2, nil, "c", []string{"corrupted", "a"}},
+		{"corrupted content is not kept", []string{"a", "b", "corrupted", "c"}, 2, isNotCorrupted, "c", []string{"a", ""}},
+		{"writing over corrupted content again and again", []string{"a", "b", "c", "corrupted", "d", "corrupted", "e"}, 2, isNotCorrupted, "e", []string{"b", "a"}},
+	}
+	for _, testCase := range testCases {
+		pathToFile := filepath.Join(t.TempDir(), "store.json")
+		for _, currentWrite := range testCase.writes {
+			// a corrupted file is not written by the code, it just happens
+			if currentWrite == "corrupted" {
+				if err := ioutil.WriteFile(pathToFile, []byte(currentWrite), 0600); err != nil {
+					t.Fatal(err)
+				}
+				continue
+			}
+			if err := writeFileAtomically(pathToFile, []byte(currentWrite), testCase.numberOfBackups, testCase.isValid); err != nil {
+				t.Fatalf("%v: %v", testCase.name, err)
+			}
+		}
+
+		if current := readFileOrEmpty(t, pathToFile); current != testCase.expectedCurrent {
+			t.Errorf("%v: current content %q, expected %q", testCase.name, current, testCase.expectedCurrent)
+		}
+		for index, expectedBackup := range testCase.expectedBackups {
+			if backup := readFileOrEmpty(t, pathOfBackup(pathToFile, index+1)); backup != expectedBackup {
+				t.Errorf("%v: backup %v is %q, expected %q", testCase.name, index+1, backup, expectedBackup)
+			}
+		}
+	}
+}
+
+func TestWriteFileAtomicallyLeavesNoTemporaryFiles(t *testing.T) {
+	directory := t.TempDir()
+	pathToFile := filepath.Join(directory, "store.json")
+	for _, content := range []string{"a", "b"} {
+		if err := writeFileAtomically(pathToFile, []byte(content), 1, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	files, err := ioutil.ReadDir(directory)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, currentFile := range files {
+		if name := currentFile.Name(); name != "store.json" && name != "store.json.1.bak" {
+			t.Errorf("unexpected file %v", name)
+		}
+		if mode := currentFile.Mode().Perm(); mode != 0600 {
+			t.Errorf("%v is readable by others with mode %v", currentFile.Name(), mode)
+		}
+	}
+}
+
+func readFileOrEmpty(t *testing.T, pathToFile string) string {
+	t.Helper()
+	data, err := ioutil.ReadFile(pathToFile)
+	if os.IsNotExist(err) {
+		return ""
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
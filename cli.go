@@ -0,0 +1,549 @@
+package main
+
+// Command line interface with one subcommand per action, e.g. `WorkScheduler add --name backup /usr/bin/backup`
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// exit codes, so scripts can tell what went wrong
+const (
+	exitCodeSuccess = 0
+	// the action failed, e.g. the command store could not be read
+	exitCodeError = 1
+	// wrong subcommand, flags or arguments
+	exitCodeUsage = 2
+	// there is no command with the given name
+	exitCodeCommandNotFound = 3
+	// validate-config found invalid config files
+	exitCodeInvalidConfig = 4
+)
+
+// subcommand is one action of the command line interface
+type subcommand struct {
+	name string
+	// arguments after the flags, shown in the usage
+	arguments   string
+	description string
+	// registers the flags before the arguments are parsed and returns what runs with the arguments after the flags
+	defineFlags func(flagSet *flag.FlagSet) func(arguments []string) int
+	// minimum and maximum number of arguments after the flags, -1 means no maximum
+	minimumArguments int
+	maximumArguments int
+	// everything after the first argument is an argument, e.g. the arguments of a command to add,
+	// otherwise flags may also come between and after the arguments
+	flagsOnlyBeforeArguments bool
+}
+
+// subcommands that only take arguments use this instead of defining flags
+func withoutFlags(run func(arguments []string) int) func(flagSet *flag.FlagSet) func(arguments []string) int {
+	return func(flagSet *flag.FlagSet) func(arguments []string) int {
+		return run
+	}
+}
+
+var subcommands = []subcommand{
+	{
+		name:        "daemon",
+		description: "Runs the scheduled commands when their conditions are met. Default when no subcommand is given.",
+		defineFlags: defineFlagsOfDaemon,
+	},
+	{
+		name:                     "add",
+		arguments:                "<absolute path of command> [arguments of command...]",
+		description:              "Adds a command to be run later. Flags have to come before the path of the command.",
+		defineFlags:              defineFlagsOfAdd,
+		minimumArguments:         1,
+		maximumArguments:         -1,
+		flagsOnlyBeforeArguments: true,
+	},
+	{
+		name:        "list",
+		description: "Lists all commands with their state, last run, next eligible time and the conditions blocking them.",
+		defineFlags: defineFlagsOfStatus,
+	},
+	{
+		name:             "status",
+		arguments:        "<command name> [command names...]",
+		description:      "Like list, but only for the given commands.",
+		defineFlags:      defineFlagsOfStatus,
+		minimumArguments: 1,
+		maximumArguments: -1,
+	},
+	{
+		name:        "explain",
+		arguments:   "<command name>",
+		description: "Explains why a command is not running: every check before it is started, whether it passes and when it is expected to.",
+		defineFlags: withoutFlags(func(arguments []string) int {
+			return exitCodeOfError("Error when explaining command:", explainCommand(arguments[0]))
+		}),
+		minimumArguments: 1,
+		maximumArguments: 1,
+	},
+	{
+		name:             "show",
+		arguments:        "<command name>",
+		description:      "Shows all settings and the state of a command.",
+		defineFlags:      withoutFlags(func(arguments []string) int { return showCommand(arguments[0]) }),
+		minimumArguments: 1,
+		maximumArguments: 1,
+	},
+	{
+		name:        "remove",
+		arguments:   "<command name>",
+		description: "Removes a command that was added with add. Commands from config files are removed by deleting their file.",
+		defineFlags: withoutFlags(func(arguments []string) int {
+			return exitCodeOfControlRequest("Error when removing command:", controlRequest{Action: controlActionRemove, CommandName: arguments[0]})
+		}),
+		minimumArguments: 1,
+		maximumArguments: 1,
+	},
+	{
+		name:        "run-now",
+		arguments:   "<command name>",
+		description: "Runs a command as soon as the daemon sees it, no matter its interval, schedule, time windows and conditions.",
+		defineFlags: withoutFlags(func(arguments []string) int {
+			return exitCodeOfControlRequest("Error when requesting run:", controlRequest{Action: controlActionRunNow, CommandName: arguments[0]})
+		}),
+		minimumArguments: 1,
+		maximumArguments: 1,
+	},
+	{
+		name:        "enable",
+		arguments:   "<command name>",
+		description: "Lets a disabled command be run again.",
+		defineFlags: withoutFlags(func(arguments []string) int {
+			return exitCodeOfControlRequest("Error when enabling command:", controlRequest{Action: controlActionEnable, CommandName: arguments[0]})
+		}),
+		minimumArguments: 1,
+		maximumArguments: 1,
+	},
+	{
+		name:        "disable",
+		arguments:   "<command name>",
+		description: "Stops a command from being run until it is enabled again, a running command is not stopped.",
+		defineFlags: withoutFlags(func(arguments []string) int {
+			return exitCodeOfControlRequest("Error when disabling command:", controlRequest{Action: controlActionDisable, CommandName: arguments[0]})
+		}),
+		minimumArguments: 1,
+		maximumArguments: 1,
+	},
+	{
+		name:        "pause",
+		arguments:   "<command name>",
+		description: "Pauses a running command until it is resumed, needs the daemon to run.",
+		defineFlags: withoutFlags(func(arguments []string) int {
+			return exitCodeOfControlRequest("Error when pausing command:", controlRequest{Action: controlActionPause, CommandName: arguments[0]})
+		}),
+		minimumArguments: 1,
+		maximumArguments: 1,
+	},
+	{
+		name:        "resume",
+		arguments:   "<command name>",
+		description: "Resumes a paused command, needs the daemon to run.",
+		defineFlags: withoutFlags(func(arguments []string) int {
+			return exitCodeOfControlRequest("Error when resuming command:", controlRequest{Action: controlActionResume, CommandName: arguments[0]})
+		}),
+		minimumArguments: 1,
+		maximumArguments: 1,
+	},
+	{
+		name:        "history",
+		arguments:   "<command name> [how far back, default 720h]",
+		description: "Shows the runs of a command and how many of them failed.",
+		defineFlags: withoutFlags(func(arguments []string) int {
+			return exitCodeOfError("Error when showing run history:", printRunHistory(arguments))
+		}),
+		minimumArguments: 1,
+		maximumArguments: 2,
+	},
+	{
+		name:             "logs",
+		arguments:        "<command name> [start of run id, default newest run]",
+		description:      "Shows the output of a run of a command.",
+		defineFlags:      defineFlagsOfLogs,
+		minimumArguments: 1,
+		maximumArguments: 2,
+	},
+	{
+		name:        "validate-config",
+		arguments:   "[config files..., default all in the working directory]",
+		description: "Checks config files without changing anything.",
+		defineFlags: withoutFlags(func(arguments []string) int {
+			return validateConfigFiles(arguments)
+		}),
+		maximumArguments: -1,
+	},
+}
+
+// runs the subcommand in the arguments and returns the exit code of the program
+func runCommandLine(arguments []string) int {
+	// no subcommand starts the daemon like before there were subcommands
+	if len(arguments) == 0 {
+		runDaemonMode(daemonSettings{})
+		return exitCodeSuccess
+	}
+
+	switch arguments[0] {
+	case "help", "-h", "-help", "--help":
+		if len(arguments) >= 2 {
+			if helpOfSubcommand, found := findSubcommand(arguments[1]); found {
+				flagSet := newFlagSetOfSubcommand(helpOfSubcommand)
+				helpOfSubcommand.defineFlags(flagSet)
+				flagSet.SetOutput(os.Stdout)
+				flagSet.Usage()
+				return exitCodeSuccess
+			}
+		}
+		printUsage()
+		return exitCodeSuccess
+	}
+
+	subcommandToRun, found := findSubcommand(arguments[0])
+	if !found {
+		fmt.Fprintf(os.Stderr, "Unknown subcommand %q, commands are added with `%v add`.\n\n", arguments[0], programName())
+		printUsage()
+		return exitCodeUsage
+	}
+
+	flagSet := newFlagSetOfSubcommand(subcommandToRun)
+	run := subcommandToRun.defineFlags(flagSet)
+	argumentsAfterFlags, err := parseFlagsOfSubcommand(flagSet, arguments[1:], subcommandToRun.flagsOnlyBeforeArguments)
+	if err != nil {
+		if err == flag.ErrHelp {
+			return exitCodeSuccess
+		}
+		return exitCodeUsage
+	}
+
+	numberOfArguments := len(argumentsAfterFlags)
+	if numberOfArguments < subcommandToRun.minimumArguments ||
+		(subcommandToRun.maximumArguments >= 0 && numberOfArguments > subcommandToRun.maximumArguments) {
+		fmt.Fprintf(os.Stderr, "Wrong number of arguments for %v.\n\n", subcommandToRun.name)
+		flagSet.Usage()
+		return exitCodeUsage
+	}
+
+	return run(argumentsAfterFlags)
+}
+
+// parses the flags and returns the other arguments, the flag package stops at the first argument,
+// so parsing goes on after every argument unless flags may only come first or "--" ends the flags
+func parseFlagsOfSubcommand(flagSet *flag.FlagSet, arguments []string, flagsOnlyBeforeArguments bool) ([]string, error) {
+	argumentsAfterFlags := []string{}
+	remainingArguments := arguments
+	for {
+		if err := flagSet.Parse(remainingArguments); err != nil {
+			return nil, err
+		}
+		numberOfParsedArguments := len(remainingArguments) - flagSet.NArg()
+		endedWithSeparator := numberOfParsedArguments > 0 && remainingArguments[numberOfParsedArguments-1] == "--"
+		if flagSet.NArg() == 0 || flagsOnlyBeforeArguments || endedWithSeparator {
+			return append(argumentsAfterFlags, flagSet.Args()...), nil
+		}
+		argumentsAfterFlags = append(argumentsAfterFlags, flagSet.Arg(0))
+		remainingArguments = flagSet.Args()[1:]
+	}
+}
+
+func findSubcommand(name string) (subcommand, bool) {
+	for _, currentSubcommand := range subcommands {
+		if currentSubcommand.name == name {
+			return currentSubcommand, true
+		}
+	}
+	return subcommand{}, false
+}
+
+func newFlagSetOfSubcommand(subcommandOfFlagSet subcommand) *flag.FlagSet {
+	flagSet := flag.NewFlagSet(subcommandOfFlagSet.name, flag.ContinueOnError)
+	flagSet.Usage = func() {
+		fmt.Fprintf(flagSet.Output(), "Usage: %v %v [flags] %v\n\n%v\n", programName(), subcommandOfFlagSet.name,
+			subcommandOfFlagSet.arguments, subcommandOfFlagSet.description)
+		numberOfFlags := 0
+		flagSet.VisitAll(func(*flag.Flag) { numberOfFlags++ })
+		if numberOfFlags > 0 {
+			fmt.Fprintln(flagSet.Output(), "\nFlags:")
+			flagSet.PrintDefaults()
+		}
+	}
+	return flagSet
+}
+
+func printUsage() {
+	fmt.Printf("Usage: %v <subcommand> [flags] [arguments]\n\n", programName())
+	fmt.Println("Schedules commands to be run when certain conditions are met.")
+	fmt.Println()
+	fmt.Println("Subcommands:")
+	for _, currentSubcommand := range subcommands {
+		fmt.Printf("  %-16v %v\n", currentSubcommand.name, currentSubcommand.description)
+	}
+	fmt.Println()
+	fmt.Printf("Run `%v help <subcommand>` for the flags and arguments of a subcommand.\n", programName())
+}
+
+func programName() string {
+	return filepath.Base(os.Args[0])
+}
+
+// prints the error, if there is one, and returns the matching exit code
+func exitCodeOfError(messageBeforeError string, err error) int {
+	if err == nil {
+		return exitCodeSuccess
+	}
+	fmt.Fprintln(os.Stderr, messageBeforeError, err)
+	if errors.Is(err, errCommandNotFound) {
+		return exitCodeCommandNotFound
+	}
+	return exitCodeError
+}
+
+func defineFlagsOfDaemon(flagSet *flag.FlagSet) func(arguments []string) int {
+	settings := daemonSettings{}
+	enableHTTPAPI := flagSet.Bool("http", false, "serve the HTTP API on "+defaultHTTPAPIAddress)
+	flagSet.StringVar(&settings.httpAPIAddress, "http-listen", "", "serve the HTTP API on this address, e.g. 127.0.0.1:8742 or unix:./workScheduler-api.sock")
+	flagSet.StringVar(&settings.pathToHTTPAPITokenFile, "http-token-file", "", "file containing the bearer token clients of the HTTP API have to send, needed unless it is served on a Unix socket")
+	flagSet.StringVar(&settings.metricsAddress, "metrics-listen", "", "serve only the Prometheus metrics without a token on this address, e.g. 127.0.0.1:9742")
+	logFormat := flagSet.String("log-format", logFormatText, "how to write log messages: text or json lines on standard error, or journald to send them to the journal with a field per command")
+	logLevelName := flagSet.String("log-level", "info", "only log messages of at least this level: debug, info, warning or error")
+
+	return func(arguments []string) int {
+		minimumLogLevel, err := parseLogLevel(*logLevelName)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return exitCodeUsage
+		}
+		if *logFormat != logFormatText && *logFormat != logFormatJSON && *logFormat != logFormatJournald {
+			fmt.Fprintln(os.Stderr, "Unknown log format", *logFormat, "known are text, json and journald")
+			return exitCodeUsage
+		}
+		// e.g. journald is not running
+		if err := configureLogging(*logFormat, minimumLogLevel); err != nil {
+			fmt.Fprintln(os.Stderr, "Could not set up logging:", err)
+			return exitCodeError
+		}
+		if *enableHTTPAPI && settings.httpAPIAddress == "" {
+			settings.httpAPIAddress = defaultHTTPAPIAddress
+		}
+		runDaemonMode(settings)
+		return exitCodeSuccess
+	}
+}
+
+func defineFlagsOfAdd(flagSet *flag.FlagSet) func(arguments []string) int {
+	name := flagSet.String("name", "", "unique name of the command (default the file name of the command)")
+	// so big it is practically run only once, like before there were flags
+	interval := flagSet.Duration("interval", 999999999*time.Second, "minimum time between successful runs, e.g. 24h, the default means practically only once")
+	requires := flagSet.String("requires", "", "comma separated conditions that have to be met, e.g. ac_power,network (default "+strings.Join(defaultRequiredConditions, ",")+")")
+
+	return func(arguments []string) int {
+		// careful, user supplied input!
+		commandToExecuteAbsolutePath := arguments[0]
+		// no path lookup, so no other program can sneak in through PATH
+		if !filepath.IsAbs(commandToExecuteAbsolutePath) {
+			fmt.Fprintf(os.Stderr, "Path of command must be absolute, but is %q.\n", commandToExecuteAbsolutePath)
+			return exitCodeUsage
+		}
+
+		newCommand := CommandWithArguments{
+			Name:                 *name,
+			AbsolutePath:         commandToExecuteAbsolutePath,
+			CommandArguments:     arguments[1:],
+			DurationBetweenRuns:  *interval,
+			AddedFromCommandLine: true,
+		}
+		if newCommand.Name == "" {
+			newCommand.Name = filepath.Base(commandToExecuteAbsolutePath)
+		}
+		if *requires != "" {
+			for _, currentConditionName := range strings.Split(*requires, ",") {
+				newCommand.RequiredConditions = append(newCommand.RequiredConditions, strings.TrimSpace(currentConditionName))
+			}
+		}
+		if _, err := buildConditionsForCommand(newCommand); err != nil {
+			fmt.Fprintln(os.Stderr, "Invalid conditions:", err)
+			return exitCodeUsage
+		}
+
+		fmt.Println("Adding the following command to the command store for later execution...")
+		fmt.Printf("Name: %q\n", newCommand.Name)
+		fmt.Printf("Absolute path: %q\n", newCommand.AbsolutePath)
+		fmt.Print("Argument list: ")
+		for _, currentArgument := range newCommand.CommandArguments {
+			fmt.Printf("%q ", currentArgument)
+		}
+		fmt.Println()
+
+		return exitCodeOfControlRequest("Error when adding command to command store for later execution:",
+			controlRequest{Action: controlActionAdd, NewCommand: &newCommand})
+	}
+}
+
+// adds a new command from the add subcommand, the daemon does this for the client when it runs
+func addCommandFromCommandLine(newCommand CommandWithArguments) (string, error) {
+	if !filepath.IsAbs(newCommand.AbsolutePath) {
+		return "", fmt.Errorf("path of command must be absolute, but is %q", newCommand.AbsolutePath)
+	}
+	if _, err := buildConditionsForCommand(newCommand); err != nil {
+		return "", fmt.Errorf("invalid conditions: %v", err)
+	}
+	newCommand.AddedFromCommandLine = true
+
+	// adding would silently replace the other command
+	if _, err := findCommandInCommandStoreByName(newCommand.Name); err == nil {
+		return "", fmt.Errorf("there already is a command named %q, choose another one with --name", newCommand.Name)
+	} else if !errors.Is(err, errCommandNotFound) {
+		return "", err
+	}
+
+	if _, err := addCommandToCommandStore(newCommand); err != nil {
+		return "", err
+	}
+	return "Successfully added, it will be executed later.", nil
+}
+
+// removes a command added with the add subcommand, the daemon does this for the client when it runs
+func removeCommandAddedFromCommandLine(commandName string) (string, error) {
+	command, err := findCommandInCommandStoreByName(commandName)
+	if err != nil {
+		return "", err
+	}
+	// the daemon would add it again from its config file when it starts
+	if !command.AddedFromCommandLine {
+		return "", fmt.Errorf("command %q comes from a config file, delete its config file instead", commandName)
+	}
+	if err := removeCommandFromCommandStoreByName(commandName); err != nil {
+		return "", err
+	}
+	return "Removed command " + commandName, nil
+}
+
+// lets the daemon, or the client itself without daemon, do what the request asks for and prints the outcome
+func exitCodeOfControlRequest(messageBeforeError string, request controlRequest) int {
+	response, err := performControlRequest(request)
+	if err == nil {
+		err = response.err()
+	}
+	if err != nil {
+		return exitCodeOfError(messageBeforeError, err)
+	}
+	fmt.Println(response.Message)
+	return exitCodeSuccess
+}
+
+func defineFlagsOfLogs(flagSet *flag.FlagSet) func(arguments []string) int {
+	follow := flagSet.Bool("follow", false, "show the output of the current run of a running command until it ends")
+
+	return func(arguments []string) int {
+		if *follow {
+			if len(arguments) != 1 {
+				fmt.Fprintln(os.Stderr, "--follow always follows the current run, a run id can't be given.")
+				return exitCodeUsage
+			}
+			return exitCodeOfError("Error when following logs:", followLogsOfCommand(arguments[0]))
+		}
+
+		runIDPrefix := ""
+		if len(arguments) == 2 {
+			runIDPrefix = arguments[1]
+		}
+		return exitCodeOfError("Error when showing logs:", printRunLogs(arguments[0], runIDPrefix))
+	}
+}
+
+func defineFlagsOfStatus(flagSet *flag.FlagSet) func(arguments []string) int {
+	outputFormat := flagSet.String("output", outputFormatTable, "output format: table, json or yaml")
+	asJSON := flagSet.Bool("json", false, "short for --output=json")
+
+	return func(arguments []string) int {
+		if *asJSON {
+			*outputFormat = outputFormatJSON
+		}
+		if *outputFormat != outputFormatTable && *outputFormat != outputFormatJSON && *outputFormat != outputFormatYAML {
+			fmt.Fprintf(os.Stderr, "Unknown output format %q, known are table, json and yaml.\n", *outputFormat)
+			return exitCodeUsage
+		}
+		response, err := performControlRequest(controlRequest{Action: controlActionStatus, CommandNames: arguments})
+		if err == nil {
+			err = response.err()
+		}
+		if err == nil {
+			err = printStatuses(response.Statuses, *outputFormat)
+		}
+		return exitCodeOfError("Error when showing status:", err)
+	}
+}
+
+func showCommand(commandName string) int {
+	command, err := findCommandInCommandStoreByName(commandName)
+	if err != nil {
+		return exitCodeOfError("Error when showing command:", err)
+	}
+
+	printField := func(name string, value interface{}) {
+		fmt.Printf("%-24v %v\n", name+":", value)
+	}
+	printField("Name", command.Name)
+	printField("UUID", command.UUID)
+	printField("Absolute path", command.AbsolutePath)
+	printField("Arguments", fmt.Sprintf("%q", command.CommandArguments))
+	printField("State", command.State)
+	printField("Disabled", command.Disabled)
+	printField("Run requested", command.RunRequested)
+	printField("Added from command line", command.AddedFromCommandLine)
+	printField("Added at", command.AddedAt.Format(time.RFC3339))
+	if command.LastRun.IsZero() {
+		printField("Last successful run", "never")
+	} else {
+		printField("Last successful run", command.LastRun.Format(time.RFC3339))
+	}
+	printField("Duration between runs", command.DurationBetweenRuns)
+	printField("Cron schedule", command.CronSchedule)
+	printField("OnCalendar", command.OnCalendar)
+	printField("Allowed windows", len(command.AllowedWindows))
+	printField("Blocked windows", len(command.BlockedWindows))
+	printField("Expected duration", command.ExpectedDuration)
+	printField("Required conditions", requiredConditionNamesOfCommand(command))
+	printField("On condition lost", command.OnConditionLost)
+	printField("Failed attempts", command.FailedAttempts)
+	if !command.NextAttemptAt.IsZero() {
+		printField("Next attempt at", command.NextAttemptAt.Format(time.RFC3339))
+	}
+	return exitCodeSuccess
+}
+
+// checks the given config files, or all in the working directory, without adding them to the command store
+func validateConfigFiles(pathsOfConfigFiles []string) int {
+	if len(pathsOfConfigFiles) == 0 {
+		configFileNames, err := getConfigFilesToRead()
+		if err != nil {
+			return exitCodeOfError("Couldn't determine what individual config files to read:", err)
+		}
+		for _, currentConfigFileName := range configFileNames {
+			pathsOfConfigFiles = append(pathsOfConfigFiles, filepath.Join(configFilesDirectory, currentConfigFileName))
+		}
+	}
+
+	numberOfInvalidConfigFiles := 0
+	for _, currentPath := range pathsOfConfigFiles {
+		if _, err := commandFromConfigFile(currentPath); err != nil {
+			fmt.Println(err)
+			numberOfInvalidConfigFiles++
+			continue
+		}
+		fmt.Println("Config", currentPath, "is valid")
+	}
+
+	if numberOfInvalidConfigFiles > 0 {
+		fmt.Printf("%v of %v config files are invalid\n", numberOfInvalidConfigFiles, len(pathsOfConfigFiles))
+		return exitCodeInvalidConfig
+	}
+	return exitCodeSuccess
+}
@@ -0,0 +1,237 @@
+package main
+
+// Conditions that have to be met before a command is started, e.g. being plugged into external power
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Condition is something about the state of the device that has to be true before a command may be started
+type Condition interface {
+	// Name is the identifier of the condition that is used to require it for a command, e.g. "ac_power"
+	Name() string
+	// Evaluate checks the condition right now and explains why it is not met, if that is the case
+	Evaluate() ConditionResult
+}
+
+// ConditionResult is the outcome of evaluating a condition once
+type ConditionResult struct {
+	ConditionName string
+	Met           bool
+	// human readable reason why the condition is not met, empty when it is met
+	ExplanationWhyUnmet string
+}
+
+// ConditionParameters are the settings of one condition for one command, as specified in its config file,
+// e.g. which host to probe for network connectivity
+type ConditionParameters map[string]interface{}
+
+// creates a new instance of a condition with the parameters of one command,
+// called once per command that requires the condition
+type conditionConstructor func(parameters ConditionParameters) (Condition, error)
+
+// all conditions that commands can require, by their name
+// conditions add themselves here in an init function of their file
+var registeredConditions = make(map[string]conditionConstructor)
+
+// conditions a command requires when it does not specify any itself,
+// this was the only check before conditions were configurable
+var defaultRequiredConditions = []string{acPowerConditionName}
+
+func registerCondition(conditionName string, constructor conditionConstructor) {
+	if _, alreadyRegistered := registeredConditions[conditionName]; alreadyRegistered {
+		// only happens when two files register the same name, which is a programming error
+		panic("condition registered twice: " + conditionName)
+	}
+	registeredConditions[conditionName] = constructor
+}
+
+// names of all conditions that can be required, sorted for stable output
+func namesOfRegisteredConditions() []string {
+	conditionNames := make([]string, 0, len(registeredConditions))
+	for conditionName := range registeredConditions {
+		conditionNames = append(conditionNames, conditionName)
+	}
+	sort.Strings(conditionNames)
+	return conditionNames
+}
+
+func requiredConditionNamesOfCommand(command CommandWithArguments) []string {
+	if len(command.RequiredConditions) == 0 {
+		return defaultRequiredConditions
+	}
+	return command.RequiredConditions
+}
+
+// creates all conditions required by a command, fails if one of them is not known
+// or its parameters are invalid
+func buildConditionsForCommand(command CommandWithArguments) ([]Condition, error) {
+	conditionNames := requiredConditionNamesOfCommand(command)
+
+	// parameters for a condition that is not required are most likely a typo in the config
+	for conditionName := range command.ConditionParameters {
+		if !isStringInSlice(conditionName, conditionNames) {
+			return []Condition{}, fmt.Errorf("parameters specified for condition %q, but it is not required", conditionName)
+		}
+	}
+
+	conditions := make([]Condition, 0, len(conditionNames))
+	for _, currentConditionName := range conditionNames {
+		constructor, found := registeredConditions[currentConditionName]
+		if !found {
+			return conditions, fmt.Errorf("unknown condition %q, known conditions are: %v", currentConditionName, namesOfRegisteredConditions())
+		}
+
+		condition, err := constructor(command.ConditionParameters[currentConditionName])
+		if err != nil {
+			return conditions, fmt.Errorf("could not set up condition %q: %v", currentConditionName, err)
+		}
+		conditions = append(conditions, condition)
+	}
+	return conditions, nil
+}
+
+// evaluates every condition, even after one was not met, so all reasons why a command
+// can not be run yet are known
+func evaluateConditions(conditions []Condition) (bool, []ConditionResult) {
+	allConditionsMet := true
+	results := make([]ConditionResult, 0, len(conditions))
+
+	for _, currentCondition := range conditions {
+		result := currentCondition.Evaluate()
+		// conditions don't have to fill in their name themselves
+		result.ConditionName = currentCondition.Name()
+		if !result.Met {
+			allConditionsMet = false
+		}
+		results = append(results, result)
+	}
+	return allConditionsMet, results
+}
+
+// evaluatedConditionResults are the results of the conditions of a command and when they were evaluated
+type evaluatedConditionResults struct {
+	Results     []ConditionResult
+	EvaluatedAt time.Time
+}
+
+// the results of the conditions of each command when the daemon evaluated them last, so status, list
+// and the metrics can show them without probing the network or asking D-Bus again
+var lastConditionResults = struct {
+	sync.Mutex
+	byCommandUUID map[uuid.UUID]evaluatedConditionResults
+}{byCommandUUID: make(map[uuid.UUID]evaluatedConditionResults)}
+
+func rememberConditionResults(uuidOfCommand uuid.UUID, results []ConditionResult) {
+	lastConditionResults.Lock()
+	defer lastConditionResults.Unlock()
+	lastConditionResults.byCommandUUID[uuidOfCommand] = evaluatedConditionResults{Results: results, EvaluatedAt: time.Now()}
+}
+
+// the results of the conditions of a command when they were evaluated last, not found
+// if they were never evaluated in this process, e.g. in the client when no daemon runs
+func lastConditionResultsOfCommand(uuidOfCommand uuid.UUID) (evaluatedConditionResults, bool) {
+	lastConditionResults.Lock()
+	defer lastConditionResults.Unlock()
+	evaluatedResults, found := lastConditionResults.byCommandUUID[uuidOfCommand]
+	return evaluatedResults, found
+}
+
+// fails if the parameters contain a key the condition does not know, so typos in configs are noticed
+func (parameters ConditionParameters) ensureOnlyKnownKeys(knownKeys ...string) error {
+	for key := range parameters {
+		if !isStringInSlice(key, knownKeys) {
+			return fmt.Errorf("unknown parameter %q, known parameters are: %v", key, knownKeys)
+		}
+	}
+	return nil
+}
+
+func (parameters ConditionParameters) stringValue(key string, defaultValue string) (string, error) {
+	value, found := parameters[key]
+	if !found {
+		return defaultValue, nil
+	}
+	stringValue, isString := value.(string)
+	if !isString {
+		return defaultValue, fmt.Errorf("parameter %q must be a string, but is %v", key, value)
+	}
+	return stringValue, nil
+}
+
+func (parameters ConditionParameters) boolValue(key string, defaultValue bool) (bool, error) {
+	value, found := parameters[key]
+	if !found {
+		return defaultValue, nil
+	}
+	boolValue, isBool := value.(bool)
+	if !isBool {
+		return defaultValue, fmt.Errorf("parameter %q must be true or false, but is %v", key, value)
+	}
+	return boolValue, nil
+}
+
+// numbers are int64 or float64 when coming from a toml config, but always float64
+// when coming from the json command store
+func (parameters ConditionParameters) numberValue(key string, defaultValue float64) (float64, error) {
+	value, found := parameters[key]
+	if !found {
+		return defaultValue, nil
+	}
+	switch number := value.(type) {
+	case int64:
+		return float64(number), nil
+	case int:
+		return float64(number), nil
+	case float64:
+		return number, nil
+	default:
+		return defaultValue, fmt.Errorf("parameter %q must be a number, but is %v", key, value)
+	}
+}
+
+// durations are written as strings like "1h30m" in configs
+func (parameters ConditionParameters) durationValue(key string, defaultValue time.Duration) (time.Duration, error) {
+	value, found := parameters[key]
+	if !found {
+		return defaultValue, nil
+	}
+	durationString, isString := value.(string)
+	if !isString {
+		return defaultValue, fmt.Errorf("parameter %q must be a duration like \"1h30m\", but is %v", key, value)
+	}
+	duration, err := time.ParseDuration(durationString)
+	if err != nil {
+		return defaultValue, fmt.Errorf("parameter %q is not a valid duration: %v", key, err)
+	}
+	return duration, nil
+}
+
+// lists are []interface{} after unmarshalling, even if they only contain strings
+func (parameters ConditionParameters) stringListValue(key string) ([]string, error) {
+	value, found := parameters[key]
+	if !found {
+		return []string{}, nil
+	}
+	switch list := value.(type) {
+	case []string:
+		return list, nil
+	case []interface{}:
+		stringList := make([]string, 0, len(list))
+		for _, currentElement := range list {
+			stringElement, isString := currentElement.(string)
+			if !isString {
+				return []string{}, fmt.Errorf("parameter %q must only contain strings, but contains %v", key, currentElement)
+			}
+			stringList = append(stringList, stringElement)
+		}
+		return stringList, nil
+	default:
+		return []string{}, fmt.Errorf("parameter %q must be a list of strings, but is %v", key, value)
+	}
+}
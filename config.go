@@ -14,11 +14,36 @@ import (
 
 const configFilesDirectory = "./"
 
-// Config contains a command with arguments and how often to execute it
+// Config contains a command with arguments, how often to execute it and which conditions have to be met for that
 type Config struct {
 	AbsolutePath        string
 	Arguments           string
 	DurationBetweenRuns time.Duration
+	// names of the conditions that have to be met before the command is started, e.g. ["ac_power", "network"]
+	Requires []string `toml:"requires"`
+	// parameters of the required conditions by condition name, e.g. [conditions.network]
+	Conditions map[string]ConditionParameters `toml:"conditions"`
+	// what happens when a condition is not met anymore while the command is running:
+	// "continue" (default), "pause", "terminate" or "kill"
+	OnConditionLost string `toml:"on_condition_lost"`
+	// how long a terminated command may take to exit before it is killed
+	TerminateGracePeriod time.Duration `toml:"terminate_grace_period"`
+	// earliest times the command may run, as cron expression like "0 3 * * *"
+	// or systemd OnCalendar specification like "*-*-* 03:00:00"
+	CronSchedule string `toml:"cron_schedule"`
+	OnCalendar   string `toml:"on_calendar"`
+	// times the command may start in, e.g. [[allowed_windows]] with start = "22:00" and end = "06:00"
+	AllowedWindows []TimeWindow `toml:"allowed_windows"`
+	// times the command must not run in, e.g. [[blocked_windows]] on days = ["Mon..Fri"] from "09:00" to "17:00"
+	BlockedWindows []TimeWindow `toml:"blocked_windows"`
+	// how long a run usually takes, so it is not started too late to finish in its time window
+	ExpectedDuration time.Duration `toml:"expected_duration"`
+	// how failed runs are retried, e.g. [retry] with max_attempts = 5
+	Retry RetryPolicy `toml:"retry"`
+	// how many past runs are kept, e.g. [history] with keep_runs = 50 and keep_for = "720h"
+	History HistoryRetention `toml:"history"`
+	// how the output of runs is stored, e.g. [output] with separate_stderr = true and max_size = 1048576
+	Output OutputSettings `toml:"output"`
 }
 
 func getConfigFromFile(pathToConfigFile string) (Config, error) {
@@ -52,43 +77,100 @@ func getConfigFilesToRead() ([]string, error) {
 	return fileNames, err
 }
 
-// TODO what to do with entries which were running when program was closed?
+// reads a config file and checks everything in it, so mistakes are noticed now and not only when the command should be run
+func commandFromConfigFile(pathToConfigFile string) (CommandWithArguments, error) {
+	configFileName := filepath.Base(pathToConfigFile)
+
+	config, err := getConfigFromFile(pathToConfigFile)
+	if err != nil {
+		return CommandWithArguments{}, fmt.Errorf("Error when reading config %v: %v", configFileName, err)
+	}
+
+	// containing file name without file extension (last dot and following)
+	commandName := strings.TrimSuffix(configFileName, filepath.Ext(configFileName))
+
+	onConditionLost, policyError := parseConditionLostPolicy(config.OnConditionLost)
+	if policyError != nil {
+		return CommandWithArguments{}, fmt.Errorf("Error in config %v: %v", configFileName, policyError)
+	}
+
+	newCommand := CommandWithArguments{
+		Name:                 commandName,
+		AbsolutePath:         config.AbsolutePath,
+		CommandArguments:     []string{config.Arguments},
+		DurationBetweenRuns:  config.DurationBetweenRuns,
+		RequiredConditions:   config.Requires,
+		ConditionParameters:  config.Conditions,
+		OnConditionLost:      onConditionLost,
+		TerminateGracePeriod: config.TerminateGracePeriod,
+		CronSchedule:         config.CronSchedule,
+		OnCalendar:           config.OnCalendar,
+		AllowedWindows:       config.AllowedWindows,
+		BlockedWindows:       config.BlockedWindows,
+		ExpectedDuration:     config.ExpectedDuration,
+		RetryPolicy:          config.Retry,
+		HistoryRetention:     config.History,
+		OutputSettings:       config.Output,
+	}
+
+	// searched from now, like for a command added right now, from the zero time a schedule of a year
+	// like "2027-*-* 03:00" would be too far away to ever be found
+	commandAddedNow := newCommand
+	commandAddedNow.AddedAt = time.Now()
+	if _, scheduleError := earliestScheduledTimeOfCommand(commandAddedNow); scheduleError != nil {
+		return newCommand, fmt.Errorf("Error in schedule of config %v: %v", configFileName, scheduleError)
+	}
+	if _, _, windowsError := isStartAllowedByTimeWindows(newCommand, time.Now()); windowsError != nil {
+		return newCommand, fmt.Errorf("Error in time windows of config %v: %v", configFileName, windowsError)
+	}
+	if retryError := newCommand.RetryPolicy.validate(); retryError != nil {
+		return newCommand, fmt.Errorf("Error in config %v: %v", configFileName, retryError)
+	}
+	if retentionError := newCommand.HistoryRetention.validate(); retentionError != nil {
+		return newCommand, fmt.Errorf("Error in config %v: %v", configFileName, retentionError)
+	}
+	if outputError := newCommand.OutputSettings.validate(); outputError != nil {
+		return newCommand, fmt.Errorf("Error in config %v: %v", configFileName, outputError)
+	}
+	if _, conditionsError := buildConditionsForCommand(newCommand); conditionsError != nil {
+		return newCommand, fmt.Errorf("Error in conditions of config %v: %v", configFileName, conditionsError)
+	}
+
+	return newCommand, nil
+}
 
 func parseAllConfigFiles() {
-	fmt.Println("Reading configs...")
+	logInfo("Reading configs...")
 
 	addedCommandNames := make([]string, 0)
 
 	configFileNames, err := getConfigFilesToRead()
 	if err != nil {
-		fmt.Println("Couldn't determine what individual config files to read: ", err)
+		logError("Couldn't determine what individual config files to read", errorLogField(err))
 		return
 	}
 	for _, currentConfigFileName := range configFileNames {
-		config, err := getConfigFromFile(currentConfigFileName)
+		newCommand, err := commandFromConfigFile(currentConfigFileName)
 		if err != nil {
-			fmt.Println("Error when reading config ", currentConfigFileName, " :", err)
+			logError("Couldn't read config file", logFieldOf("file", currentConfigFileName), errorLogField(err))
 			continue
 		}
+		absolutePath := newCommand.AbsolutePath
+		arguments := newCommand.CommandArguments
+		commandName := newCommand.Name
 
-		absolutePath := config.AbsolutePath
-		arguments := config.Arguments
-		durationBetweenRuns := config.DurationBetweenRuns
-		// containing file name without file extension (last dot and following)
-		commandName := strings.TrimSuffix(currentConfigFileName, filepath.Ext(currentConfigFileName))
-
-		hasUpdatedCommandInCommandStore, addError := addCommandToCommandStore(absolutePath, []string{arguments}, durationBetweenRuns, commandName)
+		hasUpdatedCommandInCommandStore, addError := addCommandToCommandStore(newCommand)
 
 		if addError != nil {
-			fmt.Println("Couldn't add command:", absolutePath, "with arguments ", arguments, "because:", addError)
+			logError("Couldn't add command", append(commandLogFields(newCommand), logFieldOf("path", absolutePath), logFieldOf("arguments", strings.Join(arguments, " ")), errorLogField(addError))...)
 		} else {
 			// check if we updated the details of a command we have already added from another config file
 			// in this method and in this startup of the program and not only that of the command store
 			// that represents the state of the previous run (which happens every time the program is restarted
 			// without a config file change)
 			if hasUpdatedCommandInCommandStore && isStringInSlice(currentConfigFileName, addedCommandNames) {
-				fmt.Println("Updated/overwrote details of command that was already added from other config file with the same name.",
-					"Filenames must be unique! Affected name:", currentConfigFileName)
+				logWarning("Updated/overwrote details of command that was already added from other config file with the same name. Filenames must be unique!",
+					logFieldOf("file", currentConfigFileName))
 			}
 			addedCommandNames = append(addedCommandNames, commandName)
 		}
@@ -96,7 +178,7 @@ func parseAllConfigFiles() {
 
 	err = removeOldCommandsFromCommandStore(addedCommandNames)
 	if err != nil {
-		fmt.Println("Error when removing old commands from command store, that are not present anymore in any config file. Error: ", err)
+		logError("Error when removing old commands from command store, that are not present anymore in any config file", errorLogField(err))
 	}
 }
 
@@ -108,6 +190,10 @@ func removeOldCommandsFromCommandStore(commandNamesToKeep []string) error {
 	}
 
 	for _, currentCommand := range commandStore.Commands {
+		// commands added on the command line have no config file
+		if currentCommand.AddedFromCommandLine {
+			continue
+		}
 		if !isStringInSlice(currentCommand.Name, commandNamesToKeep) {
 			removeError := removeCommandFromCommandStoreByName(currentCommand.Name)
 			if removeError != nil {
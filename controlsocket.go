@@ -0,0 +1,312 @@
+package main
+
+// The daemon listens on a Unix socket next to the command store, so the command line client
+// can ask it to change commands and to do things only the running daemon can, like pausing a
+// running command or following its live output. Without a daemon, the client changes the
+// command store itself.
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"syscall"
+)
+
+var pathToControlSocket = "./workScheduler.sock"
+
+// requests are one JSON object per line
+type controlRequest struct {
+	Action      string
+	CommandName string
+	// for the status of several commands, empty means all
+	CommandNames []string
+	// for adding a command
+	NewCommand *CommandWithArguments
+}
+
+// every request gets one JSON line as response, depending on the action followed by more data
+type controlResponse struct {
+	// empty if the request succeeded
+	Error string
+	// the error is that there is no command with the given name
+	CommandNotFound bool
+	// what was done, for the user
+	Message string
+	// for the status action
+	Statuses []commandStatus
+	// for the read actions
+	Commands []CommandWithArguments
+	Runs     []RunRecord
+}
+
+// actions of requests
+const (
+	controlActionAdd     = "add"
+	controlActionRemove  = "remove"
+	controlActionRunNow  = "run_now"
+	controlActionEnable  = "enable"
+	controlActionDisable = "disable"
+	controlActionPause   = "pause"
+	controlActionResume  = "resume"
+	controlActionStatus  = "status"
+	// response is followed by the output of the current run of the command until it ends
+	controlActionFollowLogs = "follow_logs"
+	// the daemon keeps the database open, so the client reads the commands and runs through it
+	controlActionReadCommands = "read_commands"
+	controlActionReadRuns     = "read_runs"
+)
+
+// errDaemonNotRunning means nobody listens on the control socket
+var errDaemonNotRunning = errors.New("the daemon is not running in this directory")
+
+// controlError is an error the other side of the control socket sent
+type controlError struct {
+	message         string
+	commandNotFound bool
+}
+
+func (err controlError) Error() string {
+	return err.message
+}
+
+// so errors.Is finds errCommandNotFound like in errors from the command store
+func (err controlError) Is(target error) bool {
+	return target == errCommandNotFound && err.commandNotFound
+}
+
+func (response controlResponse) err() error {
+	if response.Error == "" {
+		return nil
+	}
+	return controlError{message: response.Error, commandNotFound: response.CommandNotFound}
+}
+
+func controlResponseOfError(err error) controlResponse {
+	return controlResponse{Error: err.Error(), CommandNotFound: errors.Is(err, errCommandNotFound)}
+}
+
+// listens for requests of clients in the background
+func startControlSocket() error {
+	// a socket file of a daemon that did not shut down cleanly is in the way,
+	// but if a daemon answers on it, it is still running
+	if existingConnection, err := net.Dial("unix", pathToControlSocket); err == nil {
+		existingConnection.Close()
+		return fmt.Errorf("another daemon is already listening on %v", pathToControlSocket)
+	}
+	if err := os.Remove(pathToControlSocket); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	listener, err := net.Listen("unix", pathToControlSocket)
+	if err != nil {
+		return err
+	}
+	// only our own user may control the daemon
+	if err := os.Chmod(pathToControlSocket, 0600); err != nil {
+		listener.Close()
+		return err
+	}
+
+	go func() {
+		for {
+			connection, err := listener.Accept()
+			if err != nil {
+				logError("Error when accepting connection on control socket, not accepting any more", errorLogField(err))
+				return
+			}
+			go handleControlConnection(connection)
+		}
+	}()
+	return nil
+}
+
+func handleControlConnection(connection net.Conn) {
+	defer connection.Close()
+
+	// the permissions of the socket file should already keep others out, but they depend on the directory
+	if err := checkPeerCredentials(connection); err != nil {
+		logWarning("Rejected connection on control socket", errorLogField(err))
+		writeControlResponse(connection, controlResponseOfError(err))
+		return
+	}
+
+	var request controlRequest
+	if err := json.NewDecoder(connection).Decode(&request); err != nil {
+		writeControlResponse(connection, controlResponseOfError(fmt.Errorf("invalid request: %v", err)))
+		return
+	}
+
+	if request.Action != controlActionFollowLogs {
+		writeControlResponse(connection, executeControlRequest(request, true))
+		return
+	}
+
+	follower, live, err := followOutputOfCommand(request.CommandName)
+	if err != nil {
+		writeControlResponse(connection, controlResponseOfError(err))
+		return
+	}
+	defer live.unfollow(follower)
+	if err := writeControlResponse(connection, controlResponse{}); err != nil {
+		return
+	}
+	if err := follower.copyOutputTo(connection); err != nil {
+		fmt.Fprintln(connection)
+		fmt.Fprintln(connection, "======== Error when following output:", err, "========")
+	}
+}
+
+// does what a request asks for, the daemon does it for requests from the control socket and the
+// client does it itself if there is no daemon, actions on running commands need the daemon
+func executeControlRequest(request controlRequest, isDaemon bool) controlResponse {
+	var message string
+	var err error
+
+	switch request.Action {
+	case controlActionAdd:
+		if request.NewCommand == nil {
+			err = fmt.Errorf("no command to add in request")
+			break
+		}
+		message, err = addCommandFromCommandLine(*request.NewCommand)
+	case controlActionRemove:
+		message, err = removeCommandAddedFromCommandLine(request.CommandName)
+	case controlActionRunNow:
+		message = "Requested a run of command " + request.CommandName
+		err = modifyCommandInCommandStoreByName(request.CommandName, func(command *CommandWithArguments) {
+			command.RunRequested = true
+		})
+	case controlActionEnable:
+		message = "Enabled command " + request.CommandName
+		err = modifyCommandInCommandStoreByName(request.CommandName, func(command *CommandWithArguments) {
+			command.Disabled = false
+			resetRetriesOfCommand(command)
+		})
+	case controlActionDisable:
+		message = "Disabled command " + request.CommandName
+		err = modifyCommandInCommandStoreByName(request.CommandName, func(command *CommandWithArguments) {
+			command.Disabled = true
+		})
+	case controlActionPause, controlActionResume:
+		if !isDaemon {
+			err = fmt.Errorf("only the running daemon can pause and resume commands: %v", errDaemonNotRunning)
+			break
+		}
+		var command CommandWithArguments
+		if command, err = findCommandInCommandStoreByName(request.CommandName); err != nil {
+			break
+		}
+		if request.Action == controlActionPause {
+			message = "Paused command " + request.CommandName
+			err = pauseJobOfCommandByUser(command)
+		} else {
+			message = "Resumed command " + request.CommandName
+			err = resumeJobOfCommandByUser(command)
+		}
+	case controlActionStatus:
+		var statuses []commandStatus
+		if statuses, err = statusesOfCommands(request.CommandNames); err == nil {
+			return controlResponse{Statuses: statuses}
+		}
+	case controlActionReadCommands:
+		var commandStore CommandStore
+		if commandStore, err = readAndParseCommandStore(); err == nil {
+			return controlResponse{Commands: commandStore.Commands}
+		}
+	case controlActionReadRuns:
+		var runs []RunRecord
+		if runs, err = activeStore.RunsOfCommand(request.CommandName); err == nil {
+			return controlResponse{Runs: runs}
+		}
+	default:
+		err = fmt.Errorf("unknown action %q", request.Action)
+	}
+
+	if err != nil {
+		return controlResponseOfError(err)
+	}
+
+	// the command might be able to start right away, so don't wait until the daemon checks anyway
+	if isDaemon && (request.Action == controlActionAdd || request.Action == controlActionRunNow || request.Action == controlActionEnable) {
+		wakeUpDaemon()
+	}
+	return controlResponse{Message: message}
+}
+
+func writeControlResponse(connection net.Conn, response controlResponse) error {
+	return json.NewEncoder(connection).Encode(response)
+}
+
+// sends a request to the daemon, the returned reader has the data after the response
+func sendControlRequest(request controlRequest) (net.Conn, *bufio.Reader, controlResponse, error) {
+	connection, err := net.Dial("unix", pathToControlSocket)
+	if err != nil {
+		// no socket file or nobody listening on it
+		if errors.Is(err, syscall.ENOENT) || errors.Is(err, syscall.ECONNREFUSED) {
+			return nil, nil, controlResponse{}, errDaemonNotRunning
+		}
+		return nil, nil, controlResponse{}, fmt.Errorf("could not reach the daemon: %v", err)
+	}
+	if err := json.NewEncoder(connection).Encode(request); err != nil {
+		connection.Close()
+		return nil, nil, controlResponse{}, err
+	}
+
+	reader := bufio.NewReader(connection)
+	responseLine, err := reader.ReadBytes('\n')
+	if err != nil {
+		connection.Close()
+		return nil, nil, controlResponse{}, fmt.Errorf("no response from the daemon: %v", err)
+	}
+	var response controlResponse
+	if err := json.Unmarshal(responseLine, &response); err != nil {
+		connection.Close()
+		return nil, nil, controlResponse{}, fmt.Errorf("invalid response from the daemon: %v", err)
+	}
+	return connection, reader, response, nil
+}
+
+// lets the daemon do what the request asks for, or does it without the daemon if it is not running
+func performControlRequest(request controlRequest) (controlResponse, error) {
+	connection, _, response, err := sendControlRequest(request)
+	if errors.Is(err, errDaemonNotRunning) {
+		return executeControlRequest(request, false), nil
+	}
+	if err != nil {
+		return response, err
+	}
+	connection.Close()
+	return response, nil
+}
+
+// only lets the daemon do what the request asks for, errDaemonNotRunning if there is none
+func askDaemon(request controlRequest) (controlResponse, error) {
+	connection, _, response, err := sendControlRequest(request)
+	if err != nil {
+		return response, err
+	}
+	connection.Close()
+	return response, response.err()
+}
+
+// prints the output of the current run of a command from its beginning until it ends
+func followLogsOfCommand(commandName string) error {
+	connection, reader, response, err := sendControlRequest(controlRequest{Action: controlActionFollowLogs, CommandName: commandName})
+	if errors.Is(err, errDaemonNotRunning) {
+		return fmt.Errorf("only the running daemon knows the output of running commands: %v", err)
+	}
+	if err != nil {
+		return err
+	}
+	defer connection.Close()
+	if err := response.err(); err != nil {
+		return err
+	}
+
+	_, err = io.Copy(os.Stdout, reader)
+	return err
+}
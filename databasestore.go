@@ -0,0 +1,459 @@
+package main
+
+// The command store and the run history in an embedded bbolt database, a change only writes
+// the commands and runs it changed in a transaction instead of rewriting everything
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	bolt "go.etcd.io/bbolt"
+)
+
+var pathToCommandDatabaseFile = "./commandStore.db"
+
+// one JSON value per command, keyed by a sequence number so the commands keep the order they were added in
+var commandsBucketName = []byte("commands")
+
+// one bucket per command name with one JSON value per run, keyed by a sequence number so the runs
+// keep the order they were added in and the retention only has to look at the runs of one command
+var runsBucketName = []byte("runs")
+
+// bbolt lets only one process open the database for changes, everyone else waits this long for it
+const databaseOpenTimeout = 30 * time.Second
+
+// databaseStore is the default store
+type databaseStore struct {
+	pathToDatabaseFile string
+	// commands and runs of these JSON files are moved into the database before it is used the first time
+	pathToJSONCommandStoreFile string
+	pathToJSONRunHistoryFile   string
+
+	// protects the fields below
+	mutex sync.Mutex
+	// a failed migration is tried again with the next use of the store
+	migrated bool
+	// kept open by the daemon, nil in the client, which opens the database for every read or change
+	// when there is no daemon and otherwise asks the daemon
+	database *bolt.DB
+}
+
+// opens the database until the daemon exits instead of opening it for every read and change,
+// which keeps every other process out of it, their reads go through the daemon
+func (store *databaseStore) keepOpen() error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	database, err := bolt.Open(store.pathToDatabaseFile, 0600, &bolt.Options{Timeout: databaseOpenTimeout})
+	if err != nil {
+		return err
+	}
+	store.database = database
+	return nil
+}
+
+// runs use with the database the daemon keeps open, or opens it just for use in the client,
+// the database file is created by the first change, until then reads find nothing
+func (store *databaseStore) withDatabase(readOnly bool, use func(database *bolt.DB) error) error {
+	store.mutex.Lock()
+	database := store.database
+	// until the migration worked, the commands in the database are not complete
+	if !store.migrated {
+		var err error
+		if database != nil {
+			err = store.migrateFromJSONFiles(database)
+		} else {
+			err = store.migrateInOwnDatabase()
+		}
+		if err != nil {
+			store.mutex.Unlock()
+			return err
+		}
+		store.migrated = true
+	}
+	store.mutex.Unlock()
+
+	if database != nil {
+		return use(database)
+	}
+
+	// bbolt would create it and fail to write its header into the file opened read only
+	if readOnly {
+		if _, err := os.Stat(store.pathToDatabaseFile); err != nil {
+			return err
+		}
+	}
+	database, err := bolt.Open(store.pathToDatabaseFile, 0600, &bolt.Options{Timeout: databaseOpenTimeout, ReadOnly: readOnly})
+	if err != nil {
+		return err
+	}
+	defer database.Close()
+	return use(database)
+}
+
+// only the daemon keeps the database open, the client has to ask the daemon when one runs
+func (store *databaseStore) isKeptOpen() bool {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	return store.database != nil
+}
+
+func (store *databaseStore) Read() (CommandStore, error) {
+	commandStore := CommandStore{}
+
+	if !store.isKeptOpen() {
+		response, err := askDaemon(controlRequest{Action: controlActionReadCommands})
+		if !errors.Is(err, errDaemonNotRunning) {
+			commandStore.Commands = response.Commands
+			return commandStore, err
+		}
+	}
+
+	err := store.withDatabase(true, func(database *bolt.DB) error {
+		return database.View(func(transaction *bolt.Tx) error {
+			bucket := transaction.Bucket(commandsBucketName)
+			if bucket == nil {
+				return nil
+			}
+			return bucket.ForEach(func(key []byte, value []byte) error {
+				command := CommandWithArguments{}
+				if err := json.Unmarshal(value, &command); err != nil {
+					return fmt.Errorf("command with key %x in %v is corrupted: %w", key, store.pathToDatabaseFile, err)
+				}
+				commandStore.Commands = append(commandStore.Commands, command)
+				return nil
+			})
+		})
+	})
+	// nothing was stored yet
+	if os.IsNotExist(err) {
+		return commandStore, nil
+	}
+	if err != nil {
+		countStoreError("command_store", "read")
+	}
+	return commandStore, err
+}
+
+func (store *databaseStore) Update(change func(commandStore *CommandStore) error) error {
+	// errors of change are not errors of the store
+	var changeError error
+	err := store.withDatabase(false, func(database *bolt.DB) error {
+		return database.Update(func(transaction *bolt.Tx) error {
+			bucket, err := transaction.CreateBucketIfNotExists(commandsBucketName)
+			if err != nil {
+				return err
+			}
+
+			commandStore := CommandStore{}
+			// key and stored value of every command, so only changed commands are written again
+			keysByUUID := make(map[uuid.UUID][]byte)
+			storedValuesByUUID := make(map[uuid.UUID][]byte)
+			err = bucket.ForEach(func(key []byte, value []byte) error {
+				command := CommandWithArguments{}
+				if err := json.Unmarshal(value, &command); err != nil {
+					return fmt.Errorf("command with key %x in %v is corrupted: %w", key, store.pathToDatabaseFile, err)
+				}
+				commandStore.Commands = append(commandStore.Commands, command)
+				// only valid during the transaction and changed by writes to the bucket
+				keysByUUID[command.UUID] = append([]byte{}, key...)
+				storedValuesByUUID[command.UUID] = append([]byte{}, value...)
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+
+			if changeError = change(&commandStore); changeError != nil {
+				return changeError
+			}
+
+			for _, currentCommand := range commandStore.Commands {
+				marshalledCommand, err := json.Marshal(&currentCommand)
+				if err != nil {
+					return err
+				}
+				key, found := keysByUUID[currentCommand.UUID]
+				if found {
+					delete(keysByUUID, currentCommand.UUID)
+					if bytes.Equal(marshalledCommand, storedValuesByUUID[currentCommand.UUID]) {
+						continue
+					}
+				} else {
+					if key, err = newSequenceKey(bucket); err != nil {
+						return err
+					}
+				}
+				if err := bucket.Put(key, marshalledCommand); err != nil {
+					return err
+				}
+			}
+
+			// the commands that are left were removed
+			for _, key := range keysByUUID {
+				if err := bucket.Delete(key); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	})
+	if err != nil && changeError == nil {
+		countStoreError("command_store", "write")
+	}
+	return err
+}
+
+// only the new run and the runs removed by the retention are written
+func (store *databaseStore) AddRun(run RunRecord, retention HistoryRetention) ([]RunRecord, error) {
+	var removedRuns []RunRecord
+	err := store.withDatabase(false, func(database *bolt.DB) error {
+		return database.Update(func(transaction *bolt.Tx) error {
+			bucket, err := bucketOfRunsOfCommand(transaction, run.CommandName)
+			if err != nil {
+				return err
+			}
+			if err := putRun(bucket, run); err != nil {
+				return err
+			}
+
+			runs := make([]RunRecord, 0)
+			keysByRunID := make(map[uuid.UUID][]byte)
+			err = bucket.ForEach(func(key []byte, value []byte) error {
+				currentRun := RunRecord{}
+				if err := json.Unmarshal(value, &currentRun); err != nil {
+					return fmt.Errorf("run with key %x of command %v in %v is corrupted: %w", key, run.CommandName, store.pathToDatabaseFile, err)
+				}
+				runs = append(runs, currentRun)
+				// only valid during the transaction and changed by writes to the bucket
+				keysByRunID[currentRun.RunID] = append([]byte{}, key...)
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+
+			_, removedRuns = applyHistoryRetention(runs, run.CommandName, retention, time.Now())
+			for _, currentRun := range removedRuns {
+				if err := bucket.Delete(keysByRunID[currentRun.RunID]); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		countStoreError("run_history", "write")
+		return nil, err
+	}
+	return removedRuns, nil
+}
+
+func (store *databaseStore) RunsOfCommand(commandName string) ([]RunRecord, error) {
+	runs := make([]RunRecord, 0)
+
+	if !store.isKeptOpen() {
+		response, err := askDaemon(controlRequest{Action: controlActionReadRuns, CommandName: commandName})
+		if !errors.Is(err, errDaemonNotRunning) {
+			return append(runs, response.Runs...), err
+		}
+	}
+
+	err := store.withDatabase(true, func(database *bolt.DB) error {
+		return database.View(func(transaction *bolt.Tx) error {
+			runsBucket := transaction.Bucket(runsBucketName)
+			if runsBucket == nil {
+				return nil
+			}
+			bucket := runsBucket.Bucket([]byte(commandName))
+			if bucket == nil {
+				return nil
+			}
+			return bucket.ForEach(func(key []byte, value []byte) error {
+				currentRun := RunRecord{}
+				if err := json.Unmarshal(value, &currentRun); err != nil {
+					return fmt.Errorf("run with key %x of command %v in %v is corrupted: %w", key, commandName, store.pathToDatabaseFile, err)
+				}
+				runs = append(runs, currentRun)
+				return nil
+			})
+		})
+	})
+	// nothing was stored yet
+	if os.IsNotExist(err) {
+		return runs, nil
+	}
+	if err != nil {
+		countStoreError("run_history", "read")
+	}
+	return runs, err
+}
+
+func bucketOfRunsOfCommand(transaction *bolt.Tx, commandName string) (*bolt.Bucket, error) {
+	runsBucket, err := transaction.CreateBucketIfNotExists(runsBucketName)
+	if err != nil {
+		return nil, err
+	}
+	return runsBucket.CreateBucketIfNotExists([]byte(commandName))
+}
+
+func putRun(bucket *bolt.Bucket, run RunRecord) error {
+	marshalledRun, err := json.Marshal(&run)
+	if err != nil {
+		return err
+	}
+	key, err := newSequenceKey(bucket)
+	if err != nil {
+		return err
+	}
+	return bucket.Put(key, marshalledRun)
+}
+
+// big endian, so the keys sort like the numbers
+func newSequenceKey(bucket *bolt.Bucket) ([]byte, error) {
+	sequenceNumber, err := bucket.NextSequence()
+	if err != nil {
+		return nil, err
+	}
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, sequenceNumber)
+	return key, nil
+}
+
+// the client only opens the database for the migration if there is something to migrate
+func (store *databaseStore) migrateInOwnDatabase() error {
+	if !doesFileExist(store.pathToJSONCommandStoreFile) && !doesFileExist(store.pathToJSONRunHistoryFile) {
+		return nil
+	}
+	database, err := bolt.Open(store.pathToDatabaseFile, 0600, &bolt.Options{Timeout: databaseOpenTimeout})
+	if err != nil {
+		return err
+	}
+	err = store.migrateFromJSONFiles(database)
+	if closeError := database.Close(); err == nil {
+		err = closeError
+	}
+	return err
+}
+
+func (store *databaseStore) migrateFromJSONFiles(database *bolt.DB) error {
+	if err := store.migrateFromJSONCommandStore(database); err != nil {
+		return err
+	}
+	return store.migrateFromJSONRunHistory(database)
+}
+
+// moves the commands of the JSON command store into the database once and renames the JSON file,
+// so it is not used anymore but still there if something went wrong
+func (store *databaseStore) migrateFromJSONCommandStore(database *bolt.DB) error {
+	var fileLockOnCommandStoreFile = lockOfFile(store.pathToJSONCommandStoreFile)
+
+	// another process might be migrating at the same time
+	fileLockOnCommandStoreFile.Lock()
+	defer fileLockOnCommandStoreFile.Unlock()
+
+	// nothing to migrate or already migrated
+	if !doesFileExist(store.pathToJSONCommandStoreFile) {
+		return nil
+	}
+	commandStore, err := readAndParseCommandStoreFromFile(store.pathToJSONCommandStoreFile, true)
+	if err != nil {
+		return fmt.Errorf("could not move commands from %v into %v: %w", store.pathToJSONCommandStoreFile, store.pathToDatabaseFile, err)
+	}
+
+	err = database.Update(func(transaction *bolt.Tx) error {
+		bucket, err := transaction.CreateBucketIfNotExists(commandsBucketName)
+		if err != nil {
+			return err
+		}
+		// an earlier migration stopped before it could rename the JSON file, the commands are already here
+		if firstKey, _ := bucket.Cursor().First(); firstKey != nil {
+			return nil
+		}
+		for _, currentCommand := range commandStore.Commands {
+			marshalledCommand, err := json.Marshal(&currentCommand)
+			if err != nil {
+				return err
+			}
+			key, err := newSequenceKey(bucket)
+			if err != nil {
+				return err
+			}
+			if err := bucket.Put(key, marshalledCommand); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("could not move commands from %v into %v: %w", store.pathToJSONCommandStoreFile, store.pathToDatabaseFile, err)
+	}
+
+	pathOfMigratedFile := store.pathToJSONCommandStoreFile + ".migrated"
+	if err := os.Rename(store.pathToJSONCommandStoreFile, pathOfMigratedFile); err != nil {
+		return err
+	}
+	logInfo("Moved the commands from the JSON command store into the database", logFieldOf("from", pathOfMigratedFile),
+		logFieldOf("to", store.pathToDatabaseFile), logFieldOf("commands", len(commandStore.Commands)))
+	return nil
+}
+
+// moves the runs of the JSON run history into the database once, like the commands
+func (store *databaseStore) migrateFromJSONRunHistory(database *bolt.DB) error {
+	var fileLockOnRunHistoryFile = lockOfFile(store.pathToJSONRunHistoryFile)
+
+	fileLockOnRunHistoryFile.Lock()
+	defer fileLockOnRunHistoryFile.Unlock()
+
+	if !doesFileExist(store.pathToJSONRunHistoryFile) {
+		return nil
+	}
+	runHistory, err := readAndParseRunHistoryFromFile(store.pathToJSONRunHistoryFile, true)
+	if err != nil {
+		return fmt.Errorf("could not move runs from %v into %v: %w", store.pathToJSONRunHistoryFile, store.pathToDatabaseFile, err)
+	}
+
+	err = database.Update(func(transaction *bolt.Tx) error {
+		runsBucket, err := transaction.CreateBucketIfNotExists(runsBucketName)
+		if err != nil {
+			return err
+		}
+		// an earlier migration stopped before it could rename the JSON file, the runs are already here
+		if firstKey, _ := runsBucket.Cursor().First(); firstKey != nil {
+			return nil
+		}
+		for _, currentRun := range runHistory.Runs {
+			bucket, err := bucketOfRunsOfCommand(transaction, currentRun.CommandName)
+			if err != nil {
+				return err
+			}
+			if err := putRun(bucket, currentRun); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("could not move runs from %v into %v: %w", store.pathToJSONRunHistoryFile, store.pathToDatabaseFile, err)
+	}
+
+	pathOfMigratedFile := store.pathToJSONRunHistoryFile + ".migrated"
+	if err := os.Rename(store.pathToJSONRunHistoryFile, pathOfMigratedFile); err != nil {
+		return err
+	}
+	logInfo("Moved the runs from the JSON run history into the database", logFieldOf("from", pathOfMigratedFile),
+		logFieldOf("to", store.pathToDatabaseFile), logFieldOf("runs", len(runHistory.Runs)))
+	return nil
+}
+
+func doesFileExist(pathToFile string) bool {
+	_, err := os.Stat(pathToFile)
+	return !os.IsNotExist(err)
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// a database store in a temporary directory, with the JSON files it migrates from next to it
+func newTemporaryDatabaseStore(t *testing.T) *databaseStore {
+	t.Helper()
+	temporaryDirectory := t.TempDir()
+	// the client would ask a daemon running in the working directory otherwise
+	earlierPathToControlSocket := pathToControlSocket
+	pathToControlSocket = filepath.Join(temporaryDirectory, "workScheduler.sock")
+	t.Cleanup(func() { pathToControlSocket = earlierPathToControlSocket })
+
+	return &databaseStore{
+		pathToDatabaseFile:         filepath.Join(temporaryDirectory, "commandStore.db"),
+		pathToJSONCommandStoreFile: filepath.Join(temporaryDirectory, "commandStore.json"),
+		pathToJSONRunHistoryFile:   filepath.Join(temporaryDirectory, "runHistory.json"),
+	}
+}
+
+func namesOfCommands(commandStore CommandStore) []string {
+	names := []string{}
+	for _, currentCommand := range commandStore.Commands {
+		names = append(names, currentCommand.Name)
+	}
+	return names
+}
+
+func TestDatabaseStoreUpdates(t *testing.T) {
+	first := CommandWithArguments{Name: "first", UUID: uuid.New()}
+	second := CommandWithArguments{Name: "second", UUID: uuid.New()}
+	third := CommandWithArguments{Name: "third", UUID: uuid.New()}
+
+	testCases := []struct {
+		name          string
+		changes       []func(commandStore *CommandStore)
+		expectedNames []string
+	}{
+		{"nothing stored", nil, []string{}},
+		{"added in order", []func(commandStore *CommandStore){
+			func(commandStore *CommandStore) { commandStore.Commands = append(commandStore.Commands, first, second) },
+			func(commandStore *CommandStore) { commandStore.Commands = append(commandStore.Commands, third) },
+		}, []string{"first", "second", "third"}},
+		{"removed", []func(commandStore *CommandStore){
+			func(commandStore *CommandStore) {
+				commandStore.Commands = append(commandStore.Commands, first, second, third)
+			},
+			func(commandStore *CommandStore) {
+				commandStore.Commands = append(commandStore.Commands[:1], commandStore.Commands[2:]...)
+			},
+		}, []string{"first", "third"}},
+		{"changed in place", []func(commandStore *CommandStore){
+			func(commandStore *CommandStore) { commandStore.Commands = append(commandStore.Commands, first, second) },
+			func(commandStore *CommandStore) { commandStore.Commands[0].Name = "renamed" },
+		}, []string{"renamed", "second"}},
+		{"removed and added again goes last", []func(commandStore *CommandStore){
+			func(commandStore *CommandStore) { commandStore.Commands = append(commandStore.Commands, first, second) },
+			func(commandStore *CommandStore) { commandStore.Commands = commandStore.Commands[1:] },
+			func(commandStore *CommandStore) { commandStore.Commands = append(commandStore.Commands, first) },
+		}, []string{"second", "first"}},
+	}
+	for _, testCase := range testCases {
+		store := newTemporaryDatabaseStore(t)
+		for _, currentChange := range testCase.changes {
+			change := currentChange
+			err := store.Update(func(commandStore *CommandStore) error {
+				change(commandStore)
+				return nil
+			})
+			if err != nil {
+				t.Fatalf("%v: %v", testCase.name, err)
+			}
+		}
+
+		commandStore, err := store.Read()
+		if err != nil {
+			t.Fatalf("%v: %v", testCase.name, err)
+		}
+		if names := namesOfCommands(commandStore); !reflect.DeepEqual(names, testCase.expectedNames) {
+			t.Errorf("%v: commands %v, expected %v", testCase.name, names, testCase.expectedNames)
+		}
+	}
+}
+
+func TestDatabaseStoreMigratesJSONFiles(t *testing.T) {
+	store := newTemporaryDatabaseStore(t)
+	writeTestFile(t, store.pathToJSONCommandStoreFile, `{"Commands": [{"Name": "backup"}, {"Name": "sync"}]}`)
+	writeTestFile(t, store.pathToJSONRunHistoryFile, `{"Runs": [{"CommandName": "backup", "ExitCode": 1}, {"CommandName": "sync"}, {"CommandName": "backup", "ExitCode": 2}]}`)
+
+	commandStore, err := store.Read()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if names := namesOfCommands(commandStore); !reflect.DeepEqual(names, []string{"backup", "sync"}) {
+		t.Errorf("migrated commands %v", names)
+	}
+	runs, err := store.RunsOfCommand("backup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(runs) != 2 || runs[0].ExitCode != 1 || runs[1].ExitCode != 2 {
+		t.Errorf("migrated runs of backup %+v", runs)
+	}
+
+	for _, pathToFile := range []string{store.pathToJSONCommandStoreFile, store.pathToJSONRunHistoryFile} {
+		if doesFileExist(pathToFile) || !doesFileExist(pathToFile+".migrated") {
+			t.Errorf("%v was not renamed after the migration", pathToFile)
+		}
+	}
+}
+
+// a failed migration must not stick, once the JSON file is fine again the next use migrates it
+func TestDatabaseStoreRetriesFailedMigration(t *testing.T) {
+	store := newTemporaryDatabaseStore(t)
+	writeTestFile(t, store.pathToJSONCommandStoreFile, "{broken")
+
+	steps := []struct {
+		jsonCommandStore string
+		expectedError    bool
+		expectedNames    []string
+	}{
+		{"", true, nil},
+		{"", true, nil},
+		{`{"Commands": [{"Name": "backup"}]}`, false, []string{"backup"}},
+		// already migrated, the file is not looked at again
+		{`{"Commands": [{"Name": "ignored"}]}`, false, []string{"backup"}},
+	}
+	for index, step := range steps {
+		if step.jsonCommandStore != "" {
+			writeTestFile(t, store.pathToJSONCommandStoreFile, step.jsonCommandStore)
+		}
+		commandStore, err := store.Read()
+		if (err != nil) != step.expectedError {
+			t.Fatalf("step %v: error %v", index, err)
+		}
+		if err == nil && !reflect.DeepEqual(namesOfCommands(commandStore), step.expectedNames) {
+			t.Errorf("step %v: commands %v, expected %v", index, namesOfCommands(commandStore), step.expectedNames)
+		}
+	}
+}
+
+func TestDatabaseStoreRunHistoryRetention(t *testing.T) {
+	now := time.Now()
+	testCases := []struct {
+		name                string
+		retention           HistoryRetention
+		endTimes            []time.Time
+		expectedKeptRuns    int
+		expectedRemovedRuns int
+	}{
+		{"all kept", HistoryRetention{}, []time.Time{now, now, now}, 3, 0},
+		{"too many", HistoryRetention{KeepRuns: 2}, []time.Time{now, now, now, now}, 2, 2},
+		{"too old", HistoryRetention{KeepFor: time.Hour}, []time.Time{now.Add(-2 * time.Hour), now.Add(-30 * time.Minute), now}, 2, 1},
+	}
+	for _, testCase := range testCases {
+		store := newTemporaryDatabaseStore(t)
+		removedRuns := 0
+		for index, endTime := range testCase.endTimes {
+			removedByRun, err := store.AddRun(RunRecord{RunID: uuid.New(), CommandName: "backup", EndTime: endTime, ExitCode: index}, testCase.retention)
+			if err != nil {
+				t.Fatalf("%v: %v", testCase.name, err)
+			}
+			removedRuns += len(removedByRun)
+		}
+		// runs of other commands are never touched by the retention of this one
+		if _, err := store.AddRun(RunRecord{RunID: uuid.New(), CommandName: "other", EndTime: now}, HistoryRetention{KeepRuns: 1}); err != nil {
+			t.Fatalf("%v: %v", testCase.name, err)
+		}
+
+		runs, err := store.RunsOfCommand("backup")
+		if err != nil {
+			t.Fatalf("%v: %v", testCase.name, err)
+		}
+		if len(runs) != testCase.expectedKeptRuns || removedRuns != testCase.expectedRemovedRuns {
+			t.Errorf("%v: kept %v and removed %v runs, expected %v and %v", testCase.name, len(runs), removedRuns,
+				testCase.expectedKeptRuns, testCase.expectedRemovedRuns)
+		}
+		// the newest are kept in the order they were added
+		for index, currentRun := range runs {
+			if expectedExitCode := len(testCase.endTimes) - len(runs) + index; currentRun.ExitCode != expectedExitCode {
+				t.Errorf("%v: run %v is run %v, expected run %v", testCase.name, index, currentRun.ExitCode, expectedExitCode)
+			}
+		}
+	}
+}
+
+// the daemon keeps one database open for all reads and changes
+func TestDatabaseStoreKeptOpen(t *testing.T) {
+	store := newTemporaryDatabaseStore(t)
+	writeTestFile(t, store.pathToJSONCommandStoreFile, `{"Commands": [{"Name": "backup"}]}`)
+	if err := store.keepOpen(); err != nil {
+		t.Fatal(err)
+	}
+	defer store.database.Close()
+	database := store.database
+
+	err := store.Update(func(commandStore *CommandStore) error {
+		commandStore.Commands = append(commandStore.Commands, CommandWithArguments{Name: "sync", UUID: uuid.New()})
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.AddRun(RunRecord{RunID: uuid.New(), CommandName: "sync"}, HistoryRetention{}); err != nil {
+		t.Fatal(err)
+	}
+	commandStore, err := store.Read()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if names := namesOfCommands(commandStore); !reflect.DeepEqual(names, []string{"backup", "sync"}) {
+		t.Errorf("commands %v", names)
+	}
+	if store.database != database {
+		t.Error("database was opened again")
+	}
+}
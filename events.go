@@ -0,0 +1,52 @@
+package main
+
+// State changes of commands are announced to subscribers in the daemon, e.g. for the
+// server-sent events of the HTTP API
+
+import (
+	"sync"
+	"time"
+)
+
+// stateChangeEvent is a command that changed its state
+type stateChangeEvent struct {
+	CommandName string       `json:"command_name"`
+	State       CommandState `json:"state"`
+	Time        time.Time    `json:"time"`
+}
+
+// events a subscriber may lag behind before new ones are dropped for it, nobody is waited for
+const maximumEventsBehindOfSubscriber = 256
+
+var stateChangeSubscribers = struct {
+	sync.Mutex
+	channels map[chan stateChangeEvent]bool
+}{channels: make(map[chan stateChangeEvent]bool)}
+
+func subscribeToStateChanges() chan stateChangeEvent {
+	stateChangeSubscribers.Lock()
+	defer stateChangeSubscribers.Unlock()
+	events := make(chan stateChangeEvent, maximumEventsBehindOfSubscriber)
+	stateChangeSubscribers.channels[events] = true
+	return events
+}
+
+func unsubscribeFromStateChanges(events chan stateChangeEvent) {
+	stateChangeSubscribers.Lock()
+	defer stateChangeSubscribers.Unlock()
+	delete(stateChangeSubscribers.channels, events)
+}
+
+func publishStateChange(commandName string, newState CommandState) {
+	stateChangeSubscribers.Lock()
+	defer stateChangeSubscribers.Unlock()
+
+	event := stateChangeEvent{CommandName: commandName, State: newState, Time: time.Now()}
+	for events := range stateChangeSubscribers.channels {
+		select {
+		case events <- event:
+		default:
+			// subscriber is too slow, it misses this event
+		}
+	}
+}
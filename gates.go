@@ -0,0 +1,269 @@
+package main
+
+// Everything that decides whether a command is started, one gate after the other,
+// so the daemon and the explain subcommand check exactly the same things
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// names of the gates in the order they are checked
+const (
+	gateState        = "state"
+	gateRunRequested = "run-now"
+	gateEnabled      = "enabled"
+	gateRetry        = "retry"
+	gateInterval     = "interval"
+	gateSchedule     = "schedule"
+	gateTimeWindows  = "time windows"
+	// followed by the name of the condition
+	gateConditionPrefix = "condition "
+)
+
+// gateResult is whether a gate lets the command start and when it is expected to let it start otherwise
+type gateResult struct {
+	Gate        string
+	Passed      bool
+	Explanation string
+	// zero if it is unknown when the gate will pass
+	ExpectedPassTime time.Time
+	// the gate only passes when the user does something, like enabling the command
+	NeedsUserAction bool
+	// the daemon tells why a command is not started for this gate, for gates like the interval
+	// that block most of the time it would be too chatty
+	logWhenBlocking bool
+}
+
+// checks the gates of a command in order, with stopAtFirstFailure the gates after the first failing one are
+// not checked, which saves evaluating the conditions when the command can't start anyway
+func evaluateGatesOfCommand(command CommandWithArguments, now time.Time, stopAtFirstFailure bool) []gateResult {
+	results := []gateResult{}
+	// returns whether to stop checking
+	addResult := func(result gateResult) bool {
+		results = append(results, result)
+		return !result.Passed && stopAtFirstFailure
+	}
+
+	stateResult := gateResult{Gate: gateState, Passed: true, Explanation: string(command.State)}
+	switch command.State {
+	case CommandRunning:
+		stateResult.Passed = false
+		stateResult.Explanation = "already running"
+	case CommandPaused:
+		stateResult.Passed = false
+		stateResult.Explanation = "paused while running until its conditions are met again"
+	}
+	if addResult(stateResult) {
+		return results
+	}
+
+	// requested with run-now, so nothing else matters
+	if command.RunRequested {
+		addResult(gateResult{Gate: gateRunRequested, Passed: true, Explanation: "a run was requested, the other gates don't apply"})
+		return results
+	}
+
+	enabledResult := gateResult{Gate: gateEnabled, Passed: true, Explanation: "enabled"}
+	if command.Disabled {
+		enabledResult.Passed = false
+		enabledResult.Explanation = "disabled until it is enabled again"
+		enabledResult.NeedsUserAction = true
+	}
+	if addResult(enabledResult) {
+		return results
+	}
+
+	retryResult := gateResult{Gate: gateRetry, Passed: true, Explanation: fmt.Sprintf("%v failed attempts in a row", command.FailedAttempts)}
+	if command.State == CommandGaveUp {
+		retryResult.Passed = false
+		retryResult.Explanation = fmt.Sprintf("gave up after %v failed attempts in a row, runs again when it is enabled, its config changes or it is run with run-now", command.FailedAttempts)
+		retryResult.NeedsUserAction = true
+	} else if isWaitingForRetry(command.State) && now.Before(command.NextAttemptAt) {
+		// failed commands wait for their retry
+		retryResult.Passed = false
+		retryResult.Explanation = fmt.Sprintf("waiting to retry after %v failed attempts in a row", command.FailedAttempts)
+		retryResult.ExpectedPassTime = command.NextAttemptAt
+	}
+	if addResult(retryResult) {
+		return results
+	}
+
+	intervalResult := gateResult{Gate: gateInterval, Passed: true, Explanation: "never ran successfully"}
+	// command was never run before
+	if !command.LastRun.IsZero() {
+		dueTime := command.LastRun.Add(command.DurationBetweenRuns)
+		intervalResult.Explanation = fmt.Sprintf("last successful run %v, runs at most every %v",
+			command.LastRun.Format("2006-01-02 15:04"), command.DurationBetweenRuns)
+		if now.Sub(command.LastRun) <= command.DurationBetweenRuns {
+			intervalResult.Passed = false
+			intervalResult.ExpectedPassTime = dueTime
+		}
+	}
+	if addResult(intervalResult) {
+		return results
+	}
+
+	scheduleResult := gateResult{Gate: gateSchedule, Passed: true, Explanation: "no schedule"}
+	earliestScheduledTime, err := earliestScheduledTimeOfCommand(command)
+	if err != nil {
+		scheduleResult.Passed = false
+		scheduleResult.Explanation = fmt.Sprintf("schedule is invalid: %v", err)
+		scheduleResult.logWhenBlocking = true
+		scheduleResult.NeedsUserAction = true
+	} else if command.CronSchedule != "" || command.OnCalendar != "" {
+		scheduleResult.Explanation = fmt.Sprintf("scheduled for %v", earliestScheduledTime.Format("2006-01-02 15:04"))
+		if now.Before(earliestScheduledTime) {
+			scheduleResult.Passed = false
+			scheduleResult.ExpectedPassTime = earliestScheduledTime
+		}
+	}
+	if addResult(scheduleResult) {
+		return results
+	}
+
+	windowsResult := gateResult{Gate: gateTimeWindows, Passed: true, Explanation: "start allowed now", logWhenBlocking: true}
+	if len(command.AllowedWindows) == 0 && len(command.BlockedWindows) == 0 {
+		windowsResult.Explanation = "no time windows"
+	}
+	isStartAllowed, reasonWhyNotAllowed, err := isStartAllowedByTimeWindows(command, now)
+	if err != nil {
+		windowsResult.Passed = false
+		windowsResult.Explanation = fmt.Sprintf("time windows are invalid: %v", err)
+		windowsResult.NeedsUserAction = true
+	} else if !isStartAllowed {
+		windowsResult.Passed = false
+		windowsResult.Explanation = reasonWhyNotAllowed
+		// only explain shows when the windows allow a start, the daemon checks again in a few seconds anyway
+		if !stopAtFirstFailure {
+			if nextAllowedStart, foundAllowedStart, _ := nextStartAllowedByTimeWindows(command, now); foundAllowedStart {
+				windowsResult.ExpectedPassTime = nextAllowedStart
+			}
+		}
+	}
+	if addResult(windowsResult) {
+		return results
+	}
+
+	// conditions are evaluated last and right before starting each command, because they are
+	// the most expensive check and starting a previous command might have changed them
+	conditions, err := buildConditionsForCommand(command)
+	if err != nil {
+		addResult(gateResult{Gate: gateConditionPrefix + "setup", Explanation: fmt.Sprintf("conditions could not be set up: %v", err),
+			logWhenBlocking: true, NeedsUserAction: true})
+		return results
+	}
+	// all conditions are evaluated together, so every unmet one is reported at once
+	_, conditionResults := evaluateConditions(conditions)
+	rememberConditionResults(command.UUID, conditionResults)
+	for _, currentResult := range conditionResults {
+		conditionGateResult := gateResult{Gate: gateConditionPrefix + currentResult.ConditionName, Passed: currentResult.Met, Explanation: "met", logWhenBlocking: true}
+		if !currentResult.Met {
+			conditionGateResult.Explanation = currentResult.ExplanationWhyUnmet
+		}
+		results = append(results, conditionGateResult)
+	}
+	return results
+}
+
+func haveAllGatesPassed(results []gateResult) bool {
+	for _, currentResult := range results {
+		if !currentResult.Passed {
+			return false
+		}
+	}
+	return true
+}
+
+// the gates that blocked each command when it was checked last, the daemon checks every few seconds
+// and only tells when they change, the rest is only logged at debug level
+var gatesLastBlockingCommands = struct {
+	sync.Mutex
+	byCommandUUID map[uuid.UUID]string
+}{byCommandUUID: make(map[uuid.UUID]string)}
+
+// remembers the gates blocking a command and returns whether they changed since it was checked last
+func haveGatesBlockingCommandChanged(uuidOfCommand uuid.UUID, blockingGates []string) bool {
+	gatesLastBlockingCommands.Lock()
+	defer gatesLastBlockingCommands.Unlock()
+
+	joinedBlockingGates := strings.Join(blockingGates, ", ")
+	changed := gatesLastBlockingCommands.byCommandUUID[uuidOfCommand] != joinedBlockingGates
+	gatesLastBlockingCommands.byCommandUUID[uuidOfCommand] = joinedBlockingGates
+	return changed
+}
+
+// whether all gates of a command pass right now, logs why not for the gates worth it
+func shouldCommandBeRun(command CommandWithArguments) bool {
+	results := evaluateGatesOfCommand(command, time.Now(), true)
+
+	if results[len(results)-1].Gate == gateRunRequested {
+		logInfo("Running command because a run was requested", commandLogFields(command)...)
+	}
+
+	blockingResults := make([]gateResult, 0)
+	blockingGates := make([]string, 0)
+	for _, currentResult := range results {
+		if !currentResult.Passed && currentResult.logWhenBlocking {
+			blockingResults = append(blockingResults, currentResult)
+			blockingGates = append(blockingGates, currentResult.Gate)
+		}
+	}
+	log := logDebug
+	if haveGatesBlockingCommandChanged(command.UUID, blockingGates) {
+		log = logInfo
+	}
+	for _, currentResult := range blockingResults {
+		log("Not running command yet, "+currentResult.Gate+" does not allow it",
+			commandLogFields(command, logFieldOf("gate", currentResult.Gate), logFieldOf("explanation", currentResult.Explanation))...)
+	}
+	return haveAllGatesPassed(results)
+}
+
+// prints every gate of a command with whether it passes and when it is expected to
+func explainCommand(commandName string) error {
+	command, err := findCommandInCommandStoreByName(commandName)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	results := evaluateGatesOfCommand(command, now, false)
+
+	if haveAllGatesPassed(results) {
+		fmt.Println("Command", command.Name, "may start now, the daemon starts it within the next few seconds.")
+	} else {
+		nextEligibleTime, notEligibleBecause, err := nextEligibleTimeOfCommand(command, now)
+		switch {
+		case err != nil:
+			fmt.Println("Command", command.Name, "is not started, its settings are invalid:", err)
+		case nextEligibleTime.IsZero():
+			fmt.Println("Command", command.Name, "is not started:", notEligibleBecause)
+		case nextEligibleTime.After(now):
+			fmt.Println("Command", command.Name, "may start at", nextEligibleTime.Format("2006-01-02 15:04 MST"), "if its conditions are met then.")
+		default:
+			fmt.Println("Command", command.Name, "may start as soon as its conditions are met.")
+		}
+	}
+	fmt.Println()
+
+	for _, currentResult := range results {
+		passOrFail := "PASS"
+		expectedPass := ""
+		if !currentResult.Passed {
+			passOrFail = "FAIL"
+			expectedPass = "  (passes: unknown)"
+			if currentResult.NeedsUserAction {
+				expectedPass = "  (passes: not by itself)"
+			} else if !currentResult.ExpectedPassTime.IsZero() {
+				expectedPass = "  (passes: " + currentResult.ExpectedPassTime.Format("2006-01-02 15:04 MST") + ")"
+			}
+		}
+		fmt.Printf("%v  %-24v %v%v\n", passOrFail, currentResult.Gate, currentResult.Explanation, expectedPass)
+	}
+	return nil
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestExpectedPassTimeOfTimeWindowsGate(t *testing.T) {
+	command := CommandWithArguments{Name: "nightly", AllowedWindows: []TimeWindow{utcWindow("22:00", "06:00")}}
+
+	testCases := []struct {
+		stopAtFirstFailure       bool
+		expectedExpectedPassTime time.Time
+	}{
+		// the daemon only needs to know that the command can't start now
+		{true, time.Time{}},
+		// explain shows when it can
+		{false, utcTime(4, 22, 0)},
+	}
+	for _, testCase := range testCases {
+		results := evaluateGatesOfCommand(command, utcTime(4, 12, 0), testCase.stopAtFirstFailure)
+		windowsResult := results[len(results)-1]
+		if testCase.stopAtFirstFailure && windowsResult.Gate != gateTimeWindows {
+			t.Fatalf("gate %v was checked after the time windows", windowsResult.Gate)
+		}
+		for _, currentResult := range results {
+			if currentResult.Gate == gateTimeWindows {
+				windowsResult = currentResult
+			}
+		}
+		if windowsResult.Passed || !windowsResult.ExpectedPassTime.Equal(testCase.expectedExpectedPassTime) {
+			t.Errorf("stopping at first failure %v: passed %v, expected to pass at %v, expected %v", testCase.stopAtFirstFailure,
+				windowsResult.Passed, windowsResult.ExpectedPassTime, testCase.expectedExpectedPassTime)
+		}
+	}
+}
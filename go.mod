@@ -7,6 +7,8 @@ require (
 	github.com/gofrs/flock v0.8.0
 	github.com/google/uuid v1.1.2
 	github.com/pelletier/go-toml v1.8.1
+	go.etcd.io/bbolt v1.3.5
 	golang.org/x/sys v0.0.0-20201101102859-da207088b7d1 // indirect
+	gopkg.in/yaml.v2 v2.4.0
 	howett.net/plist v0.0.0-20201026045517-117a925f2150 // indirect
 )
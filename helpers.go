@@ -1,6 +1,10 @@
 package main
 
-import "time"
+import (
+	"context"
+	"os/exec"
+	"time"
+)
 
 func isStringInSlice(valueToCheck string, sliceToSearch []string) bool {
 	for _, currentValue := range sliceToSearch {
@@ -24,3 +28,14 @@ func multiplyDuration(factor int, duration time.Duration) time.Duration {
 	// uses the new nanoseconds value to construct the new duration
 	return time.Duration(int64(factor) * int64(duration))
 }
+
+// how long a program that a condition asks, like busctl, may take before it is killed,
+// so a hung one can't hold up the scheduler or the metrics, tests lower it
+var timeoutOfConditionPrograms = 5 * time.Second
+
+// like exec.Command, but the program is killed once timeoutOfConditionPrograms passed,
+// cancel has to be called when it finished
+func conditionProgram(name string, arguments ...string) (*exec.Cmd, context.CancelFunc) {
+	timeoutContext, cancel := context.WithTimeout(context.Background(), timeoutOfConditionPrograms)
+	return exec.CommandContext(timeoutContext, name, arguments...), cancel
+}
@@ -0,0 +1,239 @@
+package main
+
+// History of the runs of all commands, stored next to the command store, so questions like
+// "how often did the backup fail this month?" can be answered
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"syscall"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// only used by the JSON store, the database keeps the runs itself
+var pathToRunHistoryFile = "./runHistory.json"
+
+// RunHistory contains the past runs of all commands
+type RunHistory struct {
+	Runs []RunRecord
+}
+
+// RunRecord is one finished run of a command
+type RunRecord struct {
+	RunID       uuid.UUID
+	CommandName string
+	CommandUUID uuid.UUID
+	StartTime   time.Time
+	EndTime     time.Time
+	Duration    time.Duration
+	// exit code of the process, -1 if it was ended by a signal, could not be started or is unknown
+	ExitCode int
+	// signal that ended the process like "killed", empty if it exited by itself
+	Signal string
+	// state the command ended up in because of this run
+	Outcome CommandState
+	// why the run failed, if it did
+	Error string
+	// conditions of the command as they were when the run started
+	ConditionsAtStart []ConditionResult
+	// where the output of the run is stored, empty if it is not stored
+	OutputLocation string
+	// where standard error is stored if it is stored separately from standard out
+	ErrorOutputLocation string
+}
+
+// HistoryRetention decides how many runs of a command are kept in the history, zero values mean the defaults
+type HistoryRetention struct {
+	// number of newest runs that are kept
+	KeepRuns int `toml:"keep_runs"`
+	// runs older than this are removed
+	KeepFor time.Duration `toml:"keep_for"`
+}
+
+const (
+	defaultHistoryKeepRuns = 100
+	defaultHistoryKeepFor  = 90 * 24 * time.Hour
+)
+
+func (retention HistoryRetention) validate() error {
+	if retention.KeepRuns < 0 || retention.KeepFor < 0 {
+		return fmt.Errorf("history retention must not be negative")
+	}
+	return nil
+}
+
+func (retention HistoryRetention) withDefaults() HistoryRetention {
+	if retention.KeepRuns == 0 {
+		retention.KeepRuns = defaultHistoryKeepRuns
+	}
+	if retention.KeepFor == 0 {
+		retention.KeepFor = defaultHistoryKeepFor
+	}
+	return retention
+}
+
+// fills in exit code and signal from the state of the exited process, if it was started
+func setExitStatusOfRun(run *RunRecord, processState *os.ProcessState) {
+	run.ExitCode = -1
+	if processState == nil {
+		return
+	}
+	run.ExitCode = processState.ExitCode()
+	if waitStatus, ok := processState.Sys().(syscall.WaitStatus); ok && waitStatus.Signaled() {
+		run.Signal = waitStatus.Signal().String()
+	}
+}
+
+// adds a finished run and removes runs of the same command the retention does not keep anymore
+func addRunToRunHistory(run RunRecord, retention HistoryRetention) error {
+	removedRuns, err := activeStore.AddRun(run, retention)
+	if err != nil {
+		return err
+	}
+
+	// only after the runs are gone from the history, so it never points to missing output
+	for _, currentRun := range removedRuns {
+		removeRunOutput(currentRun)
+	}
+	return nil
+}
+
+// the JSON store keeps the history of all commands in one file, which is read and written as a whole
+func addRunToRunHistoryFile(pathToRunHistoryFile string, run RunRecord, retention HistoryRetention) ([]RunRecord, error) {
+	var fileLockOnRunHistoryFile = lockOfFile(pathToRunHistoryFile)
+
+	// locking for reading, modifying and writing run history
+	fileLockOnRunHistoryFile.Lock()
+	defer fileLockOnRunHistoryFile.Unlock()
+
+	runHistory, readError := readAndParseRunHistoryFromFile(pathToRunHistoryFile, true)
+	if readError != nil {
+		return nil, readError
+	}
+
+	runHistory.Runs = append(runHistory.Runs, run)
+	keptRuns, removedRuns := applyHistoryRetention(runHistory.Runs, run.CommandName, retention, time.Now())
+	runHistory.Runs = keptRuns
+
+	if writeError := marshalAndWriteRunHistoryToFile(pathToRunHistoryFile, runHistory); writeError != nil {
+		return nil, writeError
+	}
+	return removedRuns, nil
+}
+
+// removes runs of the given command that are too old or too many, runs of other commands are kept,
+// returns the kept and the removed runs
+func applyHistoryRetention(runs []RunRecord, commandName string, retention HistoryRetention, now time.Time) ([]RunRecord, []RunRecord) {
+	retention = retention.withDefaults()
+
+	numberOfRunsOfCommand := 0
+	for _, currentRun := range runs {
+		if currentRun.CommandName == commandName {
+			numberOfRunsOfCommand++
+		}
+	}
+
+	keptRuns := make([]RunRecord, 0, len(runs))
+	removedRuns := make([]RunRecord, 0)
+	numberOfRunsOfCommandSeen := 0
+	// runs are appended when they end, so the oldest ones come first
+	for _, currentRun := range runs {
+		if currentRun.CommandName != commandName {
+			keptRuns = append(keptRuns, currentRun)
+			continue
+		}
+		numberOfRunsOfCommandSeen++
+
+		isTooOld := now.Sub(currentRun.EndTime) > retention.KeepFor
+		isTooMany := numberOfRunsOfCommand-numberOfRunsOfCommandSeen >= retention.KeepRuns
+		if isTooOld || isTooMany {
+			removedRuns = append(removedRuns, currentRun)
+		} else {
+			keptRuns = append(keptRuns, currentRun)
+		}
+	}
+	return keptRuns, removedRuns
+}
+
+// runs of a command that started after the given time, newest first
+func runsOfCommandSince(commandName string, since time.Time) ([]RunRecord, error) {
+	runsOfCommand, err := activeStore.RunsOfCommand(commandName)
+	if err != nil {
+		return []RunRecord{}, err
+	}
+
+	runs := make([]RunRecord, 0)
+	for _, currentRun := range runsOfCommand {
+		if currentRun.StartTime.After(since) {
+			runs = append(runs, currentRun)
+		}
+	}
+	sort.Slice(runs, func(i, j int) bool {
+		return runs[i].StartTime.After(runs[j].StartTime)
+	})
+	return runs, nil
+}
+
+// number of runs by the state they ended in
+func countRunsByOutcome(runs []RunRecord) map[CommandState]int {
+	runsByOutcome := make(map[CommandState]int)
+	for _, currentRun := range runs {
+		runsByOutcome[currentRun.Outcome]++
+	}
+	return runsByOutcome
+}
+
+func readAndParseRunHistoryFromFile(pathToRunHistoryFile string, alreadyLocked bool) (RunHistory, error) {
+
+	var fileLockOnRunHistoryFile = lockOfFile(pathToRunHistoryFile)
+
+	if !alreadyLocked {
+		fileLockOnRunHistoryFile.Lock()
+		defer fileLockOnRunHistoryFile.Unlock()
+	}
+
+	runHistory := RunHistory{}
+	marshalledJSONData, readingError := ioutil.ReadFile(pathToRunHistoryFile)
+	if readingError != nil {
+		// no runs were recorded yet
+		if os.IsNotExist(readingError) {
+			return runHistory, nil
+		}
+		countStoreError("run_history", "read")
+		return runHistory, readingError
+	}
+
+	// empty file was created by locking it in older versions
+	if len(marshalledJSONData) == 0 {
+		return runHistory, nil
+	}
+
+	unmarshalError := json.Unmarshal(marshalledJSONData, &runHistory)
+	if unmarshalError != nil {
+		countStoreError("run_history", "read")
+	}
+	return runHistory, unmarshalError
+}
+
+func marshalAndWriteRunHistoryToFile(pathToRunHistoryFile string, runHistory RunHistory) error {
+
+	// prefix new lines with nothing, indent with tabs
+	marshalledJSONData, marshalError := json.MarshalIndent(&runHistory, "", "\t")
+	if marshalError != nil {
+		countStoreError("run_history", "write")
+		return marshalError
+	}
+
+	// only readable and writeable by own user, like the command store, the history is only
+	// a record of the past, so it does not need backups
+	writeError := writeFileAtomically(pathToRunHistoryFile, marshalledJSONData, 0, nil)
+	if writeError != nil {
+		countStoreError("run_history", "write")
+	}
+	return writeError
+}
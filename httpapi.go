@@ -0,0 +1,401 @@
+package main
+
+// Optional HTTP API of the daemon for dashboards, with the commands, their runs, logs and
+// conditions as resources, see openAPISpecification for all of them
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// address the HTTP API listens on when it is enabled without an address
+const defaultHTTPAPIAddress = "127.0.0.1:8742"
+
+// addresses starting with this are Unix sockets, e.g. "unix:./workScheduler-api.sock"
+const unixSocketAddressPrefix = "unix:"
+
+const httpAPIPathPrefix = "/api/v1/"
+
+// apiNewCommand is the body of a request adding a command, like the flags of the add subcommand
+type apiNewCommand struct {
+	Name         string   `json:"name"`
+	AbsolutePath string   `json:"absolute_path"`
+	Arguments    []string `json:"arguments"`
+	// like "24h", empty means practically only once
+	Interval string   `json:"interval"`
+	Requires []string `json:"requires"`
+}
+
+// apiConditionResults are the conditions of a command as the daemon evaluated them last, a request
+// never evaluates them itself, so polling the API doesn't decide how often the network is probed
+type apiConditionResults struct {
+	Conditions []ConditionResult `json:"conditions"`
+	// missing if the daemon did not evaluate them yet
+	EvaluatedAt *time.Time `json:"evaluated_at,omitempty"`
+}
+
+// apiPowerState is the debounced power state of the daemon and how it changed
+type apiPowerState struct {
+	State PowerState `json:"state"`
+	// oldest first, only the newest are kept
+	Transitions []PowerStateTransition `json:"transitions"`
+}
+
+type apiError struct {
+	Error string `json:"error"`
+}
+
+type apiMessage struct {
+	Message string `json:"message"`
+}
+
+// starts serving the HTTP API in the background, a bearer token is required on TCP,
+// on a Unix socket only if one is given
+func startHTTPAPI(address string, pathToTokenFile string) error {
+	token := ""
+	if pathToTokenFile != "" {
+		tokenData, err := ioutil.ReadFile(pathToTokenFile)
+		if err != nil {
+			return fmt.Errorf("could not read token file: %v", err)
+		}
+		token = strings.TrimSpace(string(tokenData))
+		if token == "" {
+			return fmt.Errorf("token file %v is empty", pathToTokenFile)
+		}
+	}
+
+	var listener net.Listener
+	var err error
+	if strings.HasPrefix(address, unixSocketAddressPrefix) {
+		pathToSocket := strings.TrimPrefix(address, unixSocketAddressPrefix)
+		if err := os.Remove(pathToSocket); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		if listener, err = net.Listen("unix", pathToSocket); err != nil {
+			return err
+		}
+		// only our own user may use the API
+		if err := os.Chmod(pathToSocket, 0600); err != nil {
+			listener.Close()
+			return err
+		}
+	} else {
+		// anyone who can connect could start commands
+		if token == "" {
+			return fmt.Errorf("the HTTP API on %v needs a bearer token, give a file containing it with --http-token-file", address)
+		}
+		if listener, err = net.Listen("tcp", address); err != nil {
+			return err
+		}
+	}
+
+	server := &http.Server{
+		Handler:           requireBearerToken(token, http.HandlerFunc(serveHTTPAPI)),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	go func() {
+		logInfo("Serving HTTP API", logFieldOf("address", address))
+		if err := server.Serve(listener); err != nil {
+			logError("HTTP API stopped", errorLogField(err))
+		}
+	}()
+	return nil
+}
+
+func requireBearerToken(token string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		if token != "" {
+			givenToken := strings.TrimPrefix(request.Header.Get("Authorization"), "Bearer ")
+			// constant time, so the token can't be guessed byte by byte from the response times
+			if subtle.ConstantTimeCompare([]byte(givenToken), []byte(token)) != 1 {
+				writer.Header().Set("WWW-Authenticate", "Bearer")
+				writeAPIError(writer, http.StatusUnauthorized, fmt.Errorf("missing or wrong bearer token"))
+				return
+			}
+		}
+		next.ServeHTTP(writer, request)
+	})
+}
+
+// routes requests by their path, like /api/v1/commands/backup/runs
+func serveHTTPAPI(writer http.ResponseWriter, request *http.Request) {
+	// where Prometheus looks for them by default
+	if request.URL.Path == "/metrics" {
+		allowMethods(writer, request, func() { serveMetrics(writer, request) }, http.MethodGet)
+		return
+	}
+	if !strings.HasPrefix(request.URL.Path, httpAPIPathPrefix) {
+		writeAPIError(writer, http.StatusNotFound, fmt.Errorf("unknown path, the API is below %v", httpAPIPathPrefix))
+		return
+	}
+	pathParts := strings.Split(strings.Trim(strings.TrimPrefix(request.URL.Path, httpAPIPathPrefix), "/"), "/")
+
+	switch {
+	case len(pathParts) == 1 && pathParts[0] == "openapi.json":
+		allowMethods(writer, request, func() {
+			writer.Header().Set("Content-Type", "application/json")
+			io.WriteString(writer, openAPISpecification)
+		}, http.MethodGet)
+	case len(pathParts) == 1 && pathParts[0] == "events":
+		allowMethods(writer, request, func() { serveStateChangeEvents(writer, request) }, http.MethodGet)
+	case len(pathParts) == 1 && pathParts[0] == "conditions":
+		allowMethods(writer, request, func() { writeAPIResponse(writer, http.StatusOK, namesOfRegisteredConditions()) }, http.MethodGet)
+	case len(pathParts) == 1 && pathParts[0] == "power":
+		allowMethods(writer, request, func() {
+			powerState, _, _ := daemonPowerMonitor.currentPowerState()
+			writeAPIResponse(writer, http.StatusOK, apiPowerState{State: powerState, Transitions: daemonPowerMonitor.transitionHistory()})
+		}, http.MethodGet)
+	case len(pathParts) == 1 && pathParts[0] == "commands":
+		switch request.Method {
+		case http.MethodGet:
+			statuses, err := statusesOfCommands(nil)
+			writeAPIResponseOrError(writer, statuses, err)
+		case http.MethodPost:
+			addCommandFromAPI(writer, request)
+		default:
+			allowMethods(writer, request, nil, http.MethodGet, http.MethodPost)
+		}
+	case len(pathParts) >= 2 && pathParts[0] == "commands":
+		serveCommandResource(writer, request, pathParts[1], pathParts[2:])
+	default:
+		writeAPIError(writer, http.StatusNotFound, fmt.Errorf("unknown path %v", request.URL.Path))
+	}
+}
+
+// resources of one command below /api/v1/commands/<name>
+func serveCommandResource(writer http.ResponseWriter, request *http.Request, commandName string, pathParts []string) {
+	if len(pathParts) == 0 {
+		switch request.Method {
+		case http.MethodGet:
+			command, err := findCommandInCommandStoreByName(commandName)
+			writeAPIResponseOrError(writer, command, err)
+		case http.MethodDelete:
+			writeControlResponseToAPI(writer, executeControlRequest(controlRequest{Action: controlActionRemove, CommandName: commandName}, true))
+		default:
+			allowMethods(writer, request, nil, http.MethodGet, http.MethodDelete)
+		}
+		return
+	}
+
+	actionsByPath := map[string]string{
+		"run-now": controlActionRunNow,
+		"enable":  controlActionEnable,
+		"disable": controlActionDisable,
+		"pause":   controlActionPause,
+		"resume":  controlActionResume,
+	}
+	if action, isAction := actionsByPath[pathParts[0]]; isAction && len(pathParts) == 1 {
+		allowMethods(writer, request, func() {
+			writeControlResponseToAPI(writer, executeControlRequest(controlRequest{Action: action, CommandName: commandName}, true))
+		}, http.MethodPost)
+		return
+	}
+
+	switch {
+	case pathParts[0] == "status" && len(pathParts) == 1:
+		allowMethods(writer, request, func() {
+			statuses, err := statusesOfCommands([]string{commandName})
+			if err != nil {
+				writeAPIResponseOrError(writer, nil, err)
+				return
+			}
+			writeAPIResponse(writer, http.StatusOK, statuses[0])
+		}, http.MethodGet)
+	case pathParts[0] == "conditions" && len(pathParts) == 1:
+		allowMethods(writer, request, func() {
+			command, err := findCommandInCommandStoreByName(commandName)
+			if err != nil {
+				writeAPIResponseOrError(writer, nil, err)
+				return
+			}
+			conditionResults := apiConditionResults{Conditions: []ConditionResult{}}
+			if evaluatedResults, found := lastConditionResultsOfCommand(command.UUID); found {
+				conditionResults.Conditions = evaluatedResults.Results
+				conditionResults.EvaluatedAt = &evaluatedResults.EvaluatedAt
+			}
+			writeAPIResponse(writer, http.StatusOK, conditionResults)
+		}, http.MethodGet)
+	case pathParts[0] == "runs" && len(pathParts) == 1:
+		allowMethods(writer, request, func() {
+			runs, err := runsOfCommandSince(commandName, time.Time{})
+			writeAPIResponseOrError(writer, runs, err)
+		}, http.MethodGet)
+	case pathParts[0] == "runs" && len(pathParts) == 2:
+		allowMethods(writer, request, func() {
+			run, err := findRunOfCommand(commandName, runIDOfPath(pathParts[1]))
+			writeAPIResponseOrError(writer, run, err)
+		}, http.MethodGet)
+	case pathParts[0] == "runs" && len(pathParts) == 3 && pathParts[2] == "log":
+		allowMethods(writer, request, func() { serveLogOfRun(writer, commandName, runIDOfPath(pathParts[1])) }, http.MethodGet)
+	default:
+		writeAPIError(writer, http.StatusNotFound, fmt.Errorf("unknown path %v", request.URL.Path))
+	}
+}
+
+// "latest" is the newest run
+func runIDOfPath(runIDInPath string) string {
+	if runIDInPath == "latest" {
+		return ""
+	}
+	return runIDInPath
+}
+
+func addCommandFromAPI(writer http.ResponseWriter, request *http.Request) {
+	var newCommandFromAPI apiNewCommand
+	if err := json.NewDecoder(io.LimitReader(request.Body, 1024*1024)).Decode(&newCommandFromAPI); err != nil {
+		writeAPIError(writer, http.StatusBadRequest, fmt.Errorf("invalid command: %v", err))
+		return
+	}
+	if newCommandFromAPI.Name == "" {
+		writeAPIError(writer, http.StatusBadRequest, fmt.Errorf("name of command is missing"))
+		return
+	}
+
+	newCommand := CommandWithArguments{
+		Name:                newCommandFromAPI.Name,
+		AbsolutePath:        newCommandFromAPI.AbsolutePath,
+		CommandArguments:    newCommandFromAPI.Arguments,
+		DurationBetweenRuns: 999999999 * time.Second,
+		RequiredConditions:  newCommandFromAPI.Requires,
+	}
+	if newCommandFromAPI.Interval != "" {
+		interval, err := time.ParseDuration(newCommandFromAPI.Interval)
+		if err != nil {
+			writeAPIError(writer, http.StatusBadRequest, fmt.Errorf("invalid interval: %v", err))
+			return
+		}
+		newCommand.DurationBetweenRuns = interval
+	}
+
+	response := executeControlRequest(controlRequest{Action: controlActionAdd, NewCommand: &newCommand}, true)
+	if response.Error != "" {
+		// everything that goes wrong when adding is caused by the command
+		writeAPIError(writer, http.StatusBadRequest, response.err())
+		return
+	}
+	writeAPIResponse(writer, http.StatusCreated, apiMessage{Message: response.Message})
+}
+
+func serveLogOfRun(writer http.ResponseWriter, commandName string, runIDPrefix string) {
+	run, err := findRunOfCommand(commandName, runIDPrefix)
+	if err != nil {
+		writeAPIResponseOrError(writer, nil, err)
+		return
+	}
+	if run.OutputLocation == "" {
+		writeAPIError(writer, http.StatusNotFound, fmt.Errorf("output of run %v was not stored", run.RunID))
+		return
+	}
+
+	writer.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	// with separate standard error, it comes after standard out
+	for _, currentLocation := range []string{run.OutputLocation, run.ErrorOutputLocation} {
+		if currentLocation == "" {
+			continue
+		}
+		logFile, err := os.Open(currentLocation)
+		if err != nil {
+			fmt.Fprintln(writer, "======== Could not read log:", err, "========")
+			continue
+		}
+		io.Copy(writer, logFile)
+		logFile.Close()
+	}
+}
+
+// sends every state change of a command as server-sent event until the client goes away
+func serveStateChangeEvents(writer http.ResponseWriter, request *http.Request) {
+	flusher, canFlush := writer.(http.Flusher)
+	if !canFlush {
+		writeAPIError(writer, http.StatusInternalServerError, fmt.Errorf("streaming is not supported"))
+		return
+	}
+
+	events := subscribeToStateChanges()
+	defer unsubscribeFromStateChanges(events)
+
+	writer.Header().Set("Content-Type", "text/event-stream")
+	writer.Header().Set("Cache-Control", "no-cache")
+	writer.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	// comments keep proxies and clients from closing an idle connection
+	keepAlive := time.NewTicker(30 * time.Second)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case event := <-events:
+			eventData, err := json.Marshal(event)
+			if err != nil {
+				continue
+			}
+			fmt.Fprintf(writer, "event: state_changed\ndata: %s\n\n", eventData)
+			flusher.Flush()
+		case <-keepAlive.C:
+			io.WriteString(writer, ": keep alive\n\n")
+			flusher.Flush()
+		case <-request.Context().Done():
+			return
+		}
+	}
+}
+
+// runs the handler if the request uses one of the methods, a nil handler means no method is allowed
+func allowMethods(writer http.ResponseWriter, request *http.Request, handler func(), methods ...string) {
+	if handler != nil {
+		for _, currentMethod := range methods {
+			if request.Method == currentMethod {
+				handler()
+				return
+			}
+		}
+	}
+	writer.Header().Set("Allow", strings.Join(methods, ", "))
+	writeAPIError(writer, http.StatusMethodNotAllowed, fmt.Errorf("method %v is not allowed, allowed are %v", request.Method, methods))
+}
+
+func writeAPIResponse(writer http.ResponseWriter, statusCode int, body interface{}) {
+	writer.Header().Set("Content-Type", "application/json")
+	writer.WriteHeader(statusCode)
+	encoder := json.NewEncoder(writer)
+	encoder.SetIndent("", "\t")
+	encoder.Encode(body)
+}
+
+func writeAPIError(writer http.ResponseWriter, statusCode int, err error) {
+	writeAPIResponse(writer, statusCode, apiError{Error: err.Error()})
+}
+
+// missing commands and runs are 404, everything else that goes wrong when reading is the fault of the daemon
+func writeAPIResponseOrError(writer http.ResponseWriter, body interface{}, err error) {
+	switch {
+	case err == nil:
+		writeAPIResponse(writer, http.StatusOK, body)
+	case errors.Is(err, errCommandNotFound) || errors.Is(err, errRunNotFound):
+		writeAPIError(writer, http.StatusNotFound, err)
+	default:
+		writeAPIError(writer, http.StatusInternalServerError, err)
+	}
+}
+
+// actions on commands fail because the command is missing or the action is not possible right now
+func writeControlResponseToAPI(writer http.ResponseWriter, response controlResponse) {
+	switch {
+	case response.Error == "":
+		writeAPIResponse(writer, http.StatusOK, apiMessage{Message: response.Message})
+	case response.CommandNotFound:
+		writeAPIError(writer, http.StatusNotFound, response.err())
+	default:
+		writeAPIError(writer, http.StatusConflict, response.err())
+	}
+}
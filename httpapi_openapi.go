@@ -0,0 +1,228 @@
+package main
+
+// OpenAPI description of the HTTP API, served at /api/v1/openapi.json
+
+const openAPISpecification = `{
+	"openapi": "3.0.3",
+	"info": {
+		"title": "WorkScheduler API",
+		"description": "Commands scheduled by the WorkScheduler daemon, their runs, logs and conditions.",
+		"version": "1"
+	},
+	"servers": [{"url": "/api/v1"}],
+	"security": [{"bearerToken": []}],
+	"paths": {
+		"/commands": {
+			"get": {
+				"summary": "Status of all commands",
+				"responses": {
+					"200": {"description": "Status of every command", "content": {"application/json": {"schema": {"type": "array", "items": {"$ref": "#/components/schemas/CommandStatus"}}}}},
+					"401": {"$ref": "#/components/responses/Unauthorized"}
+				}
+			},
+			"post": {
+				"summary": "Add a command",
+				"requestBody": {"required": true, "content": {"application/json": {"schema": {"$ref": "#/components/schemas/NewCommand"}}}},
+				"responses": {
+					"201": {"$ref": "#/components/responses/Message"},
+					"400": {"$ref": "#/components/responses/Error"},
+					"401": {"$ref": "#/components/responses/Unauthorized"}
+				}
+			}
+		},
+		"/commands/{name}": {
+			"parameters": [{"$ref": "#/components/parameters/CommandName"}],
+			"get": {
+				"summary": "Settings and state of a command as stored in the command store",
+				"responses": {
+					"200": {"description": "The command", "content": {"application/json": {"schema": {"type": "object"}}}},
+					"404": {"$ref": "#/components/responses/Error"}
+				}
+			},
+			"delete": {
+				"summary": "Remove a command that was added with the API or the command line",
+				"responses": {
+					"200": {"$ref": "#/components/responses/Message"},
+					"404": {"$ref": "#/components/responses/Error"},
+					"409": {"$ref": "#/components/responses/Error"}
+				}
+			}
+		},
+		"/commands/{name}/status": {
+			"parameters": [{"$ref": "#/components/parameters/CommandName"}],
+			"get": {
+				"summary": "Status of a command",
+				"responses": {
+					"200": {"description": "Status of the command", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/CommandStatus"}}}},
+					"404": {"$ref": "#/components/responses/Error"}
+				}
+			}
+		},
+		"/commands/{name}/conditions": {
+			"parameters": [{"$ref": "#/components/parameters/CommandName"}],
+			"get": {
+				"summary": "The conditions of a command as the daemon evaluated them last",
+				"responses": {
+					"200": {"description": "Result of every condition", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/ConditionResults"}}}},
+					"404": {"$ref": "#/components/responses/Error"}
+				}
+			}
+		},
+		"/commands/{name}/run-now": {
+			"parameters": [{"$ref": "#/components/parameters/CommandName"}],
+			"post": {"summary": "Run a command as soon as possible, no matter its schedule and conditions", "responses": {"200": {"$ref": "#/components/responses/Message"}, "404": {"$ref": "#/components/responses/Error"}}}
+		},
+		"/commands/{name}/enable": {
+			"parameters": [{"$ref": "#/components/parameters/CommandName"}],
+			"post": {"summary": "Let a disabled command run again", "responses": {"200": {"$ref": "#/components/responses/Message"}, "404": {"$ref": "#/components/responses/Error"}}}
+		},
+		"/commands/{name}/disable": {
+			"parameters": [{"$ref": "#/components/parameters/CommandName"}],
+			"post": {"summary": "Stop a command from being run", "responses": {"200": {"$ref": "#/components/responses/Message"}, "404": {"$ref": "#/components/responses/Error"}}}
+		},
+		"/commands/{name}/pause": {
+			"parameters": [{"$ref": "#/components/parameters/CommandName"}],
+			"post": {"summary": "Pause a running command until it is resumed", "responses": {"200": {"$ref": "#/components/responses/Message"}, "404": {"$ref": "#/components/responses/Error"}, "409": {"$ref": "#/components/responses/Error"}}}
+		},
+		"/commands/{name}/resume": {
+			"parameters": [{"$ref": "#/components/parameters/CommandName"}],
+			"post": {"summary": "Resume a paused command", "responses": {"200": {"$ref": "#/components/responses/Message"}, "404": {"$ref": "#/components/responses/Error"}, "409": {"$ref": "#/components/responses/Error"}}}
+		},
+		"/commands/{name}/runs": {
+			"parameters": [{"$ref": "#/components/parameters/CommandName"}],
+			"get": {
+				"summary": "Runs of a command in the run history, newest first",
+				"responses": {"200": {"description": "The runs", "content": {"application/json": {"schema": {"type": "array", "items": {"$ref": "#/components/schemas/Run"}}}}}}
+			}
+		},
+		"/commands/{name}/runs/{runID}": {
+			"parameters": [{"$ref": "#/components/parameters/CommandName"}, {"$ref": "#/components/parameters/RunID"}],
+			"get": {
+				"summary": "A run of a command",
+				"responses": {"200": {"description": "The run", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Run"}}}}, "404": {"$ref": "#/components/responses/Error"}}
+			}
+		},
+		"/commands/{name}/runs/{runID}/log": {
+			"parameters": [{"$ref": "#/components/parameters/CommandName"}, {"$ref": "#/components/parameters/RunID"}],
+			"get": {
+				"summary": "Output of a run, standard error follows standard out if they are stored separately",
+				"responses": {"200": {"description": "The output", "content": {"text/plain": {"schema": {"type": "string"}}}}, "404": {"$ref": "#/components/responses/Error"}}
+			}
+		},
+		"/conditions": {
+			"get": {
+				"summary": "Names of all known conditions",
+				"responses": {"200": {"description": "The names", "content": {"application/json": {"schema": {"type": "array", "items": {"type": "string"}}}}}}
+			}
+		},
+		"/power": {
+			"get": {
+				"summary": "The debounced power state and its recorded changes",
+				"responses": {"200": {"description": "The power state", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/PowerState"}}}}}
+			}
+		},
+		"/events": {
+			"get": {
+				"summary": "Server-sent events named state_changed whenever a command changes its state",
+				"responses": {"200": {"description": "Event stream, the data of each event is a StateChange", "content": {"text/event-stream": {"schema": {"$ref": "#/components/schemas/StateChange"}}}}}
+			}
+		},
+		"/openapi.json": {
+			"get": {"summary": "This description", "responses": {"200": {"description": "OpenAPI description", "content": {"application/json": {"schema": {"type": "object"}}}}}}
+		}
+	},
+	"components": {
+		"securitySchemes": {
+			"bearerToken": {"type": "http", "scheme": "bearer"}
+		},
+		"parameters": {
+			"CommandName": {"name": "name", "in": "path", "required": true, "schema": {"type": "string"}},
+			"RunID": {"name": "runID", "in": "path", "required": true, "description": "Id of the run or its start, latest is the newest run", "schema": {"type": "string"}}
+		},
+		"responses": {
+			"Message": {"description": "What was done", "content": {"application/json": {"schema": {"type": "object", "properties": {"message": {"type": "string"}}}}}},
+			"Error": {"description": "What went wrong", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Error"}}}},
+			"Unauthorized": {"description": "Missing or wrong bearer token", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Error"}}}}
+		},
+		"schemas": {
+			"Error": {"type": "object", "properties": {"error": {"type": "string"}}},
+			"NewCommand": {
+				"type": "object",
+				"required": ["name", "absolute_path"],
+				"properties": {
+					"name": {"type": "string"},
+					"absolute_path": {"type": "string"},
+					"arguments": {"type": "array", "items": {"type": "string"}},
+					"interval": {"type": "string", "description": "Minimum time between successful runs like 24h, empty means practically only once"},
+					"requires": {"type": "array", "items": {"type": "string"}, "description": "Names of the required conditions, empty means ac_power"}
+				}
+			},
+			"CommandStatus": {
+				"type": "object",
+				"properties": {
+					"name": {"type": "string"},
+					"state": {"type": "string"},
+					"disabled": {"type": "boolean"},
+					"last_run": {"type": "string", "format": "date-time"},
+					"next_eligible_time": {"type": "string", "format": "date-time"},
+					"not_eligible_because": {"type": "string"},
+					"blocking_conditions": {"type": "array", "items": {"type": "object", "properties": {"name": {"type": "string"}, "explanation": {"type": "string"}}}},
+					"conditions_evaluated_at": {"type": "string", "format": "date-time", "description": "When the daemon evaluated the conditions last, missing if it did not yet"}
+				}
+			},
+			"ConditionResult": {
+				"type": "object",
+				"properties": {
+					"ConditionName": {"type": "string"},
+					"Met": {"type": "boolean"},
+					"ExplanationWhyUnmet": {"type": "string"}
+				}
+			},
+			"ConditionResults": {
+				"type": "object",
+				"properties": {
+					"conditions": {"type": "array", "items": {"$ref": "#/components/schemas/ConditionResult"}, "description": "Empty if the daemon did not evaluate them yet"},
+					"evaluated_at": {"type": "string", "format": "date-time", "description": "When the daemon evaluated the conditions last, missing if it did not yet"}
+				}
+			},
+			"PowerState": {
+				"type": "object",
+				"properties": {
+					"state": {"type": "string", "enum": ["Unknown", "OnACPower", "OnBattery"]},
+					"transitions": {"type": "array", "description": "Oldest first, only the newest 50 are kept", "items": {"type": "object", "properties": {
+						"from": {"type": "string"},
+						"to": {"type": "string"},
+						"at": {"type": "string", "format": "date-time"}
+					}}}
+				}
+			},
+			"Run": {
+				"type": "object",
+				"properties": {
+					"RunID": {"type": "string"},
+					"CommandName": {"type": "string"},
+					"CommandUUID": {"type": "string"},
+					"StartTime": {"type": "string", "format": "date-time"},
+					"EndTime": {"type": "string", "format": "date-time"},
+					"Duration": {"type": "integer", "description": "Nanoseconds"},
+					"ExitCode": {"type": "integer"},
+					"Signal": {"type": "string"},
+					"Outcome": {"type": "string"},
+					"Error": {"type": "string"},
+					"ConditionsAtStart": {"type": "array", "items": {"$ref": "#/components/schemas/ConditionResult"}},
+					"OutputLocation": {"type": "string"},
+					"ErrorOutputLocation": {"type": "string"}
+				}
+			},
+			"StateChange": {
+				"type": "object",
+				"properties": {
+					"command_name": {"type": "string"},
+					"state": {"type": "string"},
+					"time": {"type": "string", "format": "date-time"}
+				}
+			}
+		}
+	}
+}
+`
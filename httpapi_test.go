@@ -0,0 +1,100 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestConditionsOfCommandAreTheLastEvaluated(t *testing.T) {
+	useTemporaryStore(t)
+	registeredConditions["must_not_be_evaluated"] = func(parameters ConditionParameters) (Condition, error) {
+		return conditionThatMustNotBeEvaluated{t: t}, nil
+	}
+	defer delete(registeredConditions, "must_not_be_evaluated")
+
+	evaluated := CommandWithArguments{Name: "evaluated", UUID: uuid.New(), RequiredConditions: []string{"must_not_be_evaluated"}}
+	notEvaluated := CommandWithArguments{Name: "not-evaluated", UUID: uuid.New(), RequiredConditions: []string{"must_not_be_evaluated"}}
+	err := activeStore.Update(func(commandStore *CommandStore) error {
+		commandStore.Commands = []CommandWithArguments{evaluated, notEvaluated}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rememberConditionResults(evaluated.UUID, []ConditionResult{{ConditionName: "must_not_be_evaluated", Met: false}})
+
+	testCases := []struct {
+		commandName         string
+		expectedStatusCode  int
+		expectedConditions  int
+		expectedEvaluatedAt bool
+	}{
+		{"evaluated", http.StatusOK, 1, true},
+		{"not-evaluated", http.StatusOK, 0, false},
+		{"unknown", http.StatusNotFound, 0, false},
+	}
+	for _, testCase := range testCases {
+		recorder := httptest.NewRecorder()
+		serveHTTPAPI(recorder, httptest.NewRequest(http.MethodGet, httpAPIPathPrefix+"commands/"+testCase.commandName+"/conditions", nil))
+		if recorder.Code != testCase.expectedStatusCode {
+			t.Errorf("%v: status code %v, expected %v", testCase.commandName, recorder.Code, testCase.expectedStatusCode)
+			continue
+		}
+		if recorder.Code != http.StatusOK {
+			continue
+		}
+
+		conditionResults := apiConditionResults{}
+		if err := json.Unmarshal(recorder.Body.Bytes(), &conditionResults); err != nil {
+			t.Fatalf("%v: %v", testCase.commandName, err)
+		}
+		if len(conditionResults.Conditions) != testCase.expectedConditions || (conditionResults.EvaluatedAt != nil) != testCase.expectedEvaluatedAt {
+			t.Errorf("%v: %v conditions evaluated at %v", testCase.commandName, len(conditionResults.Conditions), conditionResults.EvaluatedAt)
+		}
+	}
+}
+
+func TestPowerStateWithTransitions(t *testing.T) {
+	source := newScriptedPowerSource(pluggedInReading)
+	monitor := newPowerMonitor(source, 1, 0)
+	monitor.running = true
+	earlierPowerMonitor := daemonPowerMonitor
+	daemonPowerMonitor = monitor
+	defer func() { daemonPowerMonitor = earlierPowerMonitor }()
+
+	now := time.Now()
+	monitor.sample(now)
+	source.Script(unpluggedReading)
+	monitor.sample(now.Add(time.Second))
+
+	recorder := httptest.NewRecorder()
+	serveHTTPAPI(recorder, httptest.NewRequest(http.MethodGet, httpAPIPathPrefix+"power", nil))
+	powerState := apiPowerState{}
+	if err := json.Unmarshal(recorder.Body.Bytes(), &powerState); err != nil {
+		t.Fatal(err)
+	}
+	if powerState.State != PowerStateOnBattery {
+		t.Errorf("state %v, expected %v", powerState.State, PowerStateOnBattery)
+	}
+
+	expectedTransitions := []struct {
+		from PowerState
+		to   PowerState
+	}{
+		{PowerStateUnknown, PowerStateOnACPower},
+		{PowerStateOnACPower, PowerStateOnBattery},
+	}
+	if len(powerState.Transitions) != len(expectedTransitions) {
+		t.Fatalf("transitions %+v", powerState.Transitions)
+	}
+	for index, expectedTransition := range expectedTransitions {
+		if transition := powerState.Transitions[index]; transition.From != expectedTransition.from || transition.To != expectedTransition.to {
+			t.Errorf("transition %v from %v to %v, expected from %v to %v", index, transition.From, transition.To, expectedTransition.from, expectedTransition.to)
+		}
+	}
+}
@@ -0,0 +1,156 @@
+package main
+
+// Condition that nobody has used the device for some time, so commands don't slow it down for the user
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const idleConditionName = "idle"
+
+func init() {
+	registerCondition(idleConditionName, newIdleCondition)
+}
+
+// where the idle time is read from
+const (
+	idleSourceAutomatic    = "auto"
+	idleSourceLogind       = "logind"
+	idleSourceInputDevices = "input_devices"
+)
+
+const pathToInputDevicesDirectory = "/dev/input"
+
+// idleCondition is met when the user has been idle for at least the minimum idle duration
+type idleCondition struct {
+	minimumIdleDuration time.Duration
+	source              string
+}
+
+func newIdleCondition(parameters ConditionParameters) (Condition, error) {
+	if err := parameters.ensureOnlyKnownKeys("minimum_idle_duration", "source"); err != nil {
+		return nil, err
+	}
+
+	condition := idleCondition{}
+	var err error
+	if condition.minimumIdleDuration, err = parameters.durationValue("minimum_idle_duration", 10*time.Minute); err != nil {
+		return nil, err
+	}
+	if condition.source, err = parameters.stringValue("source", idleSourceAutomatic); err != nil {
+		return nil, err
+	}
+	knownSources := []string{idleSourceAutomatic, idleSourceLogind, idleSourceInputDevices}
+	if !isStringInSlice(condition.source, knownSources) {
+		return nil, fmt.Errorf("parameter \"source\" must be one of %v, but is %q", knownSources, condition.source)
+	}
+	return condition, nil
+}
+
+func (condition idleCondition) Name() string {
+	return idleConditionName
+}
+
+func (condition idleCondition) Evaluate() ConditionResult {
+	idleDuration, err := condition.idleDuration()
+	if err != nil {
+		return ConditionResult{Met: false, ExplanationWhyUnmet: fmt.Sprint("could not determine how long the user has been idle: ", err)}
+	}
+
+	if idleDuration < condition.minimumIdleDuration {
+		return ConditionResult{
+			Met: false,
+			ExplanationWhyUnmet: fmt.Sprintf("user has only been idle for %v, but %v are required",
+				idleDuration.Round(time.Second), condition.minimumIdleDuration),
+		}
+	}
+	return ConditionResult{Met: true}
+}
+
+func (condition idleCondition) idleDuration() (time.Duration, error) {
+	switch condition.source {
+	case idleSourceLogind:
+		return idleDurationFromLogind()
+	case idleSourceInputDevices:
+		return idleDurationFromInputDevices()
+	default:
+		// logind knows about all sessions, the input devices are only a fallback
+		// for systems without it
+		idleDuration, logindError := idleDurationFromLogind()
+		if logindError == nil {
+			return idleDuration, nil
+		}
+		idleDuration, inputDevicesError := idleDurationFromInputDevices()
+		if inputDevicesError != nil {
+			return 0, fmt.Errorf("logind: %v, input devices: %v", logindError, inputDevicesError)
+		}
+		return idleDuration, nil
+	}
+}
+
+// reads the IdleHint and IdleSinceHint properties of logind over D-Bus with busctl,
+// which ships with systemd and saves us from speaking D-Bus ourselves
+func idleDurationFromLogind() (time.Duration, error) {
+	program, cancel := conditionProgram("busctl", "--system", "get-property",
+		"org.freedesktop.login1", "/org/freedesktop/login1", "org.freedesktop.login1.Manager",
+		"IdleHint", "IdleSinceHint")
+	defer cancel()
+	output, err := program.Output()
+	if err != nil {
+		return 0, err
+	}
+
+	// output has one line per property like "b true" and "t 1602000000000000",
+	// the D-Bus type followed by the value
+	lines := strings.Split(strings.TrimSpace(string(output)), "\n")
+	if len(lines) != 2 {
+		return 0, fmt.Errorf("unexpected answer from logind: %q", output)
+	}
+	idleHintFields := strings.Fields(lines[0])
+	idleSinceHintFields := strings.Fields(lines[1])
+	if len(idleHintFields) != 2 || idleHintFields[0] != "b" || len(idleSinceHintFields) != 2 || idleSinceHintFields[0] != "t" {
+		return 0, fmt.Errorf("unexpected answer from logind: %q", output)
+	}
+
+	if idleHintFields[1] != "true" {
+		// somebody is using a session right now
+		return 0, nil
+	}
+
+	// microseconds since the unix epoch
+	idleSinceMicroseconds, err := strconv.ParseInt(idleSinceHintFields[1], 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("unexpected idle since hint from logind: %v", err)
+	}
+	idleSince := time.Unix(0, idleSinceMicroseconds*int64(time.Microsecond))
+	return time.Since(idleSince), nil
+}
+
+// uses the newest modification time of the input device files as the time of the last input
+func idleDurationFromInputDevices() (time.Duration, error) {
+	inputDevices, err := ioutil.ReadDir(pathToInputDevicesDirectory)
+	if err != nil {
+		return 0, err
+	}
+
+	lastInput := time.Time{}
+	for _, currentInputDevice := range inputDevices {
+		// event devices get every input, the other ones are only legacy interfaces to them
+		if matched, _ := filepath.Match("event*", currentInputDevice.Name()); !matched {
+			continue
+		}
+		if currentInputDevice.ModTime().After(lastInput) {
+			lastInput = currentInputDevice.ModTime()
+		}
+	}
+
+	if lastInput.IsZero() {
+		return 0, fmt.Errorf("no input devices found in %v", pathToInputDevicesDirectory)
+	}
+	return time.Since(lastInput), nil
+}
@@ -0,0 +1,292 @@
+package main
+
+// Keeps track of the commands that are running right now, so they can be paused or stopped
+// when their conditions are not met anymore
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ConditionLostPolicy is what happens to a running command when one of its conditions is not met anymore
+type ConditionLostPolicy string
+
+// policies for running commands whose conditions are not met anymore
+const (
+	// let the command run to completion, like before conditions were checked while running
+	ContinueWhenConditionLost ConditionLostPolicy = "continue"
+	// stop the process group and continue it once the conditions are met again
+	PauseWhenConditionLost ConditionLostPolicy = "pause"
+	// ask the process group to terminate and kill it after a grace period
+	TerminateWhenConditionLost ConditionLostPolicy = "terminate"
+	KillWhenConditionLost      ConditionLostPolicy = "kill"
+)
+
+const defaultTerminateGracePeriod = 30 * time.Second
+
+// empty means continue, so commands from configs without a policy behave like before
+func parseConditionLostPolicy(policy string) (ConditionLostPolicy, error) {
+	switch ConditionLostPolicy(policy) {
+	case "":
+		return ContinueWhenConditionLost, nil
+	case ContinueWhenConditionLost, PauseWhenConditionLost, TerminateWhenConditionLost, KillWhenConditionLost:
+		return ConditionLostPolicy(policy), nil
+	default:
+		return "", fmt.Errorf("unknown policy %q for when a condition is lost, known policies are: %v", policy,
+			[]ConditionLostPolicy{ContinueWhenConditionLost, PauseWhenConditionLost, TerminateWhenConditionLost, KillWhenConditionLost})
+	}
+}
+
+// runningJob is a process started for a command
+type runningJob struct {
+	processID int
+	paused    bool
+	// paused with the pause subcommand, it is only resumed with the resume subcommand
+	pausedByUser bool
+	// state the command ends up in because the job was stopped, empty when it was not stopped
+	stoppedWithState CommandState
+	// held while writing to the store that the job was paused or resumed, so such a write can't land
+	// after the state the command ends up in once the job exited
+	stateWriteMutex sync.Mutex
+}
+
+// jobs started by this daemon that have not exited yet
+var runningJobs = struct {
+	sync.Mutex
+	byCommandUUID map[uuid.UUID]*runningJob
+}{byCommandUUID: make(map[uuid.UUID]*runningJob)}
+
+func registerRunningJob(uuidOfCommand uuid.UUID, processID int) {
+	runningJobs.Lock()
+	defer runningJobs.Unlock()
+	runningJobs.byCommandUUID[uuidOfCommand] = &runningJob{processID: processID}
+}
+
+// removes the job after its process exited and returns it, so it is known whether it was stopped
+func unregisterRunningJob(uuidOfCommand uuid.UUID) *runningJob {
+	runningJobs.Lock()
+	job, found := runningJobs.byCommandUUID[uuidOfCommand]
+	if !found {
+		runningJobs.Unlock()
+		return &runningJob{}
+	}
+	delete(runningJobs.byCommandUUID, uuidOfCommand)
+	runningJobs.Unlock()
+
+	// waits for a pause or resume that is still writing its state, later ones see the job is gone
+	job.stateWriteMutex.Lock()
+	job.stateWriteMutex.Unlock()
+	return job
+}
+
+func isJobOfCommandRunning(uuidOfCommand uuid.UUID) bool {
+	runningJobs.Lock()
+	defer runningJobs.Unlock()
+	_, found := runningJobs.byCommandUUID[uuidOfCommand]
+	return found
+}
+
+// checks the conditions of a command that is running and pauses, resumes or stops it
+// according to its policy
+func enforceConditionLostPolicy(command CommandWithArguments) {
+	if command.OnConditionLost == ContinueWhenConditionLost || command.OnConditionLost == "" {
+		return
+	}
+
+	conditions, err := buildConditionsForCommand(command)
+	if err != nil {
+		logError("Could not set up conditions of running command, leaving it running", commandLogFields(command, errorLogField(err))...)
+		return
+	}
+	allConditionsMet, results := evaluateConditions(conditions)
+	rememberConditionResults(command.UUID, results)
+
+	if job := applyConditionLostPolicyToJob(command, allConditionsMet, results); job != nil {
+		writeStateOfJob(command, job)
+	}
+}
+
+// pauses, resumes or stops the job of the command while holding the lock on the running jobs,
+// returns the job if it was paused or resumed, so its new state can be written afterwards
+func applyConditionLostPolicyToJob(command CommandWithArguments, allConditionsMet bool, results []ConditionResult) *runningJob {
+	runningJobs.Lock()
+	defer runningJobs.Unlock()
+
+	job, found := runningJobs.byCommandUUID[command.UUID]
+	// already stopped jobs only have to exit, the user decides about jobs they paused
+	if !found || job.stoppedWithState != "" || job.pausedByUser {
+		return nil
+	}
+
+	if allConditionsMet {
+		if job.paused && resumeJob(command, job, "because its conditions are met again") {
+			return job
+		}
+		return nil
+	}
+
+	for _, currentResult := range results {
+		if !currentResult.Met {
+			logInfo("Condition of running command is not met anymore",
+				commandLogFields(command, logFieldOf("condition", currentResult.ConditionName), logFieldOf("explanation", currentResult.ExplanationWhyUnmet))...)
+		}
+	}
+
+	switch command.OnConditionLost {
+	case PauseWhenConditionLost:
+		if !job.paused && pauseJob(command, job, "until its conditions are met again") {
+			return job
+		}
+	case TerminateWhenConditionLost:
+		terminateJob(command, job)
+	case KillWhenConditionLost:
+		killJob(command, job)
+	}
+	return nil
+}
+
+// writes whether the job is paused or running into the store, after the lock on the running jobs
+// was released, because the store can be slow and every other job would wait for it
+func writeStateOfJob(command CommandWithArguments, job *runningJob) {
+	job.stateWriteMutex.Lock()
+	defer job.stateWriteMutex.Unlock()
+
+	// what the job is doing now counts, a pause or resume after the one this write is for might
+	// have written its state already
+	runningJobs.Lock()
+	stillRunning := runningJobs.byCommandUUID[command.UUID] == job && job.stoppedWithState == ""
+	state := CommandRunning
+	if job.paused {
+		state = CommandPaused
+	}
+	runningJobs.Unlock()
+
+	if !stillRunning {
+		return
+	}
+	if err := changeStateOfCommand(command.UUID, state); err != nil {
+		logError("Error when changing state of command", commandLogFields(command, logFieldOf("state", state), errorLogField(err))...)
+	}
+}
+
+// pauses a running command until the user resumes it, no matter its conditions
+func pauseJobOfCommandByUser(command CommandWithArguments) error {
+	job, err := pauseJobByUser(command)
+	if job != nil {
+		writeStateOfJob(command, job)
+	}
+	return err
+}
+
+// returns the job if it was paused right now
+func pauseJobByUser(command CommandWithArguments) (*runningJob, error) {
+	runningJobs.Lock()
+	defer runningJobs.Unlock()
+
+	job, found := runningJobs.byCommandUUID[command.UUID]
+	if !found || job.stoppedWithState != "" {
+		return nil, fmt.Errorf("command %v is not running", command.Name)
+	}
+	job.pausedByUser = true
+	if job.paused {
+		return nil, nil
+	}
+	if !pauseJob(command, job, "because the user asked for it") {
+		job.pausedByUser = false
+		return nil, fmt.Errorf("could not pause command %v", command.Name)
+	}
+	return job, nil
+}
+
+// resumes a paused command, its conditions are checked again afterwards and might pause it again
+func resumeJobOfCommandByUser(command CommandWithArguments) error {
+	job, err := resumeJobByUser(command)
+	if job != nil {
+		writeStateOfJob(command, job)
+	}
+	return err
+}
+
+// returns the job if it was resumed
+func resumeJobByUser(command CommandWithArguments) (*runningJob, error) {
+	runningJobs.Lock()
+	defer runningJobs.Unlock()
+
+	job, found := runningJobs.byCommandUUID[command.UUID]
+	if !found || job.stoppedWithState != "" {
+		return nil, fmt.Errorf("command %v is not running", command.Name)
+	}
+	if !job.paused {
+		return nil, fmt.Errorf("command %v is not paused", command.Name)
+	}
+	if !resumeJob(command, job, "because the user asked for it") {
+		return nil, fmt.Errorf("could not resume command %v", command.Name)
+	}
+	job.pausedByUser = false
+	return job, nil
+}
+
+// the following functions are called with the lock on the running jobs held, they don't touch
+// the store, whoever called them writes the new state with writeStateOfJob once the lock is released
+
+// whether the job is paused now
+func pauseJob(command CommandWithArguments, job *runningJob, reason string) bool {
+	logInfo("Pausing command "+reason, commandLogFields(command)...)
+	if err := pauseProcessGroup(job.processID); err != nil {
+		logError("Could not pause command", commandLogFields(command, errorLogField(err))...)
+		return false
+	}
+	job.paused = true
+	return true
+}
+
+// whether the job is running again now
+func resumeJob(command CommandWithArguments, job *runningJob, reason string) bool {
+	logInfo("Resuming command "+reason, commandLogFields(command)...)
+	if err := resumeProcessGroup(job.processID); err != nil {
+		logError("Could not resume command", commandLogFields(command, errorLogField(err))...)
+		return false
+	}
+	job.paused = false
+	return true
+}
+
+func terminateJob(command CommandWithArguments, job *runningJob) {
+	gracePeriod := command.TerminateGracePeriod
+	if gracePeriod == 0 {
+		gracePeriod = defaultTerminateGracePeriod
+	}
+
+	logInfo("Terminating command and killing it if it still runs after the grace period", commandLogFields(command, logFieldOf("grace_period", gracePeriod))...)
+	if err := terminateProcessGroup(job.processID); err != nil {
+		logError("Could not terminate command", commandLogFields(command, errorLogField(err))...)
+		return
+	}
+	job.stoppedWithState = CommandTerminated
+
+	time.AfterFunc(gracePeriod, func() {
+		runningJobs.Lock()
+		defer runningJobs.Unlock()
+
+		// the job is only still registered under the uuid if it did not exit in the meantime
+		// and no new job of the same command was started, which can't happen before it exited
+		if runningJobs.byCommandUUID[command.UUID] == job {
+			logWarning("Command did not exit within the grace period after being terminated, killing it", commandLogFields(command, logFieldOf("grace_period", gracePeriod))...)
+			killJob(command, job)
+			// it was terminated first, that's what counts
+			job.stoppedWithState = CommandTerminated
+		}
+	})
+}
+
+func killJob(command CommandWithArguments, job *runningJob) {
+	logInfo("Killing command", commandLogFields(command)...)
+	if err := killProcessGroup(job.processID); err != nil {
+		logError("Could not kill command", commandLogFields(command, errorLogField(err))...)
+		return
+	}
+	job.stoppedWithState = CommandKilled
+}
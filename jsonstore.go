@@ -0,0 +1,160 @@
+package main
+
+// The command store and the run history as JSON files, every change rereads and rewrites the whole file
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+)
+
+// Note on file locking:
+// create file locks in functions and not once, so different goroutines
+// create and have different locks
+// and thus have to wait for the other lock on the same file being released
+// Waiting for other processes also is handled by that, because file lock
+// is visible to other processes through OS mechanisms
+// The lock is on commandStore.json.lock and not the command store itself, see lockOfFile
+
+var pathToCommandStoreFile = "./commandStore.json"
+
+// previous versions of the command store that are kept, in case the current one gets corrupted
+const numberOfCommandStoreBackups = 3
+
+// jsonFileStore keeps the command store and the run history in JSON files, chosen with WORKSCHEDULER_STORE=json
+type jsonFileStore struct {
+	pathToCommandStoreFile string
+	pathToRunHistoryFile   string
+}
+
+func (store jsonFileStore) Read() (CommandStore, error) {
+	return readAndParseCommandStoreFromFile(store.pathToCommandStoreFile, false)
+}
+
+func (store jsonFileStore) Update(change func(commandStore *CommandStore) error) error {
+	var fileLockOnCommandStoreFile = lockOfFile(store.pathToCommandStoreFile)
+
+	// locking for reading, modifying and writing command store
+	// todo handle/relay errors when locking
+	fileLockOnCommandStoreFile.Lock()
+	defer fileLockOnCommandStoreFile.Unlock()
+
+	commandStore, readError := readAndParseCommandStoreFromFile(store.pathToCommandStoreFile, true)
+	if readError != nil {
+		return readError
+	}
+	if err := change(&commandStore); err != nil {
+		return err
+	}
+	return marshalAndWriteCommandStoreToFile(store.pathToCommandStoreFile, commandStore)
+}
+
+func (store jsonFileStore) AddRun(run RunRecord, retention HistoryRetention) ([]RunRecord, error) {
+	return addRunToRunHistoryFile(store.pathToRunHistoryFile, run, retention)
+}
+
+func (store jsonFileStore) RunsOfCommand(commandName string) ([]RunRecord, error) {
+	runHistory, err := readAndParseRunHistoryFromFile(store.pathToRunHistoryFile, false)
+	if err != nil {
+		return []RunRecord{}, err
+	}
+	runs := make([]RunRecord, 0)
+	for _, currentRun := range runHistory.Runs {
+		if currentRun.CommandName == commandName {
+			runs = append(runs, currentRun)
+		}
+	}
+	return runs, nil
+}
+
+func readAndParseCommandStoreFromFile(pathToCommandStoreFile string, alreadyLocked bool) (CommandStore, error) {
+
+	var fileLockOnCommandStoreFile = lockOfFile(pathToCommandStoreFile)
+
+	if !alreadyLocked {
+		fileLockOnCommandStoreFile.Lock()
+		defer fileLockOnCommandStoreFile.Unlock()
+	}
+
+	commandStore := CommandStore{}
+	marshalledJSONData, readingError := ioutil.ReadFile(pathToCommandStoreFile)
+	if readingError != nil {
+		// nothing was stored yet
+		if os.IsNotExist(readingError) {
+			return commandStore, nil
+		}
+		countStoreError("command_store", "read")
+		return commandStore, readingError
+	}
+
+	// Empty file is not valid json, so just return empty command store here before trying to unmarshal.
+	// Older versions created it by locking the command store itself.
+	// A write to the command store will create valid json in the future.
+	if len(marshalledJSONData) == 0 {
+		return commandStore, nil
+	}
+
+	commandStore, unmarshalError := parseCommandStore(marshalledJSONData)
+	if unmarshalError != nil {
+		countStoreError("command_store", "read")
+		return readNewestValidBackupOfCommandStore(pathToCommandStoreFile, unmarshalError)
+	}
+
+	return commandStore, nil
+}
+
+func parseCommandStore(marshalledJSONData []byte) (CommandStore, error) {
+	commandStore := CommandStore{}
+	err := json.Unmarshal(marshalledJSONData, &commandStore)
+	return commandStore, err
+}
+
+// only a command store that can be read again is worth a backup, a corrupted one or an empty file of older versions is not
+func isParsableCommandStore(marshalledJSONData []byte) bool {
+	_, err := parseCommandStore(marshalledJSONData)
+	return err == nil
+}
+
+// a corrupted command store would stop all scheduling, so the newest backup that is fine is used instead
+// until the next write replaces the corrupted file, returns the original error if no backup is fine
+func readNewestValidBackupOfCommandStore(pathToCommandStoreFile string, unmarshalError error) (CommandStore, error) {
+	for generation := 1; generation <= numberOfCommandStoreBackups; generation++ {
+		pathToBackup := pathOfBackup(pathToCommandStoreFile, generation)
+		marshalledJSONData, err := ioutil.ReadFile(pathToBackup)
+		if err != nil {
+			continue
+		}
+		backupOfCommandStore, err := parseCommandStore(marshalledJSONData)
+		if err != nil {
+			continue
+		}
+		logError("!!! THE COMMAND STORE IS CORRUPTED, USING ITS NEWEST VALID BACKUP INSTEAD !!! "+
+			"Changes made after the backup are lost, the corrupted file is replaced with the next change of the command store.",
+			logFieldOf("file", pathToCommandStoreFile), logFieldOf("backup", pathToBackup), errorLogField(unmarshalError))
+		return backupOfCommandStore, nil
+	}
+	return CommandStore{}, fmt.Errorf("command store %v is corrupted and there is no valid backup of it: %w", pathToCommandStoreFile, unmarshalError)
+}
+
+// never called directly from scheduling logic, only through Update of the JSON store
+// so the command store file will be already locked
+func marshalAndWriteCommandStoreToFile(pathToCommandStoreFile string, commandStore CommandStore) error {
+
+	// prefix new lines with nothing, indent with tabs
+	marshalledJSONData, marshalError := json.MarshalIndent(&commandStore, "", "\t")
+
+	if marshalError != nil {
+		countStoreError("command_store", "write")
+		return marshalError
+	}
+
+	// replace configured data store file with provided data, the new file is only readable
+	// and writeable by own user, the old one is kept as a backup
+	writeError := writeFileAtomically(pathToCommandStoreFile, marshalledJSONData, numberOfCommandStoreBackups, isParsableCommandStore)
+	if writeError != nil {
+		countStoreError("command_store", "write")
+		return writeError
+	}
+	return nil
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func TestJSONStoreFallsBackToNewestValidBackup(t *testing.T) {
+	validStore := `{"Commands": [{"Name": "from-backup"}]}`
+	testCases := []struct {
+		name                string
+		current             string
+		backups             []string
+		expectedCommandName string
+		expectedError       bool
+	}{
+		{"current is fine", `{"Commands": [{"Name": "current"}]}`, []string{validStore}, "current", false},
+		{"current is empty", "", []string{validStore}, "", false},
+		{"newest backup", "{broken", []string{validStore, `{"Commands": [{"Name": "older"}]}`}, "from-backup", false},
+		{"skips broken backups", "{broken", []string{"{broken", "", validStore}, "from-backup", false},
+		{"no valid backup", "{broken", []string{"{broken"}, "", true},
+		{"wrong type", `{"Commands": "none"}`, []string{validStore}, "from-backup", false},
+	}
+	for _, testCase := range testCases {
+		pathToFile := filepath.Join(t.TempDir(), "commandStore.json")
+		writeTestFile(t, pathToFile, testCase.current)
+		for index, backup := range testCase.backups {
+			writeTestFile(t, pathOfBackup(pathToFile, index+1), backup)
+		}
+
+		commandStore, err := jsonFileStore{pathToCommandStoreFile: pathToFile}.Read()
+		if (err != nil) != testCase.expectedError {
+			t.Errorf("%v: error %v", testCase.name, err)
+			continue
+		}
+		commandName := ""
+		if len(commandStore.Commands) > 0 {
+			commandName = commandStore.Commands[0].Name
+		}
+		if commandName != testCase.expectedCommandName {
+			t.Errorf("%v: read command %q, expected %q", testCase.name, commandName, testCase.expectedCommandName)
+		}
+	}
+}
+
+// a corrupted command store must not push out the valid backups when it is written over
+func TestJSONStoreDoesNotBackUpCorruptedStore(t *testing.T) {
+	pathToFile := filepath.Join(t.TempDir(), "commandStore.json")
+	store := jsonFileStore{pathToCommandStoreFile: pathToFile}
+	for _, commandName := range []string{"first", "second", "third", "fourth"} {
+		err := store.Update(func(commandStore *CommandStore) error {
+			commandStore.Commands = append(commandStore.Commands, CommandWithArguments{Name: commandName})
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for i := 0; i < numberOfCommandStoreBackups+1; i++ {
+		writeTestFile(t, pathToFile, "{broken")
+		if err := store.Update(func(commandStore *CommandStore) error { return nil }); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	backupOfCommandStore, err := parseCommandStore([]byte(readFileOrEmpty(t, pathOfBackup(pathToFile, numberOfCommandStoreBackups))))
+	if err != nil {
+		t.Fatalf("oldest backup is not readable anymore: %v", err)
+	}
+	if len(backupOfCommandStore.Commands) != 1 || backupOfCommandStore.Commands[0].Name != "first" {
+		t.Errorf("oldest backup has %v, expected only the first command", backupOfCommandStore.Commands)
+	}
+}
+
+func writeTestFile(t *testing.T, pathToFile string, content string) {
+	t.Helper()
+	if err := ioutil.WriteFile(pathToFile, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,156 @@
+package main
+
+// Output of running commands is sent line by line to followers, e.g. `logs --follow`,
+// so a long running command is not a black box until it ends
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sync"
+)
+
+// lines a follower may lag behind before it is dropped, the command must never wait for a follower
+const maximumLinesBehindOfFollower = 4096
+
+// liveOutput are the followers of the output of one run
+type liveOutput struct {
+	mutex     sync.Mutex
+	writers   []*cappedLogWriter
+	followers map[*outputFollower]bool
+	// run ended, nothing will be sent anymore
+	finished bool
+}
+
+// outputFollower gets the lines of a run after the part that was already in the log files when it started following
+type outputFollower struct {
+	lines chan []byte
+	// parts of the log files up to where the lines start
+	alreadyWrittenOutput []logFileSegment
+	// set before lines is closed, when the follower did not read the lines fast enough
+	fellBehind bool
+}
+
+// logFileSegment is the beginning of a log file up to a number of bytes
+type logFileSegment struct {
+	path          string
+	numberOfBytes int64
+}
+
+func newLiveOutput() *liveOutput {
+	return &liveOutput{followers: map[*outputFollower]bool{}}
+}
+
+// the mutex has to be held
+func (live *liveOutput) sendToFollowers(line []byte) {
+	if len(live.followers) == 0 {
+		return
+	}
+	// the writer reuses its buffer
+	lineCopy := append([]byte{}, line...)
+	for follower := range live.followers {
+		select {
+		case follower.lines <- lineCopy:
+		default:
+			follower.fellBehind = true
+			close(follower.lines)
+			delete(live.followers, follower)
+		}
+	}
+}
+
+func (live *liveOutput) follow() (*outputFollower, error) {
+	live.mutex.Lock()
+	defer live.mutex.Unlock()
+
+	if live.finished {
+		return nil, fmt.Errorf("run already finished")
+	}
+	follower := &outputFollower{lines: make(chan []byte, maximumLinesBehindOfFollower)}
+	// all writers are locked by the mutex, so no line is missing or sent twice between the files and the lines
+	for _, currentWriter := range live.writers {
+		follower.alreadyWrittenOutput = append(follower.alreadyWrittenOutput,
+			logFileSegment{path: currentWriter.path, numberOfBytes: currentWriter.bytesSentToFollowers()})
+	}
+	live.followers[follower] = true
+	return follower, nil
+}
+
+func (live *liveOutput) unfollow(follower *outputFollower) {
+	live.mutex.Lock()
+	defer live.mutex.Unlock()
+	// might already be closed because it fell behind or the run finished
+	if live.followers[follower] {
+		delete(live.followers, follower)
+		close(follower.lines)
+	}
+}
+
+// ends following for everyone after the last output of the run was sent
+func (live *liveOutput) finish() {
+	live.mutex.Lock()
+	defer live.mutex.Unlock()
+	live.finished = true
+	for follower := range live.followers {
+		close(follower.lines)
+		delete(live.followers, follower)
+	}
+}
+
+// writes the output of the run from its beginning and then every new line until the run ends
+func (follower *outputFollower) copyOutputTo(destination io.Writer) error {
+	// with separate standard error, the output of it comes after the output of standard out
+	for _, currentSegment := range follower.alreadyWrittenOutput {
+		logFile, err := os.Open(currentSegment.path)
+		if err != nil {
+			return err
+		}
+		_, err = io.CopyN(destination, logFile, currentSegment.numberOfBytes)
+		logFile.Close()
+		if err != nil {
+			return err
+		}
+	}
+
+	for line := range follower.lines {
+		if _, err := destination.Write(line); err != nil {
+			return err
+		}
+	}
+	if follower.fellBehind {
+		return fmt.Errorf("output came faster than it could be sent, stopped following")
+	}
+	return nil
+}
+
+// live outputs of the commands that are running right now, by command name
+var liveOutputsOfRunningCommands = map[string]*liveOutput{}
+var liveOutputsOfRunningCommandsMutex sync.Mutex
+
+func registerLiveOutput(commandName string, live *liveOutput) {
+	liveOutputsOfRunningCommandsMutex.Lock()
+	defer liveOutputsOfRunningCommandsMutex.Unlock()
+	liveOutputsOfRunningCommands[commandName] = live
+}
+
+func unregisterLiveOutput(commandName string) {
+	liveOutputsOfRunningCommandsMutex.Lock()
+	defer liveOutputsOfRunningCommandsMutex.Unlock()
+	delete(liveOutputsOfRunningCommands, commandName)
+}
+
+// starts following the output of the current run of a command
+func followOutputOfCommand(commandName string) (*outputFollower, *liveOutput, error) {
+	liveOutputsOfRunningCommandsMutex.Lock()
+	live, isRunning := liveOutputsOfRunningCommands[commandName]
+	liveOutputsOfRunningCommandsMutex.Unlock()
+	if !isRunning {
+		return nil, nil, fmt.Errorf("command %v is not running right now", commandName)
+	}
+
+	follower, err := live.follow()
+	if err != nil {
+		return nil, nil, fmt.Errorf("command %v is not running right now: %v", commandName, err)
+	}
+	return follower, live, nil
+}
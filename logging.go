@@ -0,0 +1,273 @@
+package main
+
+// Leveled, structured log messages of the daemon, written as text or JSON lines to standard error
+// or sent natively to journald, so they can be filtered by level and by the command they are about
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type logLevel int
+
+const (
+	logLevelDebug logLevel = iota
+	logLevelInfo
+	logLevelWarning
+	logLevelError
+)
+
+var namesOfLogLevels = map[logLevel]string{
+	logLevelDebug:   "debug",
+	logLevelInfo:    "info",
+	logLevelWarning: "warning",
+	logLevelError:   "error",
+}
+
+// syslog priorities, journalctl -p filters by them
+var journaldPriorityOfLogLevel = map[logLevel]int{
+	logLevelDebug:   7,
+	logLevelInfo:    6,
+	logLevelWarning: 4,
+	logLevelError:   3,
+}
+
+func parseLogLevel(name string) (logLevel, error) {
+	for level, currentName := range namesOfLogLevels {
+		if strings.EqualFold(name, currentName) {
+			return level, nil
+		}
+	}
+	return logLevelInfo, fmt.Errorf("unknown log level %q, known are debug, info, warning and error", name)
+}
+
+const (
+	logFormatText     = "text"
+	logFormatJSON     = "json"
+	logFormatJournald = "journald"
+)
+
+// where journald receives messages in its native protocol
+const pathToJournaldSocket = "/run/systemd/journal/socket"
+
+// identifies messages of the daemon in the journal, like journalctl -t workscheduler
+const syslogIdentifier = "workscheduler"
+
+// logField is a named value of a log message, like the name of the command it is about
+type logField struct {
+	key   string
+	value interface{}
+}
+
+func logFieldOf(key string, value interface{}) logField {
+	return logField{key: key, value: value}
+}
+
+func errorLogField(err error) logField {
+	return logFieldOf("error", err)
+}
+
+// name and uuid of a command, so all messages about it can be found
+func commandLogFields(command CommandWithArguments, moreFields ...logField) []logField {
+	fields := append([]logField{logFieldOf("command", command.Name), logFieldOf("uuid", command.UUID)}, moreFields...)
+	// full, so appending more fields for one message always copies and never changes what others see
+	return fields[:len(fields):len(fields)]
+}
+
+type logger struct {
+	mutex        sync.Mutex
+	minimumLevel logLevel
+	format       string
+	output       io.Writer
+	// only for the journald format
+	journal net.Conn
+}
+
+var daemonLogger = &logger{minimumLevel: logLevelInfo, format: logFormatText, output: os.Stderr}
+
+// chooses how the daemon logs, the journald format fails if journald can't be reached
+func configureLogging(format string, minimumLevel logLevel) error {
+	var journal net.Conn
+	switch format {
+	case logFormatText, logFormatJSON:
+	case logFormatJournald:
+		var err error
+		journal, err = net.Dial("unixgram", pathToJournaldSocket)
+		if err != nil {
+			return fmt.Errorf("could not connect to journald: %w", err)
+		}
+	default:
+		return fmt.Errorf("unknown log format %q, known are text, json and journald", format)
+	}
+
+	daemonLogger.mutex.Lock()
+	defer daemonLogger.mutex.Unlock()
+	if daemonLogger.journal != nil {
+		daemonLogger.journal.Close()
+	}
+	daemonLogger.format = format
+	daemonLogger.minimumLevel = minimumLevel
+	daemonLogger.journal = journal
+	return nil
+}
+
+func logDebug(message string, fields ...logField) {
+	daemonLogger.log(logLevelDebug, message, fields)
+}
+
+func logInfo(message string, fields ...logField) {
+	daemonLogger.log(logLevelInfo, message, fields)
+}
+
+func logWarning(message string, fields ...logField) {
+	daemonLogger.log(logLevelWarning, message, fields)
+}
+
+func logError(message string, fields ...logField) {
+	daemonLogger.log(logLevelError, message, fields)
+}
+
+func (logger *logger) log(level logLevel, message string, fields []logField) {
+	logger.mutex.Lock()
+	defer logger.mutex.Unlock()
+
+	if level < logger.minimumLevel {
+		return
+	}
+	now := time.Now()
+
+	switch logger.format {
+	case logFormatJSON:
+		logger.output.Write(formatJSONLogLine(now, level, message, fields))
+	case logFormatJournald:
+		// e.g. too big for a datagram, then at least standard error has it, which systemd usually
+		// sends to the journal too
+		if _, err := logger.journal.Write(formatJournaldEntry(level, message, fields)); err != nil {
+			logger.output.Write(formatTextLogLine(now, level, message, append(fields, logFieldOf("journald_error", err))))
+		}
+	default:
+		logger.output.Write(formatTextLogLine(now, level, message, fields))
+	}
+}
+
+// errors, durations, uuids and the like are logged as their text
+func plainLogValue(value interface{}) interface{} {
+	switch typedValue := value.(type) {
+	case nil:
+		return ""
+	case error:
+		return typedValue.Error()
+	case fmt.Stringer:
+		return typedValue.String()
+	case string, bool, int, int64, uint64, float64:
+		return typedValue
+	default:
+		return fmt.Sprint(typedValue)
+	}
+}
+
+// like 2026-01-02T15:04:05.000+01:00 INFO  Executing command command=backup uuid=...
+func formatTextLogLine(now time.Time, level logLevel, message string, fields []logField) []byte {
+	var line bytes.Buffer
+	fmt.Fprintf(&line, "%v %-7v %v", now.Format("2006-01-02T15:04:05.000Z07:00"), strings.ToUpper(namesOfLogLevels[level]), message)
+	for _, currentField := range fields {
+		value := fmt.Sprint(plainLogValue(currentField.value))
+		// only quoted if needed, so most lines stay easy to read
+		if value == "" || strings.ContainsAny(value, " =\"\n\t") {
+			value = strconv.Quote(value)
+		}
+		fmt.Fprintf(&line, " %v=%v", currentField.key, value)
+	}
+	line.WriteString("\n")
+	return line.Bytes()
+}
+
+// one JSON object per line with time, level and message first, then the fields
+func formatJSONLogLine(now time.Time, level logLevel, message string, fields []logField) []byte {
+	var line bytes.Buffer
+	writeJSONMember := func(key string, value interface{}) {
+		if line.Len() == 0 {
+			line.WriteString("{")
+		} else {
+			line.WriteString(",")
+		}
+		marshalledKey, _ := json.Marshal(key)
+		line.Write(marshalledKey)
+		line.WriteString(":")
+		// not escaping <, > and &, this is no HTML
+		encoder := json.NewEncoder(&line)
+		encoder.SetEscapeHTML(false)
+		if err := encoder.Encode(value); err != nil {
+			encoder.Encode(fmt.Sprint(value))
+		}
+		// the encoder ends every value with a new line
+		line.Truncate(line.Len() - 1)
+	}
+
+	writeJSONMember("time", now.Format(time.RFC3339Nano))
+	writeJSONMember("level", namesOfLogLevels[level])
+	writeJSONMember("message", message)
+	for _, currentField := range fields {
+		writeJSONMember(currentField.key, plainLogValue(currentField.value))
+	}
+	line.WriteString("}\n")
+	return line.Bytes()
+}
+
+// a datagram of the native journal protocol, fields become upper case journal fields,
+// so journalctl COMMAND=backup shows everything about the command backup
+func formatJournaldEntry(level logLevel, message string, fields []logField) []byte {
+	var entry bytes.Buffer
+	writeJournaldField(&entry, "MESSAGE", message)
+	writeJournaldField(&entry, "PRIORITY", strconv.Itoa(journaldPriorityOfLogLevel[level]))
+	writeJournaldField(&entry, "SYSLOG_IDENTIFIER", syslogIdentifier)
+
+	// sorted, so an entry always looks the same
+	sortedFields := append([]logField{}, fields...)
+	sort.SliceStable(sortedFields, func(i, j int) bool { return sortedFields[i].key < sortedFields[j].key })
+	for _, currentField := range sortedFields {
+		writeJournaldField(&entry, journaldFieldName(currentField.key), fmt.Sprint(plainLogValue(currentField.value)))
+	}
+	return entry.Bytes()
+}
+
+// journal field names may only contain upper case letters, digits and underscores and may not start
+// with an underscore, those are reserved for fields journald adds itself
+func journaldFieldName(key string) string {
+	name := strings.Map(func(character rune) rune {
+		switch {
+		case character >= 'A' && character <= 'Z', character >= '0' && character <= '9':
+			return character
+		case character >= 'a' && character <= 'z':
+			return character - 'a' + 'A'
+		default:
+			return '_'
+		}
+	}, key)
+	name = strings.TrimLeft(name, "_")
+	if name == "" {
+		return "FIELD"
+	}
+	return name
+}
+
+func writeJournaldField(entry *bytes.Buffer, name string, value string) {
+	if !strings.Contains(value, "\n") {
+		entry.WriteString(name + "=" + value + "\n")
+		return
+	}
+	// values with new lines are sent with their length in front instead
+	entry.WriteString(name + "\n")
+	binary.Write(entry, binary.LittleEndian, uint64(len(value)))
+	entry.WriteString(value + "\n")
+}
@@ -4,71 +4,116 @@ import (
 	"fmt"
 	"os"
 	"os/exec"
+	"strings"
 	"time"
 
-	"github.com/distatus/battery"
 	"github.com/google/uuid"
 )
 
 func main() {
-	fmt.Println("Started WorkScheduler :)")
-
-	numberOfCommandLineArguments := len(os.Args)
-	// first argument is the path to this program itself, so more than 1 argument means user passed some command as argument
-	if numberOfCommandLineArguments >= 2 {
-		// we just add the command to the command store and exit
-
-		// careful, user supplied input!
-		commandToExecuteAbsolutePath := os.Args[1]
-		// todo: check if full absolute path, don't use path lookup as standard to prevent path injection attacks
-
-		var commandArguments []string
-		if numberOfCommandLineArguments >= 3 {
-			// careful, user supplied input!
-			commandArguments = os.Args[2:]
-		} else {
-			fmt.Println("Info: No arguments specified for the command to run.")
-		}
-		fmt.Println("Adding the following command to the command store for later execution...")
-		fmt.Printf("Absolute path: %q\n", commandToExecuteAbsolutePath)
+	os.Exit(runCommandLine(os.Args[1:]))
+}
 
-		fmt.Print("Argument list: ")
-		for _, currentArgument := range commandArguments {
-			fmt.Printf("%q ", currentArgument)
+// prints the runs of a command in a time up to now, arguments are the command name
+// and optionally how far back to look, like "720h"
+func printRunHistory(arguments []string) error {
+	commandName := arguments[0]
+	lookBack := 30 * 24 * time.Hour
+	if len(arguments) == 2 {
+		var err error
+		if lookBack, err = time.ParseDuration(arguments[1]); err != nil {
+			return err
 		}
-		fmt.Println()
+	}
 
-		newUUID, err := addCommandToCommandStore(commandToExecuteAbsolutePath, commandArguments, 999999999*time.Second, uuid.New().String())
-		if err != nil {
-			fmt.Println("Error when adding command to command store for later execution:", err)
-		} else {
-			fmt.Println("Successfully added with uuid:", newUUID)
-			fmt.Println("It will be executed later.")
+	runs, err := runsOfCommandSince(commandName, time.Now().Add(-lookBack))
+	if err != nil {
+		return err
+	}
+
+	runsByOutcome := countRunsByOutcome(runs)
+	fmt.Printf("%v runs of %v in the last %v: %v successful, %v failed, %v terminated, %v killed, %v interrupted\n",
+		len(runs), commandName, lookBack, runsByOutcome[CommandSuccessful], runsByOutcome[CommandFailed],
+		runsByOutcome[CommandTerminated], runsByOutcome[CommandKilled], runsByOutcome[CommandInterrupted])
+
+	for _, currentRun := range runs {
+		fmt.Printf("%v  %-10v  took %-12v  exit code %v", currentRun.StartTime.Format(time.RFC3339), currentRun.Outcome,
+			currentRun.Duration.Round(time.Second), currentRun.ExitCode)
+		if currentRun.Signal != "" {
+			fmt.Print(" (", currentRun.Signal, ")")
 		}
+		fmt.Println("  run", currentRun.RunID)
+	}
+	return nil
+}
 
-	} else {
-		runDaemonMode()
+// requests on the control socket that might let a command start wake up the daemon loop,
+// so it does not have to wait for its next check
+var daemonWakeUp = make(chan struct{}, 1)
+
+func wakeUpDaemon() {
+	select {
+	case daemonWakeUp <- struct{}{}:
+	default:
+		// already woken up and not yet checked
 	}
+}
 
+// daemonSettings are the flags of the daemon subcommand
+type daemonSettings struct {
+	// empty means no HTTP API
+	httpAPIAddress         string
+	pathToHTTPAPITokenFile string
+	// empty means metrics are only served by the HTTP API
+	metricsAddress string
 }
 
-func runDaemonMode() {
-	fmt.Println("No command to add to scheduled commands specified, running in daemon mode and executing stored commands when appropriate")
+func runDaemonMode(settings daemonSettings) {
+	logInfo("Started WorkScheduler :)")
+	logInfo("Running in daemon mode and executing stored commands when appropriate")
+
+	// before anything reads the store, otherwise it would ask the daemon, which is this process
+	if database, isDatabase := activeStore.(*databaseStore); isDatabase {
+		if err := database.keepOpen(); err != nil {
+			logError("Could not open the database of the command store, another daemon might be using it", errorLogField(err))
+			os.Exit(exitCodeError)
+		}
+	}
 
 	parseAllConfigFiles()
+	if err := fillInMissingAddedAtOfCommands(); err != nil {
+		logError("Could not fill in when old commands were added, their schedules might not match", errorLogField(err))
+	}
+	recoverCommandsLeftRunning()
 
-	for {
+	// debounce the power state in the background, so conditions don't see every flap of it
+	daemonPowerMonitor.start(powerMonitorSamplingInterval)
+
+	if err := startControlSocket(); err != nil {
+		logWarning("Could not listen on control socket, the command line client can't reach the daemon and can't follow output", errorLogField(err))
+	}
+
+	if settings.httpAPIAddress != "" {
+		// asked for explicitly, so running without it would be surprising
+		if err := startHTTPAPI(settings.httpAPIAddress, settings.pathToHTTPAPITokenFile); err != nil {
+			logError("Could not start HTTP API", errorLogField(err))
+			os.Exit(exitCodeError)
+		}
+	}
+	if settings.metricsAddress != "" {
+		if err := startMetricsServer(settings.metricsAddress); err != nil {
+			logError("Could not start metrics server", errorLogField(err))
+			os.Exit(exitCodeError)
+		}
+	}
 
-		waitUntilPowerPluggedIn()
+	for {
 
-		fmt.Println("Checking command store for commands to be run...")
+		logDebug("Checking command store for commands to be run...")
 		commandStore, err := readAndParseCommandStore()
 		if err != nil {
-			fmt.Println("Error when reading command store:", err)
-			fmt.Println("Trying again later (only when also plugged into external power).")
 			amountSeconds := 5
-			fmt.Println("Sleeping for", amountSeconds, "seconds...")
-			fmt.Println()
+			logError("Error when reading command store, trying again later", errorLogField(err), logFieldOf("retry_in_seconds", amountSeconds))
 			sleepForSeconds(amountSeconds)
 			continue
 		}
@@ -76,148 +121,146 @@ func runDaemonMode() {
 		startedExecutingAtLeastOneCommand := false
 		for _, currentCommand := range commandStore.Commands {
 
-			// ignore error, just use the returned true as fallback, we will check again later
-			runningOnBattery, _ := isDeviceRunningOnBatteryPower()
-			if runningOnBattery {
-				// don't schedule another command when running on battery
-				// go to beginning of outer for loop where we wait for computer to be plugged in again
-				break
+			// commands started by us that are still running might have to be paused or stopped
+			if isJobOfCommandRunning(currentCommand.UUID) {
+				enforceConditionLostPolicy(currentCommand)
+				continue
 			}
 
+			checkedAt := time.Now()
 			if !shouldCommandBeRun(currentCommand) {
 				continue
 			}
 
+			// written before anything slow, so the next check or a wake up doesn't start the command a second time
+			if err := changeStateOfCommand(currentCommand.UUID, CommandRunning); err != nil {
+				logError("Error when changing state of command, not starting it", commandLogFields(currentCommand, logFieldOf("state", CommandRunning), errorLogField(err))...)
+				continue
+			}
+
 			startedExecutingAtLeastOneCommand = true
 
 			// run current command asynchronously
 
 			// make function with argument here so each coroutine has its own copy of the
 			// respective current command and does not share one reference
-			go func(commandToRun CommandWithArguments) {
-				runRawCommandAndHandleErrors(commandToRun)
-			}(currentCommand)
+			go func(commandToRun CommandWithArguments, conditionsAtStart []ConditionResult) {
+				runRawCommandAndHandleErrors(commandToRun, conditionsAtStart)
+			}(currentCommand, conditionsEvaluatedSince(currentCommand.UUID, checkedAt))
 
 		}
 
 		if !startedExecutingAtLeastOneCommand {
-			fmt.Println("No command waiting to be run -> did not start new execution of a command.")
+			logDebug("No command waiting to be run -> did not start new execution of a command.")
 		}
 
 		// we ran all commands asynchronously (if any), wait a bit before checking again
-		// for new commands to be scheduled (even if we are still plugged into power)
+		// for new commands to be scheduled (even if their conditions are still met)
 		secondsToSleep := 10
-		fmt.Println("Sleeping for", secondsToSleep, "seconds...")
-		fmt.Println()
-		sleepForSeconds(secondsToSleep)
+		logDebug("Sleeping", logFieldOf("seconds", secondsToSleep))
+		select {
+		case <-time.After(multiplyDuration(secondsToSleep, time.Second)):
+		case <-daemonWakeUp:
+			logDebug("Woken up early by a request on the control socket")
+		}
 
 	}
 }
 
-func shouldCommandBeRun(command CommandWithArguments) bool {
-	if command.State == CommandRunning {
-		return false
-	}
-
-	// TODO: handle command failed state
-
-	// command was never run before
-	if command.LastRun.IsZero() {
-		return true
-	}
-
-	if time.Now().Sub(command.LastRun) > command.DurationBetweenRuns {
-		return true
+// the conditions of a command as they were evaluated by the check that let it start, for the history
+// of its runs, a requested run skips the conditions and the results of an earlier check would be wrong
+func conditionsEvaluatedSince(uuidOfCommand uuid.UUID, checkedAt time.Time) []ConditionResult {
+	evaluatedResults, found := lastConditionResultsOfCommand(uuidOfCommand)
+	if !found || evaluatedResults.EvaluatedAt.Before(checkedAt) {
+		return []ConditionResult{}
 	}
-	return false
+	return evaluatedResults.Results
 }
 
-func runRawCommandAndHandleErrors(commandToRun CommandWithArguments) error {
+// the state of the command has to be changed to running before, so it is not started twice
+func runRawCommandAndHandleErrors(commandToRun CommandWithArguments, conditionsAtStart []ConditionResult) error {
 
 	absolutePath := commandToRun.AbsolutePath
 	argumentList := commandToRun.CommandArguments
 	uuidOfCommand := commandToRun.UUID
 
-	changeStateToRunningError := changeStateOfCommand(uuidOfCommand, CommandRunning)
-	if changeStateToRunningError != nil {
-		fmt.Println("Error when changing state of command", commandToRun, "error: ", changeStateToRunningError)
+	run := RunRecord{
+		RunID:             uuid.New(),
+		CommandName:       commandToRun.Name,
+		CommandUUID:       uuidOfCommand,
+		StartTime:         time.Now(),
+		ConditionsAtStart: conditionsAtStart,
 	}
+	// every message about this run has these, so it can be followed in the log
+	runLogFields := commandLogFields(commandToRun, logFieldOf("run_id", run.RunID))
 
-	fmt.Println("Executing command `"+absolutePath+"` with arguments: ", argumentList, "and uuid:", uuidOfCommand)
+	logInfo("Executing command", append(runLogFields, logFieldOf("path", absolutePath), logFieldOf("arguments", strings.Join(argumentList, " ")))...)
+	recordStartInMetrics(commandToRun, run.StartTime)
 
 	// todo: this works without an absolute path at the moment but maybe we should change that
 	// to prevent some PATH injection attacks
 	command := exec.Command(absolutePath, argumentList...)
-	standardOutAndError, err := command.CombinedOutput()
-
-	var stateChangeError error = nil
-	if err != nil {
-		fmt.Println("Error executing command and/or reading standard out and standard error of it:", err)
-		stateChangeError = changeStateOfCommand(uuidOfCommand, CommandFailed)
+	// own process group, so the command and everything it starts can be paused and stopped together
+	startInNewProcessGroup(command)
+
+	output, outputError := openRunOutput(commandToRun, &run)
+	if outputError != nil {
+		logWarning("Could not create log files for the output of command, dropping its output", append(runLogFields, errorLogField(outputError))...)
+		run.OutputLocation = ""
+		run.ErrorOutputLocation = ""
 	} else {
-		fmt.Println("Successfully executed command `"+absolutePath+"` with arguments: ", argumentList, "and uuid:", uuidOfCommand)
-		stateChangeError = changeStateOfCommand(uuidOfCommand, CommandSuccessful)
+		command.Stdout = output.standardOut
+		command.Stderr = output.standardError
+		registerLiveOutput(commandToRun.Name, output.live)
 	}
 
-	if stateChangeError != nil {
-		fmt.Println("Error when changing state of command", commandToRun, "error: ", stateChangeError)
+	err := command.Start()
+	if err == nil {
+		registerRunningJob(uuidOfCommand, command.Process.Pid)
+		recordCurrentRunOfCommand(commandToRun, run, command.Process.Pid)
+		err = command.Wait()
 	}
+	job := unregisterRunningJob(uuidOfCommand)
 
-	// TODO log to system log or sth, just run as systemd unit
-	fmt.Println()
-	fmt.Println("======== Standard out and error of command", commandToRun, "========")
-	fmt.Print(string(standardOutAndError))
-	fmt.Println("======== End of standard out and error ========")
-	fmt.Println()
-
-	return err
-}
-
-func waitUntilPowerPluggedIn() {
-
-	// ignore error, just use the returned true as fallback, we will just check again later
-	// if it is running on battery
-	for runningOnBattery, _ := isDeviceRunningOnBatteryPower(); runningOnBattery; runningOnBattery, _ = isDeviceRunningOnBatteryPower() {
-		numberOfSecondsToWait := 10
-		fmt.Println("Running only on battery power, waiting for", numberOfSecondsToWait, "seconds")
-		sleepForSeconds(numberOfSecondsToWait)
+	if outputError == nil {
+		unregisterLiveOutput(commandToRun.Name)
+		if closeError := output.close(); closeError != nil {
+			logWarning("Error when writing output of command to its log files", append(runLogFields, errorLogField(closeError))...)
+		}
 	}
 
-	fmt.Println("External power is currently connected")
-
-}
-
-func isDeviceRunningOnBatteryPower() (bool, error) {
+	run.EndTime = time.Now()
+	run.Duration = run.EndTime.Sub(run.StartTime)
+	setExitStatusOfRun(&run, command.ProcessState)
 
-	// This often returns an error shortly after being plugged in, but is fine a few seconds later
-	// and returns the correct value then
-	batteries, err := battery.GetAll()
+	if job.stoppedWithState != "" {
+		run.Outcome = job.stoppedWithState
+		logWarning("Command was stopped because its conditions were not met anymore", append(runLogFields, logFieldOf("state", run.Outcome), errorLogField(err))...)
+	} else if err != nil {
+		run.Outcome = CommandFailed
+		logError("Error executing command and/or reading standard out and standard error of it", append(runLogFields, logFieldOf("state", run.Outcome), errorLogField(err))...)
+	} else {
+		run.Outcome = CommandSuccessful
+		logInfo("Successfully executed command", append(runLogFields, logFieldOf("state", run.Outcome), logFieldOf("duration", run.Duration))...)
+	}
 	if err != nil {
-		// handle error here, rest of program is happy with true as fallback for now and does
-		// not use the returned error currently
-		fmt.Println("Could not get battery info! Error:", err)
-		// return true as fallback to not start commands when potentially running on battery
-		return true, err
-	}
-
-	// check if there is a battery that is discharging to determine if running on battery or AC:
-	for _, currentBattery := range batteries {
-		if currentBattery.State == battery.Discharging {
-			// If at least one battery is discharing, the external power (if present)
-			// is not enough to charge the laptop as a whole and it is
-			// losing charge on at least one battery.
-			// This is a case where we consider it running on battery power.
-			return true, nil
-		}
+		run.Error = err.Error()
 	}
+	recordRunInMetrics(run)
 
-	// when device has a battery:
-	// no battery is discharging so every battery is either charging or full
-	// -> device not runing on battery
+	stateChangeError := changeStateOfCommand(uuidOfCommand, run.Outcome)
+	if stateChangeError != nil {
+		logError("Error when changing state of command", append(runLogFields, logFieldOf("state", run.Outcome), errorLogField(stateChangeError))...)
+	}
 
-	// when device has no battery:
-	// no battery that is discharging was found, because there are no batteries
-	// -> device not running on battery
-	return false, nil
+	historyError := addRunToRunHistory(run, commandToRun.HistoryRetention)
+	if historyError != nil {
+		logError("Error when adding run of command to the run history", append(runLogFields, errorLogField(historyError))...)
+	}
 
-}
\ No newline at end of file
+	if run.OutputLocation != "" {
+		logInfo("Output of command is in its log files", append(runLogFields, logFieldOf("output", run.OutputLocation), logFieldOf("error_output", run.ErrorOutputLocation))...)
+	}
+
+	return err
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"flag"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// the daemon logs a lot while its functions are tested, that is only shown with go test -v
+func TestMain(m *testing.M) {
+	flag.Parse()
+	if !testing.Verbose() {
+		daemonLogger.output = ioutil.Discard
+	}
+	os.Exit(m.Run())
+}
+
+func TestConditionsAtStartAreFromTheCheckThatStartedTheCommand(t *testing.T) {
+	results := []ConditionResult{{ConditionName: "ac_power", Met: true}}
+	testCases := []struct {
+		name               string
+		evaluatedBefore    bool
+		evaluatedAfter     bool
+		expectedConditions int
+	}{
+		{"evaluated by the check", false, true, 1},
+		// a requested run skips the conditions
+		{"only evaluated by an earlier check", true, false, 0},
+		{"never evaluated", false, false, 0},
+	}
+	for _, testCase := range testCases {
+		uuidOfCommand := uuid.New()
+		if testCase.evaluatedBefore {
+			rememberConditionResults(uuidOfCommand, results)
+		}
+		checkedAt := time.Now()
+		if testCase.evaluatedAfter {
+			rememberConditionResults(uuidOfCommand, results)
+		}
+
+		if conditionsAtStart := conditionsEvaluatedSince(uuidOfCommand, checkedAt); len(conditionsAtStart) != testCase.expectedConditions {
+			t.Errorf("%v: %v conditions at start, expected %v", testCase.name, len(conditionsAtStart), testCase.expectedConditions)
+		}
+	}
+}
@@ -0,0 +1,204 @@
+package main
+
+// Condition that the network connection is not metered, e.g. not tethered over a phone,
+// so commands transferring a lot of data don't use up a data plan
+
+import (
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const unmeteredNetworkConditionName = "unmetered_network"
+
+func init() {
+	registerCondition(unmeteredNetworkConditionName, newUnmeteredNetworkCondition)
+}
+
+// MeteredClassification tells whether the active network connection is metered
+type MeteredClassification string
+
+// possible classifications of the active network connection
+const (
+	ConnectionMetered        MeteredClassification = "metered"
+	ConnectionNotMetered     MeteredClassification = "not metered"
+	ConnectionMeteredUnknown MeteredClassification = "unknown"
+)
+
+// unmeteredNetworkCondition is met when the active connection is not classified as metered
+type unmeteredNetworkCondition struct {
+	// program and arguments run to classify the connection, exit code 0 means metered,
+	// 1 means not metered, anything else unknown
+	probeCommand []string
+	// connections over these interfaces are always metered
+	meteredInterfaceNames []string
+	// connections to these wifi networks are always metered
+	meteredSSIDs []string
+	// whether to ask NetworkManager if it knows the connection is metered
+	askNetworkManager     bool
+	treatUnknownAsMetered bool
+}
+
+func newUnmeteredNetworkCondition(parameters ConditionParameters) (Condition, error) {
+	if err := parameters.ensureOnlyKnownKeys("probe_command", "metered_interfaces", "metered_ssids", "ask_network_manager", "treat_unknown_as_metered"); err != nil {
+		return nil, err
+	}
+
+	condition := unmeteredNetworkCondition{}
+	var err error
+	if condition.probeCommand, err = parameters.stringListValue("probe_command"); err != nil {
+		return nil, err
+	}
+	if condition.meteredInterfaceNames, err = parameters.stringListValue("metered_interfaces"); err != nil {
+		return nil, err
+	}
+	if condition.meteredSSIDs, err = parameters.stringListValue("metered_ssids"); err != nil {
+		return nil, err
+	}
+	if condition.askNetworkManager, err = parameters.boolValue("ask_network_manager", true); err != nil {
+		return nil, err
+	}
+	if condition.treatUnknownAsMetered, err = parameters.boolValue("treat_unknown_as_metered", false); err != nil {
+		return nil, err
+	}
+	return condition, nil
+}
+
+func (condition unmeteredNetworkCondition) Name() string {
+	return unmeteredNetworkConditionName
+}
+
+func (condition unmeteredNetworkCondition) Evaluate() ConditionResult {
+	classification, reason := condition.classifyActiveConnection()
+
+	switch {
+	case classification == ConnectionMetered:
+		return ConditionResult{Met: false, ExplanationWhyUnmet: "connection is metered: " + reason}
+	case classification == ConnectionMeteredUnknown && condition.treatUnknownAsMetered:
+		return ConditionResult{Met: false, ExplanationWhyUnmet: "could not tell if connection is metered, treating it as metered: " + reason}
+	default:
+		return ConditionResult{Met: true}
+	}
+}
+
+// asks the sources from the most to the least explicitly configured one and returns the
+// first definite answer together with where it came from
+func (condition unmeteredNetworkCondition) classifyActiveConnection() (MeteredClassification, string) {
+	reasonsForUnknown := make([]string, 0)
+
+	if len(condition.probeCommand) > 0 {
+		classification, reason := classifyConnectionWithProbeCommand(condition.probeCommand)
+		if classification != ConnectionMeteredUnknown {
+			return classification, reason
+		}
+		reasonsForUnknown = append(reasonsForUnknown, reason)
+	}
+
+	if len(condition.meteredInterfaceNames) > 0 {
+		activeInterfaceNames, err := defaultRouteInterfaces()
+		if err != nil {
+			reasonsForUnknown = append(reasonsForUnknown, fmt.Sprint("could not determine active interfaces: ", err))
+		}
+		for _, currentInterfaceName := range activeInterfaceNames {
+			if isStringInSlice(currentInterfaceName, condition.meteredInterfaceNames) {
+				return ConnectionMetered, "interface " + currentInterfaceName + " is configured as metered"
+			}
+		}
+	}
+
+	if len(condition.meteredSSIDs) > 0 {
+		ssid, err := currentWifiSSID()
+		if err != nil {
+			reasonsForUnknown = append(reasonsForUnknown, fmt.Sprint("could not determine wifi network: ", err))
+		} else if isStringInSlice(ssid, condition.meteredSSIDs) {
+			return ConnectionMetered, "wifi network " + ssid + " is configured as metered"
+		}
+	}
+
+	if condition.askNetworkManager {
+		classification, reason := classifyConnectionWithNetworkManager()
+		if classification != ConnectionMeteredUnknown {
+			return classification, reason
+		}
+		reasonsForUnknown = append(reasonsForUnknown, reason)
+	}
+
+	// the configured lists only ever say a connection is metered, so not matching them
+	// is only a definite answer when nothing else was asked
+	if len(reasonsForUnknown) == 0 {
+		return ConnectionNotMetered, "connection matches no configured metered interface or wifi network"
+	}
+	return ConnectionMeteredUnknown, strings.Join(reasonsForUnknown, ", ")
+}
+
+func classifyConnectionWithProbeCommand(probeCommand []string) (MeteredClassification, string) {
+	program, cancel := conditionProgram(probeCommand[0], probeCommand[1:]...)
+	defer cancel()
+	startTime := time.Now()
+	err := program.Run()
+	if err == nil {
+		return ConnectionMetered, "probe command exited with 0"
+	}
+	if exitError, isExitError := err.(*exec.ExitError); isExitError && exitError.ExitCode() == 1 {
+		return ConnectionNotMetered, "probe command exited with 1"
+	}
+	// killed because it took too long
+	if time.Since(startTime) >= timeoutOfConditionPrograms {
+		return ConnectionMeteredUnknown, fmt.Sprintf("probe command did not finish within %v", timeoutOfConditionPrograms)
+	}
+	return ConnectionMeteredUnknown, fmt.Sprint("probe command failed: ", err)
+}
+
+// name of the wifi network the device is connected to
+func currentWifiSSID() (string, error) {
+	program, cancel := conditionProgram("iwgetid", "--raw")
+	defer cancel()
+	output, err := program.Output()
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(output)), nil
+}
+
+// values of the Metered property of NetworkManager, see NMMetered in its D-Bus API documentation
+const (
+	networkManagerMeteredUnknown  = 0
+	networkManagerMeteredYes      = 1
+	networkManagerMeteredNo       = 2
+	networkManagerMeteredGuessYes = 3
+	networkManagerMeteredGuessNo  = 4
+)
+
+// reads the Metered property of the primary connection over D-Bus with busctl, which
+// ships with systemd and saves us from speaking D-Bus ourselves
+func classifyConnectionWithNetworkManager() (MeteredClassification, string) {
+	program, cancel := conditionProgram("busctl", "--system", "get-property",
+		"org.freedesktop.NetworkManager", "/org/freedesktop/NetworkManager",
+		"org.freedesktop.NetworkManager", "Metered")
+	defer cancel()
+	output, err := program.Output()
+	if err != nil {
+		return ConnectionMeteredUnknown, fmt.Sprint("could not ask NetworkManager: ", err)
+	}
+
+	// output looks like "u 4" for the D-Bus type uint32 and its value
+	fields := strings.Fields(string(output))
+	if len(fields) != 2 || fields[0] != "u" {
+		return ConnectionMeteredUnknown, fmt.Sprintf("unexpected answer from NetworkManager: %q", output)
+	}
+	meteredValue, err := strconv.Atoi(fields[1])
+	if err != nil {
+		return ConnectionMeteredUnknown, fmt.Sprintf("unexpected answer from NetworkManager: %q", output)
+	}
+
+	switch meteredValue {
+	case networkManagerMeteredYes, networkManagerMeteredGuessYes:
+		return ConnectionMetered, "NetworkManager reports the connection as metered"
+	case networkManagerMeteredNo, networkManagerMeteredGuessNo:
+		return ConnectionNotMetered, "NetworkManager reports the connection as not metered"
+	default:
+		return ConnectionMeteredUnknown, "NetworkManager does not know if the connection is metered"
+	}
+}
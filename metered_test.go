@@ -0,0 +1,40 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestClassifyConnectionWithProbeCommand(t *testing.T) {
+	testCases := []struct {
+		probeCommand           []string
+		expectedClassification MeteredClassification
+	}{
+		{[]string{"sh", "-c", "exit 0"}, ConnectionMetered},
+		{[]string{"sh", "-c", "exit 1"}, ConnectionNotMetered},
+		{[]string{"sh", "-c", "exit 2"}, ConnectionMeteredUnknown},
+		{[]string{"/nonexistent/probe"}, ConnectionMeteredUnknown},
+	}
+	for _, testCase := range testCases {
+		classification, reason := classifyConnectionWithProbeCommand(testCase.probeCommand)
+		if classification != testCase.expectedClassification {
+			t.Errorf("%v: got %v (%v), expected %v", testCase.probeCommand, classification, reason, testCase.expectedClassification)
+		}
+	}
+}
+
+func TestHungProbeCommandIsKilled(t *testing.T) {
+	earlierTimeout := timeoutOfConditionPrograms
+	timeoutOfConditionPrograms = 100 * time.Millisecond
+	defer func() { timeoutOfConditionPrograms = earlierTimeout }()
+
+	start := time.Now()
+	classification, reason := classifyConnectionWithProbeCommand([]string{"sleep", "60"})
+	// killing and reaping it takes a moment, but nowhere near the 60 seconds it would sleep
+	if waited := time.Since(start); waited < timeoutOfConditionPrograms || waited > 10*timeoutOfConditionPrograms {
+		t.Fatalf("waited %v for a hung probe command with a timeout of %v", waited, timeoutOfConditionPrograms)
+	}
+	if classification != ConnectionMeteredUnknown {
+		t.Fatalf("hung probe command classified the connection as %v (%v)", classification, reason)
+	}
+}
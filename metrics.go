@@ -0,0 +1,288 @@
+package main
+
+// Prometheus metrics of the daemon, written in the text exposition format by hand,
+// because the few counters and histograms needed here don't justify the client library
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// metricVector is a metric with a value per combination of label values
+type metricVector struct {
+	mutex      sync.Mutex
+	name       string
+	help       string
+	labelNames []string
+	// only for histograms, upper bounds of the buckets in ascending order
+	bucketUpperBounds []float64
+	// by the label values joined with labelValuesSeparator
+	counters   map[string]float64
+	histograms map[string]*histogramValues
+}
+
+type histogramValues struct {
+	// observations per bucket, not cumulative
+	bucketCounts []uint64
+	sum          float64
+	count        uint64
+}
+
+// can't be part of a label value that is valid UTF-8 text, so joined label values are unambiguous
+const labelValuesSeparator = "\xff"
+
+func newCounterVector(name string, help string, labelNames ...string) *metricVector {
+	return &metricVector{name: name, help: help, labelNames: labelNames, counters: map[string]float64{}}
+}
+
+func newHistogramVector(name string, help string, bucketUpperBounds []float64, labelNames ...string) *metricVector {
+	return &metricVector{name: name, help: help, labelNames: labelNames, bucketUpperBounds: bucketUpperBounds,
+		histograms: map[string]*histogramValues{}}
+}
+
+func (vector *metricVector) increment(labelValues ...string) {
+	vector.mutex.Lock()
+	defer vector.mutex.Unlock()
+	vector.counters[strings.Join(labelValues, labelValuesSeparator)]++
+}
+
+func (vector *metricVector) observe(value float64, labelValues ...string) {
+	vector.mutex.Lock()
+	defer vector.mutex.Unlock()
+
+	key := strings.Join(labelValues, labelValuesSeparator)
+	values, found := vector.histograms[key]
+	if !found {
+		values = &histogramValues{bucketCounts: make([]uint64, len(vector.bucketUpperBounds))}
+		vector.histograms[key] = values
+	}
+	for index, upperBound := range vector.bucketUpperBounds {
+		if value <= upperBound {
+			values.bucketCounts[index]++
+			break
+		}
+	}
+	values.sum += value
+	values.count++
+}
+
+func (vector *metricVector) writeTo(writer io.Writer) {
+	vector.mutex.Lock()
+	defer vector.mutex.Unlock()
+
+	metricType := "counter"
+	if vector.histograms != nil {
+		metricType = "histogram"
+	}
+	fmt.Fprintf(writer, "# HELP %v %v\n# TYPE %v %v\n", vector.name, vector.help, vector.name, metricType)
+
+	for _, key := range sortedKeys(vector.counters, vector.histograms) {
+		labelValues := strings.Split(key, labelValuesSeparator)
+		if vector.histograms == nil {
+			fmt.Fprintf(writer, "%v%v %v\n", vector.name, formatLabels(vector.labelNames, labelValues), formatMetricValue(vector.counters[key]))
+			continue
+		}
+
+		values := vector.histograms[key]
+		cumulativeCount := uint64(0)
+		for index, upperBound := range vector.bucketUpperBounds {
+			cumulativeCount += values.bucketCounts[index]
+			fmt.Fprintf(writer, "%v_bucket%v %v\n", vector.name,
+				formatLabels(append(vector.labelNames, "le"), append(labelValues, formatMetricValue(upperBound))), cumulativeCount)
+		}
+		fmt.Fprintf(writer, "%v_bucket%v %v\n", vector.name, formatLabels(append(vector.labelNames, "le"), append(labelValues, "+Inf")), values.count)
+		fmt.Fprintf(writer, "%v_sum%v %v\n", vector.name, formatLabels(vector.labelNames, labelValues), formatMetricValue(values.sum))
+		fmt.Fprintf(writer, "%v_count%v %v\n", vector.name, formatLabels(vector.labelNames, labelValues), values.count)
+	}
+}
+
+func sortedKeys(counters map[string]float64, histograms map[string]*histogramValues) []string {
+	keys := make([]string, 0, len(counters)+len(histograms))
+	for key := range counters {
+		keys = append(keys, key)
+	}
+	for key := range histograms {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func formatLabels(labelNames []string, labelValues []string) string {
+	if len(labelNames) == 0 {
+		return ""
+	}
+	labels := make([]string, 0, len(labelNames))
+	for index, labelName := range labelNames {
+		labelValue := ""
+		if index < len(labelValues) {
+			labelValue = labelValues[index]
+		}
+		labels = append(labels, labelName+`="`+escapeLabelValue(labelValue)+`"`)
+	}
+	return "{" + strings.Join(labels, ",") + "}"
+}
+
+func escapeLabelValue(labelValue string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(labelValue)
+}
+
+func formatMetricValue(value float64) string {
+	if math.IsInf(value, 1) {
+		return "+Inf"
+	}
+	return fmt.Sprint(value)
+}
+
+// writes a metric without labels or with fixed labels that is computed when it is scraped
+func writeGauge(writer io.Writer, name string, help string, labelNames []string, valuesByLabelValues map[string]float64) {
+	fmt.Fprintf(writer, "# HELP %v %v\n# TYPE %v gauge\n", name, help, name)
+	for _, key := range sortedKeys(valuesByLabelValues, nil) {
+		fmt.Fprintf(writer, "%v%v %v\n", name, formatLabels(labelNames, strings.Split(key, labelValuesSeparator)), formatMetricValue(valuesByLabelValues[key]))
+	}
+}
+
+// seconds, from a few seconds up to half a day
+var durationBucketUpperBounds = []float64{1, 5, 15, 60, 300, 900, 1800, 3600, 7200, 14400, 43200}
+
+var (
+	runsMetric = newCounterVector("workscheduler_runs_total",
+		"Finished runs by command and the state they ended in.", "command", "outcome")
+	runDurationMetric = newHistogramVector("workscheduler_run_duration_seconds",
+		"How long runs took by command.", durationBucketUpperBounds, "command")
+	startDelayMetric = newHistogramVector("workscheduler_start_delay_seconds",
+		"Time between a command becoming eligible by its interval, schedule and retries and it being started, mostly waiting for conditions and time windows.",
+		durationBucketUpperBounds, "command")
+	storeErrorsMetric = newCounterVector("workscheduler_store_errors_total",
+		"Errors when reading or writing the command store or the run history.", "store", "operation")
+	powerTransitionsMetric = newCounterVector("workscheduler_power_state_transitions_total",
+		"Changes of the debounced power state, a fast growing count means a flapping charger.", "from", "to")
+)
+
+// called by the storage functions, e.g. countStoreError("command_store", "write")
+func countStoreError(store string, operation string) {
+	storeErrorsMetric.increment(store, operation)
+}
+
+// called by the power monitor whenever it takes over a new power state
+func countPowerStateTransition(transition PowerStateTransition) {
+	powerTransitionsMetric.increment(string(transition.From), string(transition.To))
+}
+
+func recordStartInMetrics(command CommandWithArguments, startTime time.Time) {
+	// a requested run did not wait for anything
+	if command.RunRequested {
+		startDelayMetric.observe(0, command.Name)
+		return
+	}
+	eligibleSince, err := eligibleSinceOfCommand(command)
+	if err != nil {
+		return
+	}
+	startDelay := startTime.Sub(eligibleSince)
+	if startDelay < 0 {
+		startDelay = 0
+	}
+	startDelayMetric.observe(startDelay.Seconds(), command.Name)
+}
+
+func recordRunInMetrics(run RunRecord) {
+	runsMetric.increment(run.CommandName, string(run.Outcome))
+	runDurationMetric.observe(run.Duration.Seconds(), run.CommandName)
+}
+
+// since when the interval, schedule and retries of a command let it start, the time windows and
+// conditions are what it waited for after that
+func eligibleSinceOfCommand(command CommandWithArguments) (time.Time, error) {
+	eligibleSince := command.AddedAt
+	laterOf := func(otherTime time.Time) {
+		if otherTime.After(eligibleSince) {
+			eligibleSince = otherTime
+		}
+	}
+
+	if isWaitingForRetry(command.State) {
+		laterOf(command.NextAttemptAt)
+	}
+	if !command.LastRun.IsZero() {
+		laterOf(command.LastRun.Add(command.DurationBetweenRuns))
+	}
+	earliestScheduledTime, err := earliestScheduledTimeOfCommand(command)
+	if err != nil {
+		return time.Time{}, err
+	}
+	laterOf(earliestScheduledTime)
+	return eligibleSince, nil
+}
+
+// writes all metrics, the gauges are computed right now from what the daemon knows
+func serveMetrics(writer http.ResponseWriter, request *http.Request) {
+	writer.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+
+	runsMetric.writeTo(writer)
+	runDurationMetric.writeTo(writer)
+	startDelayMetric.writeTo(writer)
+	storeErrorsMetric.writeTo(writer)
+	powerTransitionsMetric.writeTo(writer)
+
+	powerState, _, _ := daemonPowerMonitor.currentPowerState()
+	powerStates := map[string]float64{}
+	for _, currentState := range []PowerState{PowerStateUnknown, PowerStateOnACPower, PowerStateOnBattery} {
+		powerStates[string(currentState)] = 0
+	}
+	powerStates[string(powerState)] = 1
+	writeGauge(writer, "workscheduler_power_state", "Debounced power state, 1 for the current one.", []string{"state"}, powerStates)
+
+	commandStore, err := readAndParseCommandStore()
+	if err != nil {
+		// the error counter already shows it
+		return
+	}
+
+	commandsByState := map[string]float64{}
+	conditionsMet := map[string]float64{}
+	for _, currentCommand := range commandStore.Commands {
+		commandsByState[string(currentCommand.State)]++
+		// probing the network on every scrape would make the metrics slow and the probes depend on the scrape interval,
+		// conditions the daemon did not evaluate yet are left out
+		evaluatedResults, found := lastConditionResultsOfCommand(currentCommand.UUID)
+		if !found {
+			continue
+		}
+		for _, currentResult := range evaluatedResults.Results {
+			met := 0.0
+			if currentResult.Met {
+				met = 1
+			}
+			conditionsMet[currentCommand.Name+labelValuesSeparator+currentResult.ConditionName] = met
+		}
+	}
+	writeGauge(writer, "workscheduler_commands", "Commands in the command store by state.", []string{"state"}, commandsByState)
+	writeGauge(writer, "workscheduler_condition_met", "Whether a condition of a command, e.g. power, network or idle, was met when the daemon evaluated it last.",
+		[]string{"command", "condition"}, conditionsMet)
+}
+
+// serves only the metrics without authentication, they don't allow changing anything
+func startMetricsServer(address string) error {
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return err
+	}
+	serveMux := http.NewServeMux()
+	serveMux.HandleFunc("/metrics", serveMetrics)
+	server := &http.Server{Handler: serveMux, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		logInfo("Serving metrics", logFieldOf("address", address))
+		if err := server.Serve(listener); err != nil {
+			logError("Metrics server stopped", errorLogField(err))
+		}
+	}()
+	return nil
+}
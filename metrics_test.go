@@ -0,0 +1,148 @@
+package main
+
+import (
+	"bytes"
+	"math"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestEscapeLabelValue(t *testing.T) {
+	testCases := []struct {
+		labelValue      string
+		expectedEscaped string
+	}{
+		{"backup", "backup"},
+		{`C:\backup`, `C:\\backup`},
+		{`say "hi"`, `say \"hi\"`},
+		{"two\nlines", `two\nlines`},
+		{"\\\"\n", `\\\"\n`},
+	}
+	for _, testCase := range testCases {
+		if escaped := escapeLabelValue(testCase.labelValue); escaped != testCase.expectedEscaped {
+			t.Errorf("%q: escaped to %q, expected %q", testCase.labelValue, escaped, testCase.expectedEscaped)
+		}
+	}
+}
+
+func TestFormatLabelsAndValues(t *testing.T) {
+	testCases := []struct {
+		labelNames     []string
+		labelValues    []string
+		expectedLabels string
+	}{
+		{nil, nil, ""},
+		{[]string{"command"}, []string{"backup"}, `{command="backup"}`},
+		{[]string{"command", "outcome"}, []string{"backup", "Failed"}, `{command="backup",outcome="Failed"}`},
+		{[]string{"command", "outcome"}, []string{"backup"}, `{command="backup",outcome=""}`},
+	}
+	for _, testCase := range testCases {
+		if labels := formatLabels(testCase.labelNames, testCase.labelValues); labels != testCase.expectedLabels {
+			t.Errorf("%v=%v: formatted as %v, expected %v", testCase.labelNames, testCase.labelValues, labels, testCase.expectedLabels)
+		}
+	}
+
+	for value, expectedFormat := range map[float64]string{1: "1", 0.5: "0.5", 43200: "43200", math.Inf(1): "+Inf"} {
+		if formatted := formatMetricValue(value); formatted != expectedFormat {
+			t.Errorf("%v: formatted as %v, expected %v", value, formatted, expectedFormat)
+		}
+	}
+}
+
+func TestCounterExposition(t *testing.T) {
+	counter := newCounterVector("test_runs_total", "Runs for the test.", "command", "outcome")
+	counter.increment("b", "Succeeded")
+	counter.increment("a", "Failed")
+	counter.increment("a", "Failed")
+
+	var output bytes.Buffer
+	counter.writeTo(&output)
+	expectedOutput := `# HELP test_runs_total Runs for the test.
+# TYPE test_runs_total counter
+test_runs_total{command="a",outcome="Failed"} 2
+test_runs_total{command="b",outcome="Succeeded"} 1
+`
+	if output.String() != expectedOutput {
+		t.Errorf("exposition is\n%v\nexpected\n%v", output.String(), expectedOutput)
+	}
+}
+
+func TestHistogramBucketsAreCumulative(t *testing.T) {
+	testCases := []struct {
+		name           string
+		observations   []float64
+		expectedOutput string
+	}{
+		{"on the bounds", []float64{1, 5}, `test_seconds_bucket{command="c",le="1"} 1
+test_seconds_bucket{command="c",le="5"} 2
+test_seconds_bucket{command="c",le="+Inf"} 2
+test_seconds_sum{command="c"} 6
+test_seconds_count{command="c"} 2
+`},
+		{"above all bounds", []float64{0.5, 100}, `test_seconds_bucket{command="c",le="1"} 1
+test_seconds_bucket{command="c",le="5"} 1
+test_seconds_bucket{command="c",le="+Inf"} 2
+test_seconds_sum{command="c"} 100.5
+test_seconds_count{command="c"} 2
+`},
+		{"between bounds", []float64{2, 3, 4}, `test_seconds_bucket{command="c",le="1"} 0
+test_seconds_bucket{command="c",le="5"} 3
+test_seconds_bucket{command="c",le="+Inf"} 3
+test_seconds_sum{command="c"} 9
+test_seconds_count{command="c"} 3
+`},
+	}
+	for _, testCase := range testCases {
+		histogram := newHistogramVector("test_seconds", "Durations for the test.", []float64{1, 5}, "command")
+		for _, observation := range testCase.observations {
+			histogram.observe(observation, "c")
+		}
+
+		var output bytes.Buffer
+		histogram.writeTo(&output)
+		expectedOutput := "# HELP test_seconds Durations for the test.\n# TYPE test_seconds histogram\n" + testCase.expectedOutput
+		if output.String() != expectedOutput {
+			t.Errorf("%v: exposition is\n%v\nexpected\n%v", testCase.name, output.String(), expectedOutput)
+		}
+	}
+}
+
+func TestMetricsShowLastConditionResults(t *testing.T) {
+	useTemporaryStore(t)
+	registeredConditions["must_not_be_evaluated"] = func(parameters ConditionParameters) (Condition, error) {
+		return conditionThatMustNotBeEvaluated{t: t}, nil
+	}
+	defer delete(registeredConditions, "must_not_be_evaluated")
+
+	evaluated := CommandWithArguments{Name: "evaluated", UUID: uuid.New(), RequiredConditions: []string{"must_not_be_evaluated"}}
+	notEvaluated := CommandWithArguments{Name: "not-evaluated", UUID: uuid.New(), RequiredConditions: []string{"must_not_be_evaluated"}}
+	err := activeStore.Update(func(commandStore *CommandStore) error {
+		commandStore.Commands = []CommandWithArguments{evaluated, notEvaluated}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rememberConditionResults(evaluated.UUID, []ConditionResult{{ConditionName: "must_not_be_evaluated", Met: false}})
+
+	recorder := httptest.NewRecorder()
+	serveMetrics(recorder, httptest.NewRequest("GET", "/metrics", nil))
+	output := recorder.Body.String()
+
+	testCases := []struct {
+		line          string
+		expectedFound bool
+	}{
+		{`workscheduler_condition_met{command="evaluated",condition="must_not_be_evaluated"} 0`, true},
+		{`workscheduler_condition_met{command="not-evaluated"`, false},
+		{`workscheduler_commands{state=""} 2`, true},
+	}
+	for _, testCase := range testCases {
+		if found := strings.Contains(output, testCase.line); found != testCase.expectedFound {
+			t.Errorf("%v: found %v, expected %v in\n%v", testCase.line, found, testCase.expectedFound, output)
+		}
+	}
+}
@@ -0,0 +1,243 @@
+package main
+
+// Condition that the device is connected to a network, checked with a default route,
+// DNS resolution and optionally connecting to a specific host
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const networkConditionName = "network"
+
+func init() {
+	registerCondition(networkConditionName, newNetworkCondition)
+}
+
+const (
+	pathToIPv4RouteTable = "/proc/net/route"
+	pathToIPv6RouteTable = "/proc/net/ipv6_route"
+	// flag of a route in the kernel route tables that is usable
+	routeFlagUp = 0x1
+)
+
+// networkCondition is met when every enabled probe succeeds
+type networkCondition struct {
+	// only routes over this interface count, empty means any interface except loopback
+	interfaceName     string
+	checkDefaultRoute bool
+	// empty disables the DNS check
+	dnsLookupHost string
+	// host:port that has to accept a TCP connection, empty disables the TCP check
+	tcpProbeAddress string
+	// how long a single DNS lookup or TCP connection attempt may take
+	probeTimeout time.Duration
+	// how long the result of the probes is reused before probing again
+	cacheDuration time.Duration
+}
+
+// probing the network costs time and traffic, so results are shared between
+// all commands with the same parameters for a short time
+// the lock only guards the map, probes run without it, so a slow probe only holds up
+// the commands with the same parameters, which wait for its result
+var networkProbeCache = struct {
+	sync.Mutex
+	results map[networkCondition]*cachedConditionResult
+}{results: make(map[networkCondition]*cachedConditionResult)}
+
+type cachedConditionResult struct {
+	result      ConditionResult
+	evaluatedAt time.Time
+	// closed once result and evaluatedAt are set by the probe
+	done chan struct{}
+}
+
+func newNetworkCondition(parameters ConditionParameters) (Condition, error) {
+	if err := parameters.ensureOnlyKnownKeys("interface", "check_default_route", "dns_lookup_host", "tcp_probe_address", "timeout", "cache_duration"); err != nil {
+		return nil, err
+	}
+
+	condition := networkCondition{}
+	var err error
+	if condition.interfaceName, err = parameters.stringValue("interface", ""); err != nil {
+		return nil, err
+	}
+	if condition.checkDefaultRoute, err = parameters.boolValue("check_default_route", true); err != nil {
+		return nil, err
+	}
+	// resolving a name tells whether the configured DNS servers are reachable, there is no default,
+	// so the condition never depends on outside DNS unless a config asks for it
+	if condition.dnsLookupHost, err = parameters.stringValue("dns_lookup_host", ""); err != nil {
+		return nil, err
+	}
+	if condition.tcpProbeAddress, err = parameters.stringValue("tcp_probe_address", ""); err != nil {
+		return nil, err
+	}
+	if condition.tcpProbeAddress != "" {
+		if _, _, err := net.SplitHostPort(condition.tcpProbeAddress); err != nil {
+			return nil, fmt.Errorf("parameter \"tcp_probe_address\" must look like host:port: %v", err)
+		}
+	}
+	if condition.probeTimeout, err = parameters.durationValue("timeout", 5*time.Second); err != nil {
+		return nil, err
+	}
+	if condition.cacheDuration, err = parameters.durationValue("cache_duration", 30*time.Second); err != nil {
+		return nil, err
+	}
+	return condition, nil
+}
+
+func (condition networkCondition) Name() string {
+	return networkConditionName
+}
+
+func (condition networkCondition) Evaluate() ConditionResult {
+	networkProbeCache.Lock()
+	cached, found := networkProbeCache.results[condition]
+	if found {
+		select {
+		case <-cached.done:
+			if time.Since(cached.evaluatedAt) < condition.cacheDuration {
+				networkProbeCache.Unlock()
+				return cached.result
+			}
+		default:
+			// another command with the same parameters is probing right now, its result is fresh enough
+			networkProbeCache.Unlock()
+			<-cached.done
+			return cached.result
+		}
+	}
+	probing := &cachedConditionResult{done: make(chan struct{})}
+	networkProbeCache.results[condition] = probing
+	networkProbeCache.Unlock()
+
+	probing.result = condition.probe()
+	probing.evaluatedAt = time.Now()
+	close(probing.done)
+	return probing.result
+}
+
+// runs the enabled probes from the cheapest to the most expensive one and stops at the first failure
+func (condition networkCondition) probe() ConditionResult {
+	if condition.checkDefaultRoute {
+		hasDefaultRoute, err := hasDefaultRoute(condition.interfaceName)
+		if err != nil {
+			return ConditionResult{Met: false, ExplanationWhyUnmet: fmt.Sprint("could not read route tables: ", err)}
+		}
+		if !hasDefaultRoute {
+			if condition.interfaceName != "" {
+				return ConditionResult{Met: false, ExplanationWhyUnmet: "no default route over interface " + condition.interfaceName}
+			}
+			return ConditionResult{Met: false, ExplanationWhyUnmet: "no default route over any network interface"}
+		}
+	}
+
+	if condition.dnsLookupHost != "" {
+		lookupContext, cancel := context.WithTimeout(context.Background(), condition.probeTimeout)
+		defer cancel()
+		if _, err := net.DefaultResolver.LookupHost(lookupContext, condition.dnsLookupHost); err != nil {
+			return ConditionResult{Met: false, ExplanationWhyUnmet: fmt.Sprintf("could not resolve %q: %v", condition.dnsLookupHost, err)}
+		}
+	}
+
+	if condition.tcpProbeAddress != "" {
+		connection, err := net.DialTimeout("tcp", condition.tcpProbeAddress, condition.probeTimeout)
+		if err != nil {
+			return ConditionResult{Met: false, ExplanationWhyUnmet: fmt.Sprintf("could not connect to %v: %v", condition.tcpProbeAddress, err)}
+		}
+		connection.Close()
+	}
+
+	return ConditionResult{Met: true}
+}
+
+// checks the IPv4 and IPv6 route tables of the kernel for a usable default route,
+// only over the given interface if it is not empty
+func hasDefaultRoute(interfaceName string) (bool, error) {
+	interfaceNames, err := defaultRouteInterfaces()
+	if len(interfaceNames) == 0 {
+		return false, err
+	}
+	return interfaceName == "" || isStringInSlice(interfaceName, interfaceNames), nil
+}
+
+// names of all interfaces except loopback that have a usable IPv4 or IPv6 default route
+func defaultRouteInterfaces() ([]string, error) {
+	ipv4InterfaceNames, ipv4Error := defaultRouteInterfacesFromRouteTable(pathToIPv4RouteTable, isIPv4DefaultRoute)
+	ipv6InterfaceNames, ipv6Error := defaultRouteInterfacesFromRouteTable(pathToIPv6RouteTable, isIPv6DefaultRoute)
+
+	// IPv6 might be disabled, so only fail when neither table could be read
+	if ipv4Error != nil && ipv6Error != nil {
+		return []string{}, ipv4Error
+	}
+
+	interfaceNames := ipv4InterfaceNames
+	for _, currentInterfaceName := range ipv6InterfaceNames {
+		if !isStringInSlice(currentInterfaceName, interfaceNames) {
+			interfaceNames = append(interfaceNames, currentInterfaceName)
+		}
+	}
+	return interfaceNames, nil
+}
+
+// returns the interface of the route if the line describes a usable default route
+type defaultRouteParser func(routeTableLine string) (string, bool)
+
+func defaultRouteInterfacesFromRouteTable(pathToRouteTable string, parseLine defaultRouteParser) ([]string, error) {
+	interfaceNames := make([]string, 0)
+
+	routeTableFile, err := os.Open(pathToRouteTable)
+	if err != nil {
+		return interfaceNames, err
+	}
+	defer routeTableFile.Close()
+
+	scanner := bufio.NewScanner(routeTableFile)
+	for scanner.Scan() {
+		routeInterfaceName, isDefaultRoute := parseLine(scanner.Text())
+		if !isDefaultRoute || routeInterfaceName == "lo" || isStringInSlice(routeInterfaceName, interfaceNames) {
+			continue
+		}
+		interfaceNames = append(interfaceNames, routeInterfaceName)
+	}
+	return interfaceNames, scanner.Err()
+}
+
+// lines of /proc/net/route look like:
+// Iface Destination Gateway Flags RefCnt Use Metric Mask MTU Window IRTT
+// wlan0 00000000 0102A8C0 0003 0 0 600 00000000 0 0 0
+func isIPv4DefaultRoute(routeTableLine string) (string, bool) {
+	fields := strings.Fields(routeTableLine)
+	if len(fields) < 8 || fields[0] == "Iface" {
+		return "", false
+	}
+	flags, err := strconv.ParseUint(fields[3], 16, 32)
+	if err != nil {
+		return "", false
+	}
+	isDefaultRoute := fields[1] == "00000000" && fields[7] == "00000000" && flags&routeFlagUp != 0
+	return fields[0], isDefaultRoute
+}
+
+// lines of /proc/net/ipv6_route have no header and look like:
+// destination prefixlength source prefixlength nexthop metric refcount use flags interface
+func isIPv6DefaultRoute(routeTableLine string) (string, bool) {
+	fields := strings.Fields(routeTableLine)
+	if len(fields) < 10 {
+		return "", false
+	}
+	flags, err := strconv.ParseUint(fields[8], 16, 32)
+	if err != nil {
+		return "", false
+	}
+	isDefaultRoute := fields[0] == strings.Repeat("0", 32) && fields[1] == "00" && flags&routeFlagUp != 0
+	return fields[9], isDefaultRoute
+}
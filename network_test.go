@@ -0,0 +1,158 @@
+package main
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+// a network condition that only probes the given address, so no outside network is needed
+func localNetworkCondition(t *testing.T, tcpProbeAddress string) Condition {
+	t.Helper()
+	condition, err := newNetworkCondition(ConditionParameters{
+		"check_default_route": false,
+		"tcp_probe_address":   tcpProbeAddress,
+		"timeout":             "1s",
+		"cache_duration":      "0s",
+	})
+	if err != nil {
+		t.Fatalf("could not set up network condition: %v", err)
+	}
+	return condition
+}
+
+func TestNetworkConditionAgainstLocalListener(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("could not listen: %v", err)
+	}
+	address := listener.Addr().String()
+	go func() {
+		for {
+			connection, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			connection.Close()
+		}
+	}()
+
+	if result := localNetworkCondition(t, address).Evaluate(); !result.Met {
+		t.Fatalf("condition not met while %v listens: %v", address, result.ExplanationWhyUnmet)
+	}
+
+	listener.Close()
+	if result := localNetworkCondition(t, address).Evaluate(); result.Met {
+		t.Fatalf("condition met after %v stopped listening", address)
+	}
+}
+
+func TestNetworkConditionReusesCachedResult(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("could not listen: %v", err)
+	}
+	address := listener.Addr().String()
+	go func() {
+		for {
+			connection, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			connection.Close()
+		}
+	}()
+
+	condition, err := newNetworkCondition(ConditionParameters{
+		"check_default_route": false,
+		"tcp_probe_address":   address,
+		"cache_duration":      "1h",
+	})
+	if err != nil {
+		t.Fatalf("could not set up network condition: %v", err)
+	}
+	if result := condition.Evaluate(); !result.Met {
+		t.Fatalf("condition not met while %v listens: %v", address, result.ExplanationWhyUnmet)
+	}
+	listener.Close()
+	if result := condition.Evaluate(); !result.Met {
+		t.Fatalf("cached result was not reused after %v stopped listening", address)
+	}
+}
+
+func TestNetworkConditionHasNoDNSLookupByDefault(t *testing.T) {
+	condition, err := newNetworkCondition(ConditionParameters{})
+	if err != nil {
+		t.Fatalf("could not set up network condition: %v", err)
+	}
+	if host := condition.(networkCondition).dnsLookupHost; host != "" {
+		t.Fatalf("DNS lookup of %q without it being configured", host)
+	}
+}
+
+func TestNetworkConditionRejectsInvalidParameters(t *testing.T) {
+	testCases := map[string]ConditionParameters{
+		"unknown parameter":     {"dns_host": "example.com"},
+		"address without port":  {"tcp_probe_address": "localhost"},
+		"timeout no duration":   {"timeout": "soon"},
+		"route check no bool":   {"check_default_route": "yes"},
+		"cache duration number": {"cache_duration": 30.0},
+	}
+	for name, parameters := range testCases {
+		if _, err := newNetworkCondition(parameters); err == nil {
+			t.Errorf("%v: no error for %v", name, parameters)
+		}
+	}
+}
+
+func TestDefaultRouteParsers(t *testing.T) {
+	testCases := []struct {
+		name                  string
+		parseLine             defaultRouteParser
+		line                  string
+		expectedInterfaceName string
+		expectedDefaultRoute  bool
+	}{
+		{"IPv4 default route", isIPv4DefaultRoute, "wlan0\t00000000\t0102A8C0\t0003\t0\t0\t600\t00000000\t0\t0\t0", "wlan0", true},
+		{"IPv4 header", isIPv4DefaultRoute, "Iface\tDestination\tGateway\tFlags\tRefCnt\tUse\tMetric\tMask\tMTU\tWindow\tIRTT", "", false},
+		{"IPv4 subnet route", isIPv4DefaultRoute, "wlan0\t0002A8C0\t00000000\t0001\t0\t0\t600\t00FFFFFF\t0\t0\t0", "wlan0", false},
+		{"IPv4 default route down", isIPv4DefaultRoute, "eth0\t00000000\t0102A8C0\t0002\t0\t0\t100\t00000000\t0\t0\t0", "eth0", false},
+		{"IPv6 default route", isIPv6DefaultRoute, "00000000000000000000000000000000 00 00000000000000000000000000000000 00 fe800000000000000000000000000001 00000400 00000001 00000000 00000003 wlan0", "wlan0", true},
+		{"IPv6 prefix route", isIPv6DefaultRoute, "20010db8000000000000000000000000 40 00000000000000000000000000000000 00 00000000000000000000000000000000 00000100 00000001 00000000 00000001 wlan0", "wlan0", false},
+		{"IPv6 short line", isIPv6DefaultRoute, "00000000000000000000000000000000 00", "", false},
+	}
+	for _, testCase := range testCases {
+		interfaceName, isDefaultRoute := testCase.parseLine(testCase.line)
+		if interfaceName != testCase.expectedInterfaceName || isDefaultRoute != testCase.expectedDefaultRoute {
+			t.Errorf("%v: got %q, %v, expected %q, %v", testCase.name, interfaceName, isDefaultRoute,
+				testCase.expectedInterfaceName, testCase.expectedDefaultRoute)
+		}
+	}
+}
+
+// a probe that takes long must not hold up commands with other parameters
+func TestSlowNetworkProbeDoesNotBlockOthers(t *testing.T) {
+	// nothing answers on this address in the documentation range, so connecting runs into the timeout
+	slowCondition, err := newNetworkCondition(ConditionParameters{
+		"check_default_route": false,
+		"tcp_probe_address":   "192.0.2.1:9",
+		"timeout":             "2s",
+		"cache_duration":      "0s",
+	})
+	if err != nil {
+		t.Fatalf("could not set up network condition: %v", err)
+	}
+	go slowCondition.Evaluate()
+	// let the slow probe start first
+	time.Sleep(100 * time.Millisecond)
+
+	fastCondition, err := newNetworkCondition(ConditionParameters{"check_default_route": false, "cache_duration": "0s"})
+	if err != nil {
+		t.Fatalf("could not set up network condition: %v", err)
+	}
+	start := time.Now()
+	fastCondition.Evaluate()
+	if waited := time.Since(start); waited > time.Second {
+		t.Fatalf("probe without checks waited %v for a slow probe of other parameters", waited)
+	}
+}
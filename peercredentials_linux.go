@@ -0,0 +1,40 @@
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"syscall"
+)
+
+// only the user running the daemon and root may control it, the kernel tells who is on the other side of the socket
+func checkPeerCredentials(connection net.Conn) error {
+	unixConnection, isUnixConnection := connection.(*net.UnixConn)
+	if !isUnixConnection {
+		return fmt.Errorf("not a Unix socket connection")
+	}
+	rawConnection, err := unixConnection.SyscallConn()
+	if err != nil {
+		return err
+	}
+
+	var credentials *syscall.Ucred
+	var credentialsError error
+	controlError := rawConnection.Control(func(fileDescriptor uintptr) {
+		credentials, credentialsError = syscall.GetsockoptUcred(int(fileDescriptor), syscall.SOL_SOCKET, syscall.SO_PEERCRED)
+	})
+	if controlError != nil {
+		return controlError
+	}
+	if credentialsError != nil {
+		return fmt.Errorf("could not get credentials of peer: %v", credentialsError)
+	}
+
+	if int(credentials.Uid) != os.Getuid() && credentials.Uid != 0 {
+		return fmt.Errorf("user %v of process %v may not control the daemon of user %v", credentials.Uid, credentials.Pid, os.Getuid())
+	}
+	return nil
+}
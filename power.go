@@ -0,0 +1,117 @@
+package main
+
+// Condition that the device is not running on battery power
+
+import (
+	"fmt"
+	"time"
+)
+
+const acPowerConditionName = "ac_power"
+
+func init() {
+	registerCondition(acPowerConditionName, newACPowerCondition)
+}
+
+// acPowerCondition is met when no battery of the device is discharging and the batteries
+// are charged enough, or when running on battery is allowed because they are charged enough for that
+type acPowerCondition struct {
+	// combined charge of all batteries that is required even when external power is connected,
+	// so a heavy command does not start on an almost empty device that was just plugged in
+	minimumChargePercent float64
+	// running on battery is fine above this combined charge, 0 means never
+	allowOnBatteryAbovePercent float64
+}
+
+func newACPowerCondition(parameters ConditionParameters) (Condition, error) {
+	if err := parameters.ensureOnlyKnownKeys("minimum_charge_percent", "allow_on_battery_above_percent"); err != nil {
+		return nil, err
+	}
+
+	condition := acPowerCondition{}
+	var err error
+	if condition.minimumChargePercent, err = parameters.numberValue("minimum_charge_percent", 0); err != nil {
+		return nil, err
+	}
+	if condition.allowOnBatteryAbovePercent, err = parameters.numberValue("allow_on_battery_above_percent", 0); err != nil {
+		return nil, err
+	}
+	for _, percent := range []float64{condition.minimumChargePercent, condition.allowOnBatteryAbovePercent} {
+		if percent < 0 || percent > 100 {
+			return nil, fmt.Errorf("percentages must be between 0 and 100, but one is %v", percent)
+		}
+	}
+	return condition, nil
+}
+
+func (condition acPowerCondition) Name() string {
+	return acPowerConditionName
+}
+
+// more changes of the power state than this in the last hour are mentioned when the condition is not met
+const numberOfPowerTransitionsConsideredFlapping = 4
+
+func (condition acPowerCondition) Evaluate() ConditionResult {
+	result := condition.evaluateWithoutHistory()
+
+	if !result.Met {
+		numberOfTransitions := daemonPowerMonitor.numberOfTransitionsSince(time.Now().Add(-time.Hour))
+		if numberOfTransitions > numberOfPowerTransitionsConsideredFlapping {
+			result.ExplanationWhyUnmet += fmt.Sprint(" (power state changed ", numberOfTransitions, " times in the last hour, the charger might be loose)")
+		}
+	}
+	return result
+}
+
+func (condition acPowerCondition) evaluateWithoutHistory() ConditionResult {
+	powerState, powerStatus, err := daemonPowerMonitor.currentPowerState()
+	if powerState == PowerStateUnknown {
+		explanation := "power state is unknown"
+		if err != nil {
+			explanation += fmt.Sprint(", last reading failed: ", err)
+		}
+		return ConditionResult{Met: false, ExplanationWhyUnmet: explanation}
+	}
+
+	// devices without battery can only run on external power and have no charge to check
+	if !powerStatus.HasBattery {
+		return ConditionResult{Met: true}
+	}
+
+	// a battery that does not report its capacity is no reason to believe the device runs on external power
+	if !powerStatus.ChargeKnown {
+		switch {
+		case powerState == PowerStateOnBattery:
+			return ConditionResult{Met: false, ExplanationWhyUnmet: "running only on battery power, whose charge is unknown"}
+		case condition.minimumChargePercent > 0:
+			return ConditionResult{
+				Met:                 false,
+				ExplanationWhyUnmet: fmt.Sprintf("charge of the batteries is unknown, but at least %.0f%% are required", condition.minimumChargePercent),
+			}
+		default:
+			return ConditionResult{Met: true}
+		}
+	}
+
+	if powerStatus.ChargePercent < condition.minimumChargePercent {
+		return ConditionResult{
+			Met: false,
+			ExplanationWhyUnmet: fmt.Sprintf("batteries are only charged to %.0f%%, but at least %.0f%% are required",
+				powerStatus.ChargePercent, condition.minimumChargePercent),
+		}
+	}
+
+	if powerState == PowerStateOnBattery {
+		if condition.allowOnBatteryAbovePercent == 0 {
+			return ConditionResult{Met: false, ExplanationWhyUnmet: "running only on battery power"}
+		}
+		if powerStatus.ChargePercent <= condition.allowOnBatteryAbovePercent {
+			return ConditionResult{
+				Met: false,
+				ExplanationWhyUnmet: fmt.Sprintf("running only on battery power charged to %.0f%%, which is not above the allowed %.0f%%",
+					powerStatus.ChargePercent, condition.allowOnBatteryAbovePercent),
+			}
+		}
+	}
+	return ConditionResult{Met: true}
+}
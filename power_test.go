@@ -0,0 +1,111 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// evaluates ac_power with the power monitor reading the given status directly
+func evaluateACPowerWithStatus(t *testing.T, parameters ConditionParameters, powerStatus PowerStatus) ConditionResult {
+	t.Helper()
+	earlierPowerMonitor := daemonPowerMonitor
+	defer func() { daemonPowerMonitor = earlierPowerMonitor }()
+	daemonPowerMonitor = newPowerMonitor(newScriptedPowerSource(ScriptedPowerReading{PowerStatus: powerStatus}), 1, 0)
+
+	condition, err := newACPowerCondition(parameters)
+	if err != nil {
+		t.Fatalf("could not set up ac_power condition: %v", err)
+	}
+	return condition.Evaluate()
+}
+
+func TestACPowerCondition(t *testing.T) {
+	testCases := []struct {
+		name        string
+		parameters  ConditionParameters
+		powerStatus PowerStatus
+		expectedMet bool
+	}{
+		{"no battery", ConditionParameters{}, PowerStatus{}, true},
+		{"plugged in", ConditionParameters{}, PowerStatus{HasBattery: true, ChargeKnown: true, ChargePercent: 50}, true},
+		{"on battery", ConditionParameters{}, PowerStatus{RunningOnBattery: true, HasBattery: true, ChargeKnown: true, ChargePercent: 90}, false},
+		{"on battery above allowed charge", ConditionParameters{"allow_on_battery_above_percent": 80.0},
+			PowerStatus{RunningOnBattery: true, HasBattery: true, ChargeKnown: true, ChargePercent: 90}, true},
+		{"on battery at allowed charge", ConditionParameters{"allow_on_battery_above_percent": 80.0},
+			PowerStatus{RunningOnBattery: true, HasBattery: true, ChargeKnown: true, ChargePercent: 80}, false},
+		{"plugged in below minimum charge", ConditionParameters{"minimum_charge_percent": 30.0},
+			PowerStatus{HasBattery: true, ChargeKnown: true, ChargePercent: 20}, false},
+		{"on battery with unknown charge", ConditionParameters{}, PowerStatus{RunningOnBattery: true, HasBattery: true}, false},
+		{"on battery with unknown charge but allowed", ConditionParameters{"allow_on_battery_above_percent": 10.0},
+			PowerStatus{RunningOnBattery: true, HasBattery: true}, false},
+		{"plugged in with unknown charge", ConditionParameters{}, PowerStatus{HasBattery: true}, true},
+		{"plugged in with unknown charge and minimum", ConditionParameters{"minimum_charge_percent": 30.0}, PowerStatus{HasBattery: true}, false},
+	}
+	for _, testCase := range testCases {
+		result := evaluateACPowerWithStatus(t, testCase.parameters, testCase.powerStatus)
+		if result.Met != testCase.expectedMet {
+			t.Errorf("%v: met is %v, expected %v (%v)", testCase.name, result.Met, testCase.expectedMet, result.ExplanationWhyUnmet)
+		}
+	}
+}
+
+// creates a power supply directory like the ones in /sys/class/power_supply
+func writeFakePowerSupply(t *testing.T, pathToPowerSupplyDirectory string, name string, attributes map[string]string) {
+	t.Helper()
+	pathToPowerSupply := filepath.Join(pathToPowerSupplyDirectory, name)
+	if err := os.MkdirAll(pathToPowerSupply, 0755); err != nil {
+		t.Fatal(err)
+	}
+	for attributeName, value := range attributes {
+		if err := ioutil.WriteFile(filepath.Join(pathToPowerSupply, attributeName), []byte(value+"\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestSysfsPowerSource(t *testing.T) {
+	testCases := []struct {
+		name           string
+		powerSupplies  map[string]map[string]string
+		expectedStatus PowerStatus
+	}{
+		{"desktop", map[string]map[string]string{
+			"AC": {"type": "Mains", "online": "1"},
+		}, PowerStatus{ACAdapterStateKnown: true, ACAdapterOnline: true}},
+		{"charging laptop", map[string]map[string]string{
+			"AC":   {"type": "Mains", "online": "1"},
+			"BAT0": {"type": "Battery", "status": "Charging", "energy_now": "30", "energy_full": "60"},
+		}, PowerStatus{HasBattery: true, ChargeKnown: true, ChargePercent: 50, ACAdapterStateKnown: true, ACAdapterOnline: true}},
+		{"unplugged laptop not discharging", map[string]map[string]string{
+			"AC":   {"type": "Mains", "online": "0"},
+			"BAT0": {"type": "Battery", "status": "Not charging", "charge_now": "45", "charge_full": "50"},
+		}, PowerStatus{RunningOnBattery: true, HasBattery: true, ChargeKnown: true, ChargePercent: 90, ACAdapterStateKnown: true}},
+		{"discharging battery without full capacity", map[string]map[string]string{
+			"BAT0": {"type": "Battery", "status": "Discharging", "energy_now": "30", "energy_full": "0"},
+		}, PowerStatus{RunningOnBattery: true, HasBattery: true}},
+		{"battery of a mouse", map[string]map[string]string{
+			"hid-mouse": {"type": "Battery", "scope": "Device", "status": "Discharging", "capacity": "10"},
+		}, PowerStatus{}},
+	}
+	for _, testCase := range testCases {
+		pathToPowerSupplyDirectory, err := ioutil.TempDir("", "power_supply")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(pathToPowerSupplyDirectory)
+		for name, attributes := range testCase.powerSupplies {
+			writeFakePowerSupply(t, pathToPowerSupplyDirectory, name, attributes)
+		}
+
+		powerStatus, err := sysfsPowerSource{pathToPowerSupplyDirectory: pathToPowerSupplyDirectory}.ReadPowerStatus()
+		if err != nil {
+			t.Errorf("%v: %v", testCase.name, err)
+			continue
+		}
+		if powerStatus != testCase.expectedStatus {
+			t.Errorf("%v: got %+v, expected %+v", testCase.name, powerStatus, testCase.expectedStatus)
+		}
+	}
+}
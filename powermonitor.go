@@ -0,0 +1,186 @@
+package main
+
+// Debounces the readings of the power source, because they are often wrong or fail for a few
+// seconds after plugging in or unplugging, and flapping chargers would otherwise start and stop
+// commands all the time
+
+import (
+	"sync"
+	"time"
+)
+
+// PowerState is what the device is running on, as decided after debouncing
+type PowerState string
+
+// states the power supply can be in after debouncing
+const (
+	// no consistent readings yet or the power source keeps failing
+	PowerStateUnknown   PowerState = "Unknown"
+	PowerStateOnACPower PowerState = "OnACPower"
+	PowerStateOnBattery PowerState = "OnBattery"
+)
+
+// PowerStateTransition is a change of the debounced power state
+type PowerStateTransition struct {
+	From PowerState `json:"from"`
+	To   PowerState `json:"to"`
+	At   time.Time  `json:"at"`
+}
+
+// how the daemon debounces the power state, a new state is only taken over when
+// it was read this many times in a row and for at least this long
+var (
+	powerMonitorSamplingInterval            = 2 * time.Second
+	powerMonitorRequiredConsistentReadings  = 3
+	powerMonitorRequiredStablePeriod        = 5 * time.Second
+	maximumNumberOfPowerTransitionsToRecord = 50
+)
+
+// powerMonitor samples a power source and only changes its state after consistent readings
+type powerMonitor struct {
+	mutex  sync.Mutex
+	source PowerSource
+
+	requiredConsistentReadings int
+	requiredStablePeriod       time.Duration
+
+	// whether sample is called regularly, otherwise the source is read on every request
+	running bool
+
+	state PowerState
+	// last successful reading, for the charge of the batteries
+	lastStatus PowerStatus
+	// last reading error, tells why the state is unknown
+	lastError error
+
+	// state the readings currently point to, which is not taken over yet
+	candidateState              PowerState
+	candidateSince              time.Time
+	consistentCandidateReadings int
+
+	// newest transition last
+	transitions []PowerStateTransition
+}
+
+// the monitor used by the conditions, started by the daemon
+var daemonPowerMonitor = newPowerMonitor(activePowerSource,
+	powerMonitorRequiredConsistentReadings, powerMonitorRequiredStablePeriod)
+
+func newPowerMonitor(source PowerSource, requiredConsistentReadings int, requiredStablePeriod time.Duration) *powerMonitor {
+	return &powerMonitor{
+		source:                     source,
+		requiredConsistentReadings: requiredConsistentReadings,
+		requiredStablePeriod:       requiredStablePeriod,
+		state:                      PowerStateUnknown,
+		candidateState:             PowerStateUnknown,
+		transitions:                make([]PowerStateTransition, 0),
+	}
+}
+
+// samples the power source in the given interval in the background, the state is
+// unknown until the readings were consistent for long enough
+func (monitor *powerMonitor) start(samplingInterval time.Duration) {
+	monitor.mutex.Lock()
+	monitor.running = true
+	monitor.mutex.Unlock()
+
+	go func() {
+		for {
+			monitor.sample(time.Now())
+			time.Sleep(samplingInterval)
+		}
+	}()
+}
+
+// reads the power source once and changes the state if the readings were consistent for long enough
+func (monitor *powerMonitor) sample(now time.Time) {
+	powerStatus, err := monitor.source.ReadPowerStatus()
+
+	monitor.mutex.Lock()
+	defer monitor.mutex.Unlock()
+
+	readState := powerStateOfReading(powerStatus, err)
+	if err != nil {
+		monitor.lastError = err
+	} else {
+		monitor.lastStatus = powerStatus
+		monitor.lastError = nil
+	}
+
+	if readState != monitor.candidateState {
+		monitor.candidateState = readState
+		monitor.candidateSince = now
+		monitor.consistentCandidateReadings = 0
+	}
+	monitor.consistentCandidateReadings++
+
+	if monitor.candidateState == monitor.state {
+		return
+	}
+	if monitor.consistentCandidateReadings < monitor.requiredConsistentReadings ||
+		now.Sub(monitor.candidateSince) < monitor.requiredStablePeriod {
+		return
+	}
+
+	transition := PowerStateTransition{From: monitor.state, To: monitor.candidateState, At: now}
+	logInfo("Power state changed", logFieldOf("from", transition.From), logFieldOf("to", transition.To),
+		logFieldOf("consistent_readings", monitor.consistentCandidateReadings))
+
+	countPowerStateTransition(transition)
+
+	monitor.state = monitor.candidateState
+	monitor.transitions = append(monitor.transitions, transition)
+	if len(monitor.transitions) > maximumNumberOfPowerTransitionsToRecord {
+		monitor.transitions = monitor.transitions[len(monitor.transitions)-maximumNumberOfPowerTransitionsToRecord:]
+	}
+}
+
+func powerStateOfReading(powerStatus PowerStatus, err error) PowerState {
+	switch {
+	case err != nil:
+		return PowerStateUnknown
+	case powerStatus.RunningOnBattery:
+		return PowerStateOnBattery
+	default:
+		return PowerStateOnACPower
+	}
+}
+
+// the debounced power state, the last successful reading and the last reading error,
+// read directly from the source without debouncing when the monitor is not running,
+// e.g. when not in daemon mode
+func (monitor *powerMonitor) currentPowerState() (PowerState, PowerStatus, error) {
+	monitor.mutex.Lock()
+	running := monitor.running
+	monitor.mutex.Unlock()
+
+	if !running {
+		powerStatus, err := monitor.source.ReadPowerStatus()
+		return powerStateOfReading(powerStatus, err), powerStatus, err
+	}
+
+	monitor.mutex.Lock()
+	defer monitor.mutex.Unlock()
+	return monitor.state, monitor.lastStatus, monitor.lastError
+}
+
+// the recorded changes of the power state, oldest first, served by the HTTP API to see whether a charger flaps
+func (monitor *powerMonitor) transitionHistory() []PowerStateTransition {
+	monitor.mutex.Lock()
+	defer monitor.mutex.Unlock()
+
+	transitions := make([]PowerStateTransition, len(monitor.transitions))
+	copy(transitions, monitor.transitions)
+	return transitions
+}
+
+// number of changes of the power state after the given time, to notice flapping chargers
+func (monitor *powerMonitor) numberOfTransitionsSince(since time.Time) int {
+	numberOfTransitions := 0
+	for _, currentTransition := range monitor.transitionHistory() {
+		if currentTransition.At.After(since) {
+			numberOfTransitions++
+		}
+	}
+	return numberOfTransitions
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestScriptedPowerSourceReturnsReadingsScriptedLater(t *testing.T) {
+	source := newScriptedPowerSource(pluggedInReading)
+	for i := 0; i < 3; i++ {
+		if powerStatus, _ := source.ReadPowerStatus(); powerStatus.RunningOnBattery {
+			t.Fatalf("read %v: running on battery, but only plugged in was scripted", i)
+		}
+	}
+
+	source.Script(unpluggedReading, errorReading)
+	if powerStatus, err := source.ReadPowerStatus(); err != nil || !powerStatus.RunningOnBattery {
+		t.Fatalf("first reading scripted later was skipped, got %+v, %v", powerStatus, err)
+	}
+	if _, err := source.ReadPowerStatus(); err == nil {
+		t.Fatal("second reading scripted later was skipped")
+	}
+	if _, err := source.ReadPowerStatus(); err == nil {
+		t.Fatal("last reading is not repeated")
+	}
+}
+
+func TestNewScriptedPowerSourceFromString(t *testing.T) {
+	if _, err := newScriptedPowerSourceFromString("plugged, unplugged,error"); err != nil {
+		t.Fatalf("valid script rejected: %v", err)
+	}
+	if _, err := newScriptedPowerSourceFromString("plugged,charging"); err == nil {
+		t.Fatal("unknown step accepted")
+	}
+}
+
+func TestPowerMonitorDebounce(t *testing.T) {
+	testCases := []struct {
+		name   string
+		script []ScriptedPowerReading
+		// one sample per second, the state expected after each
+		expectedStates []PowerState
+	}{
+		{"stays unknown until readings are consistent for long enough",
+			[]ScriptedPowerReading{pluggedInReading},
+			[]PowerState{PowerStateUnknown, PowerStateUnknown, PowerStateUnknown, PowerStateUnknown, PowerStateUnknown, PowerStateOnACPower}},
+		{"ignores a short unplugging",
+			[]ScriptedPowerReading{pluggedInReading, pluggedInReading, pluggedInReading, pluggedInReading, pluggedInReading, pluggedInReading,
+				unpluggedReading, unpluggedReading, pluggedInReading},
+			[]PowerState{PowerStateUnknown, PowerStateUnknown, PowerStateUnknown, PowerStateUnknown, PowerStateUnknown, PowerStateOnACPower,
+				PowerStateOnACPower, PowerStateOnACPower, PowerStateOnACPower}},
+		{"errors after plugging in delay the state",
+			[]ScriptedPowerReading{errorReading, errorReading, pluggedInReading},
+			[]PowerState{PowerStateUnknown, PowerStateUnknown, PowerStateUnknown, PowerStateUnknown, PowerStateUnknown, PowerStateUnknown,
+				PowerStateUnknown, PowerStateOnACPower}},
+		{"takes over unplugging after consistent readings",
+			[]ScriptedPowerReading{pluggedInReading, pluggedInReading, pluggedInReading, pluggedInReading, pluggedInReading, pluggedInReading,
+				unpluggedReading},
+			[]PowerState{PowerStateUnknown, PowerStateUnknown, PowerStateUnknown, PowerStateUnknown, PowerStateUnknown, PowerStateOnACPower,
+				PowerStateOnACPower, PowerStateOnACPower, PowerStateOnACPower, PowerStateOnACPower, PowerStateOnACPower, PowerStateOnBattery}},
+	}
+	for _, testCase := range testCases {
+		monitor := newPowerMonitor(newScriptedPowerSource(testCase.script...), 3, 5*time.Second)
+		monitor.running = true
+		now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+		for sampleIndex, expectedState := range testCase.expectedStates {
+			monitor.sample(now)
+			if state, _, _ := monitor.currentPowerState(); state != expectedState {
+				t.Errorf("%v: state after sample %v is %v, expected %v", testCase.name, sampleIndex, state, expectedState)
+			}
+			now = now.Add(time.Second)
+		}
+	}
+}
+
+func TestPowerMonitorCountsTransitions(t *testing.T) {
+	source := newScriptedPowerSource(pluggedInReading)
+	monitor := newPowerMonitor(source, 1, 0)
+	monitor.running = true
+	now := time.Now()
+
+	monitor.sample(now)
+	source.Script(unpluggedReading)
+	monitor.sample(now.Add(time.Second))
+	source.Script(pluggedInReading)
+	monitor.sample(now.Add(2 * time.Second))
+
+	if numberOfTransitions := monitor.numberOfTransitionsSince(now.Add(-time.Minute)); numberOfTransitions != 3 {
+		t.Fatalf("%v transitions recorded, expected 3 from unknown to plugged, unplugged and plugged again", numberOfTransitions)
+	}
+	if numberOfTransitions := monitor.numberOfTransitionsSince(now.Add(1500 * time.Millisecond)); numberOfTransitions != 1 {
+		t.Fatalf("%v transitions after the unplugging, expected 1", numberOfTransitions)
+	}
+}
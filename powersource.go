@@ -0,0 +1,235 @@
+package main
+
+// Sources for the state of the power supply of the device, exchangeable so the scheduling
+// can be tried without a battery or on machines where one source doesn't work
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/distatus/battery"
+)
+
+// PowerStatus is a snapshot of the power supply of the device
+type PowerStatus struct {
+	RunningOnBattery bool
+	// false for devices without batteries, the charge is meaningless then
+	HasBattery bool
+	// false when the batteries don't report their capacity, the charge is meaningless then too
+	ChargeKnown bool
+	// combined charge of all batteries from 0 to 100
+	ChargePercent float64
+	// whether the source can tell if an AC adapter is connected, only then ACAdapterOnline is meaningful
+	ACAdapterStateKnown bool
+	ACAdapterOnline     bool
+}
+
+// PowerSource reads the current state of the power supply
+type PowerSource interface {
+	ReadPowerStatus() (PowerStatus, error)
+}
+
+// environment variable to choose the power source, mainly for trying things out,
+// see powerSourceFromEnvironment for the possible values
+const powerSourceEnvironmentVariable = "WORKSCHEDULER_POWER_SOURCE"
+
+const pathToPowerSupplyDirectory = "/sys/class/power_supply"
+
+// the power source used by the conditions
+var activePowerSource = powerSourceFromEnvironment()
+
+// "battery" uses the battery library, "sysfs" reads /sys/class/power_supply and
+// "scripted:plugged,unplugged,error,..." replays the given readings, see newScriptedPowerSourceFromString.
+// Without a value sysfs is used if it exists, because it also knows about the AC adapter.
+func powerSourceFromEnvironment() PowerSource {
+	chosenPowerSource := os.Getenv(powerSourceEnvironmentVariable)
+
+	switch {
+	case chosenPowerSource == "battery":
+		return batteryLibraryPowerSource{}
+	case chosenPowerSource == "sysfs":
+		return sysfsPowerSource{pathToPowerSupplyDirectory: pathToPowerSupplyDirectory}
+	case strings.HasPrefix(chosenPowerSource, scriptedPowerSourcePrefix):
+		scriptedPowerSource, err := newScriptedPowerSourceFromString(strings.TrimPrefix(chosenPowerSource, scriptedPowerSourcePrefix))
+		if err != nil {
+			logWarning("Invalid scripted power source, falling back to the default", logFieldOf("variable", powerSourceEnvironmentVariable), errorLogField(err))
+			break
+		}
+		return scriptedPowerSource
+	case chosenPowerSource != "":
+		logWarning("Unknown power source, falling back to the default", logFieldOf("variable", powerSourceEnvironmentVariable), logFieldOf("power_source", chosenPowerSource))
+	}
+
+	if _, err := os.Stat(pathToPowerSupplyDirectory); err == nil {
+		return sysfsPowerSource{pathToPowerSupplyDirectory: pathToPowerSupplyDirectory}
+	}
+	return batteryLibraryPowerSource{}
+}
+
+// batteryLibraryPowerSource works on every operating system the battery library supports,
+// but only knows about batteries and not about the AC adapter
+type batteryLibraryPowerSource struct{}
+
+func (source batteryLibraryPowerSource) ReadPowerStatus() (PowerStatus, error) {
+
+	powerStatus := PowerStatus{}
+
+	// This often returns an error shortly after being plugged in, but is fine a few seconds later
+	// and returns the correct value then
+	batteries, err := battery.GetAll()
+	if err != nil {
+		logDebug("Could not get battery info!", errorLogField(err))
+		return powerStatus, err
+	}
+
+	totalCurrentCapacity := 0.0
+	totalFullCapacity := 0.0
+
+	// check if there is a battery that is discharging to determine if running on battery or AC:
+	for _, currentBattery := range batteries {
+		if currentBattery.State == battery.Discharging {
+			// If at least one battery is discharing, the external power (if present)
+			// is not enough to charge the laptop as a whole and it is
+			// losing charge on at least one battery.
+			// This is a case where we consider it running on battery power.
+			powerStatus.RunningOnBattery = true
+		}
+		totalCurrentCapacity += currentBattery.Current
+		totalFullCapacity += currentBattery.Full
+	}
+
+	// when device has a battery:
+	// no battery is discharging so every battery is either charging or full
+	// -> device not runing on battery
+
+	// when device has no battery:
+	// no battery that is discharging was found, because there are no batteries
+	// -> device not running on battery
+
+	setCombinedCharge(&powerStatus, len(batteries), totalCurrentCapacity, totalFullCapacity)
+
+	return powerStatus, nil
+
+}
+
+// the charge is combined over all batteries, weighted by their capacity, so a nearly empty
+// small battery doesn't count as much as a big one
+// batteries that report no full capacity are still batteries, only their charge is unknown
+func setCombinedCharge(powerStatus *PowerStatus, numberOfBatteries int, totalCurrentCapacity float64, totalFullCapacity float64) {
+	powerStatus.HasBattery = numberOfBatteries > 0
+	if powerStatus.HasBattery && totalFullCapacity > 0 {
+		powerStatus.ChargeKnown = true
+		powerStatus.ChargePercent = 100 * totalCurrentCapacity / totalFullCapacity
+	}
+}
+
+// sysfsPowerSource reads the power supplies the linux kernel exposes, which includes
+// whether the AC adapter is online
+type sysfsPowerSource struct {
+	pathToPowerSupplyDirectory string
+}
+
+func (source sysfsPowerSource) ReadPowerStatus() (PowerStatus, error) {
+	powerStatus := PowerStatus{}
+
+	powerSupplies, err := ioutil.ReadDir(source.pathToPowerSupplyDirectory)
+	if err != nil {
+		return powerStatus, err
+	}
+
+	numberOfBatteries := 0
+	totalCurrentCapacity := 0.0
+	totalFullCapacity := 0.0
+
+	for _, currentPowerSupply := range powerSupplies {
+		pathToPowerSupply := filepath.Join(source.pathToPowerSupplyDirectory, currentPowerSupply.Name())
+
+		powerSupplyType, err := readSysfsValue(pathToPowerSupply, "type")
+		if err != nil {
+			return powerStatus, err
+		}
+
+		switch powerSupplyType {
+		case "Mains", "USB":
+			online, err := readSysfsValue(pathToPowerSupply, "online")
+			if err != nil {
+				return powerStatus, err
+			}
+			powerStatus.ACAdapterStateKnown = true
+			if online == "1" {
+				powerStatus.ACAdapterOnline = true
+			}
+
+		case "Battery":
+			// batteries of connected devices like mice are also listed, but don't power this device
+			if scope, _ := readSysfsValue(pathToPowerSupply, "scope"); scope == "Device" {
+				continue
+			}
+
+			status, err := readSysfsValue(pathToPowerSupply, "status")
+			if err != nil {
+				return powerStatus, err
+			}
+			if status == "Discharging" {
+				powerStatus.RunningOnBattery = true
+			}
+
+			currentCapacity, fullCapacity, err := readSysfsBatteryCapacity(pathToPowerSupply)
+			if err != nil {
+				return powerStatus, err
+			}
+			numberOfBatteries++
+			totalCurrentCapacity += currentCapacity
+			totalFullCapacity += fullCapacity
+		}
+	}
+
+	setCombinedCharge(&powerStatus, numberOfBatteries, totalCurrentCapacity, totalFullCapacity)
+
+	// without any online adapter, the batteries are the only thing powering the device,
+	// even if the firmware reports them as not charging instead of discharging
+	if powerStatus.HasBattery && powerStatus.ACAdapterStateKnown && !powerStatus.ACAdapterOnline {
+		powerStatus.RunningOnBattery = true
+	}
+
+	return powerStatus, nil
+}
+
+// batteries report their capacity either as energy (µWh) or as charge (µAh) depending
+// on the firmware, the percentage is the same for both
+func readSysfsBatteryCapacity(pathToBattery string) (float64, float64, error) {
+	for _, prefix := range []string{"energy", "charge"} {
+		currentCapacity, currentError := readSysfsNumber(pathToBattery, prefix+"_now")
+		fullCapacity, fullError := readSysfsNumber(pathToBattery, prefix+"_full")
+		if currentError == nil && fullError == nil {
+			return currentCapacity, fullCapacity, nil
+		}
+	}
+
+	// some batteries only report the percentage
+	capacityPercent, err := readSysfsNumber(pathToBattery, "capacity")
+	if err != nil {
+		return 0, 0, fmt.Errorf("battery %v reports neither energy, charge nor capacity", pathToBattery)
+	}
+	return capacityPercent, 100, nil
+}
+
+func readSysfsValue(pathToPowerSupply string, attributeName string) (string, error) {
+	value, err := ioutil.ReadFile(filepath.Join(pathToPowerSupply, attributeName))
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(value)), nil
+}
+
+func readSysfsNumber(pathToPowerSupply string, attributeName string) (float64, error) {
+	value, err := readSysfsValue(pathToPowerSupply, attributeName)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseFloat(value, 64)
+}
@@ -0,0 +1,88 @@
+package main
+
+// A power source that replays given readings, for trying the scheduling on machines without
+// a battery and for driving it through plugging in and unplugging in tests
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+const scriptedPowerSourcePrefix = "scripted:"
+
+// ScriptedPowerReading is one reading a scripted power source returns
+type ScriptedPowerReading struct {
+	PowerStatus PowerStatus
+	Err         error
+}
+
+// scriptedPowerSource returns its readings one after the other on each read and
+// keeps returning the last one once all were returned, until more are scripted
+type scriptedPowerSource struct {
+	mutex    sync.Mutex
+	readings []ScriptedPowerReading
+	nextRead int
+}
+
+// readings scripts can be made of
+var (
+	pluggedInReading = ScriptedPowerReading{PowerStatus: PowerStatus{
+		HasBattery: true, ChargeKnown: true, ChargePercent: 80, ACAdapterStateKnown: true, ACAdapterOnline: true,
+	}}
+	unpluggedReading = ScriptedPowerReading{PowerStatus: PowerStatus{
+		RunningOnBattery: true, HasBattery: true, ChargeKnown: true, ChargePercent: 80, ACAdapterStateKnown: true, ACAdapterOnline: false,
+	}}
+	errorReading = ScriptedPowerReading{Err: errors.New("scripted error when reading power status")}
+)
+
+func newScriptedPowerSource(readings ...ScriptedPowerReading) *scriptedPowerSource {
+	return &scriptedPowerSource{readings: readings}
+}
+
+// parses a comma separated list of "plugged", "unplugged" and "error"
+func newScriptedPowerSourceFromString(script string) (*scriptedPowerSource, error) {
+	readings := make([]ScriptedPowerReading, 0)
+	for _, currentStep := range strings.Split(script, ",") {
+		switch strings.TrimSpace(currentStep) {
+		case "plugged":
+			readings = append(readings, pluggedInReading)
+		case "unplugged":
+			readings = append(readings, unpluggedReading)
+		case "error":
+			readings = append(readings, errorReading)
+		default:
+			return nil, fmt.Errorf("unknown step %q, only plugged, unplugged and error are possible", currentStep)
+		}
+	}
+	return newScriptedPowerSource(readings...), nil
+}
+
+// appends readings, which are returned after the ones already scripted
+func (source *scriptedPowerSource) Script(readings ...ScriptedPowerReading) {
+	source.mutex.Lock()
+	defer source.mutex.Unlock()
+	source.readings = append(source.readings, readings...)
+}
+
+func (source *scriptedPowerSource) ReadPowerStatus() (PowerStatus, error) {
+	source.mutex.Lock()
+	defer source.mutex.Unlock()
+
+	if len(source.readings) == 0 {
+		return PowerStatus{}, errors.New("scripted power source has no readings")
+	}
+
+	readingIndex := source.nextRead
+	if readingIndex >= len(source.readings) {
+		readingIndex = len(source.readings) - 1
+	}
+	// stops right after the last reading, so readings scripted later are the next ones returned
+	if source.nextRead < len(source.readings) {
+		source.nextRead++
+	}
+
+	reading := source.readings[readingIndex]
+	return reading.PowerStatus, reading.Err
+}
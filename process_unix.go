@@ -0,0 +1,37 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+// Signals to the process group of a command, so processes it started are affected as well
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// the command gets its own process group with its process id as the group id
+func startInNewProcessGroup(command *exec.Cmd) {
+	command.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+}
+
+// negative process ids address the whole process group
+func pauseProcessGroup(processGroupID int) error {
+	return syscall.Kill(-processGroupID, syscall.SIGSTOP)
+}
+
+func resumeProcessGroup(processGroupID int) error {
+	return syscall.Kill(-processGroupID, syscall.SIGCONT)
+}
+
+func terminateProcessGroup(processGroupID int) error {
+	if err := syscall.Kill(-processGroupID, syscall.SIGTERM); err != nil {
+		return err
+	}
+	// stopped processes only handle the termination signal once they are continued
+	return syscall.Kill(-processGroupID, syscall.SIGCONT)
+}
+
+func killProcessGroup(processGroupID int) error {
+	return syscall.Kill(-processGroupID, syscall.SIGKILL)
+}
@@ -0,0 +1,37 @@
+//go:build windows
+// +build windows
+
+package main
+
+// Windows has no process groups that can be signalled, only the process itself can be killed
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+)
+
+var errPausingNotSupported = errors.New("pausing commands is not supported on windows")
+
+func startInNewProcessGroup(command *exec.Cmd) {}
+
+func pauseProcessGroup(processGroupID int) error {
+	return errPausingNotSupported
+}
+
+func resumeProcessGroup(processGroupID int) error {
+	return errPausingNotSupported
+}
+
+// there is no way to ask a process to exit gracefully, so it is killed right away
+func terminateProcessGroup(processGroupID int) error {
+	return killProcessGroup(processGroupID)
+}
+
+func killProcessGroup(processGroupID int) error {
+	process, err := os.FindProcess(processGroupID)
+	if err != nil {
+		return err
+	}
+	return process.Kill()
+}
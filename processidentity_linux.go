@@ -0,0 +1,44 @@
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+)
+
+// the start time of a process in clock ticks after boot from /proc/<pid>/stat, errors if there is no
+// such process or it already exited and only waits to be reaped
+func startTimeOfProcess(processID int) (string, error) {
+	stat, err := ioutil.ReadFile("/proc/" + strconv.Itoa(processID) + "/stat")
+	if err != nil {
+		return "", err
+	}
+	// the name in parentheses may contain spaces and parentheses itself, the fields after it don't
+	endOfName := strings.LastIndexByte(string(stat), ')')
+	if endOfName == -1 {
+		return "", fmt.Errorf("unexpected format of /proc/%v/stat", processID)
+	}
+	// starting with the state, which is the third field, the start time is the 22nd
+	fields := strings.Fields(string(stat[endOfName+1:]))
+	if len(fields) < 20 {
+		return "", fmt.Errorf("unexpected format of /proc/%v/stat", processID)
+	}
+	if fields[0] == "Z" || fields[0] == "X" {
+		return "", fmt.Errorf("process %v already exited", processID)
+	}
+	return fields[19], nil
+}
+
+// changes with every boot, so processes of an earlier boot are not mistaken for ones with the same
+// process id and start time now
+func currentBootID() (string, error) {
+	bootID, err := ioutil.ReadFile("/proc/sys/kernel/random/boot_id")
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(bootID)), nil
+}
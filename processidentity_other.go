@@ -0,0 +1,16 @@
+//go:build !linux
+// +build !linux
+
+package main
+
+import "errors"
+
+// without /proc a process can't be told apart from a later one with the same process id,
+// so processes of an earlier daemon are never stopped by a later one
+func startTimeOfProcess(processID int) (string, error) {
+	return "", errors.New("start times of processes are only known on linux")
+}
+
+func currentBootID() (string, error) {
+	return "", errors.New("boot ids are only known on linux")
+}
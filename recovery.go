@@ -0,0 +1,138 @@
+package main
+
+// Commands that were running when the daemon stopped, e.g. because it crashed or the computer
+// was turned off, would stay in the running state forever. Their runs count as interrupted and
+// are retried like failed ones. A process that still runs is stopped first: its output went through
+// pipes of the earlier daemon, so it would die from SIGPIPE the next time it writes anyway.
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// how often a process of an earlier daemon is checked while it is stopped, it can't be waited for because it is not our child
+const processOfEarlierDaemonPollInterval = time.Second
+
+// remembers the process of a run in the command store, so it is recognized after a restart
+func recordCurrentRunOfCommand(command CommandWithArguments, run RunRecord, processID int) {
+	currentRun := &CurrentRun{
+		RunID:               run.RunID,
+		StartTime:           run.StartTime,
+		ProcessID:           processID,
+		OutputLocation:      run.OutputLocation,
+		ErrorOutputLocation: run.ErrorOutputLocation,
+	}
+	// stay empty where they are unknown or when the process already exited, then the process
+	// is never touched after a restart
+	currentRun.ProcessStartTime, _ = startTimeOfProcess(processID)
+	currentRun.BootID, _ = currentBootID()
+
+	err := modifyCommandInCommandStoreByName(command.Name, func(storedCommand *CommandWithArguments) {
+		storedCommand.CurrentRun = currentRun
+	})
+	if err != nil {
+		logWarning("Could not remember the process of the run, it is not recognized after a restart of the daemon",
+			commandLogFields(command, logFieldOf("run_id", run.RunID), errorLogField(err))...)
+	}
+}
+
+// called once when the daemon starts, before it starts any commands itself
+func recoverCommandsLeftRunning() {
+	commandStore, err := readAndParseCommandStore()
+	if err != nil {
+		logError("Could not check for commands left running by the last daemon", errorLogField(err))
+		return
+	}
+
+	for _, currentCommand := range commandStore.Commands {
+		if currentCommand.State != CommandRunning && currentCommand.State != CommandPaused {
+			continue
+		}
+		if currentCommand.CurrentRun != nil && isProcessOfRunStillRunning(*currentCommand.CurrentRun) {
+			stopProcessOfEarlierDaemon(currentCommand)
+			continue
+		}
+		logWarning("Command was running when the daemon stopped and its process is gone, counting the run as interrupted",
+			commandLogFields(currentCommand)...)
+		finishRunOfEarlierDaemon(currentCommand, "the daemon stopped while the command was running and its process does not exist anymore")
+	}
+}
+
+// the same process id alone could be a later process, so the start time has to match as well,
+// and after a reboot start times begin anew, so it has to be the same boot
+func isProcessOfRunStillRunning(currentRun CurrentRun) bool {
+	if currentRun.ProcessStartTime == "" || currentRun.BootID == "" {
+		return false
+	}
+	if bootID, err := currentBootID(); err != nil || bootID != currentRun.BootID {
+		return false
+	}
+	startTime, err := startTimeOfProcess(currentRun.ProcessID)
+	return err == nil && startTime == currentRun.ProcessStartTime
+}
+
+// terminates the process group of a run of an earlier daemon and kills it after the grace period,
+// the command stays in the running state until the process is gone, so it is not started twice
+func stopProcessOfEarlierDaemon(command CommandWithArguments) {
+	currentRun := *command.CurrentRun
+	gracePeriod := command.TerminateGracePeriod
+	if gracePeriod == 0 {
+		gracePeriod = defaultTerminateGracePeriod
+	}
+	runLogFields := commandLogFields(command, logFieldOf("run_id", currentRun.RunID), logFieldOf("process_id", currentRun.ProcessID))
+
+	logWarning("Command was running when the daemon stopped and its process still runs, terminating it because its output can't be taken over",
+		append(runLogFields, logFieldOf("grace_period", gracePeriod))...)
+	if err := terminateProcessGroup(currentRun.ProcessID); err != nil {
+		logError("Could not terminate process of command", append(runLogFields, errorLogField(err))...)
+	}
+
+	go func() {
+		killTime := time.Now().Add(gracePeriod)
+		killed := false
+		for isProcessOfRunStillRunning(currentRun) {
+			if !killed && time.Now().After(killTime) {
+				logWarning("Process of command did not exit within the grace period after being terminated, killing it", runLogFields...)
+				if err := killProcessGroup(currentRun.ProcessID); err != nil {
+					logError("Could not kill process of command", append(runLogFields, errorLogField(err))...)
+				}
+				killed = true
+			}
+			time.Sleep(processOfEarlierDaemonPollInterval)
+		}
+		finishRunOfEarlierDaemon(command, "the daemon stopped while the command was running, its process was stopped after the restart because its output can't be taken over")
+	}()
+}
+
+// records the run in the history as interrupted and moves the command out of the running state
+func finishRunOfEarlierDaemon(command CommandWithArguments, explanation string) {
+	now := time.Now()
+	run := RunRecord{
+		RunID:       uuid.New(),
+		CommandName: command.Name,
+		CommandUUID: command.UUID,
+		// unknown if the daemon stopped before the process was started
+		StartTime: now,
+		EndTime:   now,
+		Outcome:   CommandInterrupted,
+		Error:     explanation,
+	}
+	if command.CurrentRun != nil {
+		run.RunID = command.CurrentRun.RunID
+		run.StartTime = command.CurrentRun.StartTime
+		run.OutputLocation = command.CurrentRun.OutputLocation
+		run.ErrorOutputLocation = command.CurrentRun.ErrorOutputLocation
+	}
+	run.Duration = run.EndTime.Sub(run.StartTime)
+	setExitStatusOfRun(&run, nil)
+	recordRunInMetrics(run)
+
+	runLogFields := commandLogFields(command, logFieldOf("run_id", run.RunID))
+	if err := changeStateOfCommand(command.UUID, run.Outcome); err != nil {
+		logError("Error when changing state of command", append(runLogFields, logFieldOf("state", run.Outcome), errorLogField(err))...)
+	}
+	if err := addRunToRunHistory(run, command.HistoryRetention); err != nil {
+		logError("Error when adding run of command to the run history", append(runLogFields, errorLogField(err))...)
+	}
+}
@@ -0,0 +1,75 @@
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"os"
+	"os/exec"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestIsProcessOfRunStillRunning(t *testing.T) {
+	ownStartTime, err := startTimeOfProcess(os.Getpid())
+	if err != nil {
+		t.Fatal(err)
+	}
+	bootID, err := currentBootID()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		name            string
+		currentRun      CurrentRun
+		expectedRunning bool
+	}{
+		{"same process", CurrentRun{ProcessID: os.Getpid(), ProcessStartTime: ownStartTime, BootID: bootID}, true},
+		{"later process with the same id", CurrentRun{ProcessID: os.Getpid(), ProcessStartTime: "1", BootID: bootID}, false},
+		{"process of an earlier boot", CurrentRun{ProcessID: os.Getpid(), ProcessStartTime: ownStartTime, BootID: "earlier-boot"}, false},
+		{"boot unknown", CurrentRun{ProcessID: os.Getpid(), ProcessStartTime: ownStartTime}, false},
+		{"start time unknown", CurrentRun{ProcessID: os.Getpid(), BootID: bootID}, false},
+	}
+	for _, testCase := range testCases {
+		if running := isProcessOfRunStillRunning(testCase.currentRun); running != testCase.expectedRunning {
+			t.Errorf("%v: running %v, expected %v", testCase.name, running, testCase.expectedRunning)
+		}
+	}
+}
+
+func TestProcessOfEarlierDaemonIsStopped(t *testing.T) {
+	useTemporaryStore(t)
+
+	process := exec.Command("sleep", "60")
+	startInNewProcessGroup(process)
+	if err := process.Start(); err != nil {
+		t.Fatal(err)
+	}
+	// reaps the process once it was stopped, otherwise it would stay a zombie of the test
+	go process.Wait()
+
+	command := CommandWithArguments{Name: "left-running", UUID: uuid.New(), State: CommandRunning}
+	err := activeStore.Update(func(commandStore *CommandStore) error {
+		commandStore.Commands = []CommandWithArguments{command}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	recordCurrentRunOfCommand(command, RunRecord{RunID: uuid.New(), StartTime: time.Now()}, process.Process.Pid)
+
+	recoverCommandsLeftRunning()
+	for start := time.Now(); time.Since(start) < 10*time.Second; time.Sleep(100 * time.Millisecond) {
+		storedCommand, err := findCommandInCommandStoreByName(command.Name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if storedCommand.State == CommandInterrupted {
+			return
+		}
+	}
+	t.Fatal("command is not interrupted 10 seconds after its process was terminated")
+}
@@ -0,0 +1,116 @@
+package main
+
+// Retrying failed commands with exponential backoff, instead of on every check of the daemon
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"time"
+)
+
+// RetryPolicy decides how often and after which delays a failed command is run again,
+// zero values mean the defaults
+type RetryPolicy struct {
+	// attempts in a row that may fail before giving up, 0 means never give up
+	MaxAttempts int `toml:"max_attempts"`
+	// delay after the first failure
+	InitialDelay time.Duration `toml:"initial_delay"`
+	// each further failure multiplies the delay by this
+	Multiplier float64       `toml:"multiplier"`
+	MaxDelay   time.Duration `toml:"max_delay"`
+	// the delay is changed randomly by up to this fraction of it, so commands failing at the
+	// same time don't retry at the same time again
+	Jitter float64 `toml:"jitter"`
+}
+
+func init() {
+	// otherwise every start of the daemon would use the same jitter
+	rand.Seed(time.Now().UnixNano())
+}
+
+const (
+	defaultRetryInitialDelay = time.Minute
+	defaultRetryMultiplier   = 2
+	defaultRetryMaxDelay     = time.Hour
+)
+
+func (policy RetryPolicy) validate() error {
+	if policy.MaxAttempts < 0 {
+		return fmt.Errorf("max_attempts of retry policy must not be negative, but is %v", policy.MaxAttempts)
+	}
+	if policy.InitialDelay < 0 || policy.MaxDelay < 0 {
+		return fmt.Errorf("delays of retry policy must not be negative")
+	}
+	if policy.Multiplier != 0 && policy.Multiplier < 1 {
+		return fmt.Errorf("multiplier of retry policy must be at least 1, but is %v", policy.Multiplier)
+	}
+	if policy.Jitter < 0 || policy.Jitter > 1 {
+		return fmt.Errorf("jitter of retry policy must be between 0 and 1, but is %v", policy.Jitter)
+	}
+	return nil
+}
+
+func (policy RetryPolicy) withDefaults() RetryPolicy {
+	if policy.InitialDelay == 0 {
+		policy.InitialDelay = defaultRetryInitialDelay
+	}
+	if policy.Multiplier == 0 {
+		policy.Multiplier = defaultRetryMultiplier
+	}
+	if policy.MaxDelay == 0 {
+		policy.MaxDelay = defaultRetryMaxDelay
+	}
+	return policy
+}
+
+// delay before the next attempt after the given number of failed attempts in a row
+func (policy RetryPolicy) delayAfterFailedAttempts(failedAttempts int) time.Duration {
+	policy = policy.withDefaults()
+
+	delay := float64(policy.InitialDelay) * math.Pow(policy.Multiplier, float64(failedAttempts-1))
+	if delay > float64(policy.MaxDelay) {
+		delay = float64(policy.MaxDelay)
+	}
+	// random factor between 1 - jitter and 1 + jitter
+	delay *= 1 + policy.Jitter*(2*rand.Float64()-1)
+
+	return time.Duration(delay)
+}
+
+// failed and interrupted commands wait until their next attempt
+func isWaitingForRetry(state CommandState) bool {
+	return state == CommandFailed || state == CommandInterrupted
+}
+
+// lets a command start over with its retries, e.g. after it gave up and the user enabled it again
+// or changed its config
+func resetRetriesOfCommand(command *CommandWithArguments) {
+	if command.State == CommandGaveUp {
+		command.State = CommandWaitingToBeRun
+	}
+	command.FailedAttempts = 0
+	command.NextAttemptAt = time.Time{}
+}
+
+// updates the attempt count and the time of the next attempt after a run ended with the given state,
+// a command that failed too often ends up in the given up state
+func applyRetryPolicyAfterRun(command *CommandWithArguments, endedWithState CommandState, now time.Time) {
+	switch endedWithState {
+	case CommandSuccessful:
+		command.FailedAttempts = 0
+		command.NextAttemptAt = time.Time{}
+
+	case CommandFailed, CommandInterrupted:
+		command.FailedAttempts++
+		if command.RetryPolicy.MaxAttempts > 0 && command.FailedAttempts >= command.RetryPolicy.MaxAttempts {
+			logWarning("Command failed too many times in a row, giving up", commandLogFields(*command, logFieldOf("failed_attempts", command.FailedAttempts))...)
+			command.State = CommandGaveUp
+			command.NextAttemptAt = time.Time{}
+			return
+		}
+		command.NextAttemptAt = now.Add(command.RetryPolicy.delayAfterFailedAttempts(command.FailedAttempts))
+		logInfo("Command failed, retrying later", commandLogFields(*command, logFieldOf("state", endedWithState), logFieldOf("failed_attempts", command.FailedAttempts),
+			logFieldOf("next_attempt_at", command.NextAttemptAt.Format(time.RFC3339)))...)
+	}
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDelayAfterFailedAttempts(t *testing.T) {
+	testCases := []struct {
+		policy         RetryPolicy
+		failedAttempts int
+		expectedDelay  time.Duration
+	}{
+		{RetryPolicy{}, 1, time.Minute},
+		{RetryPolicy{}, 2, 2 * time.Minute},
+		{RetryPolicy{}, 4, 8 * time.Minute},
+		{RetryPolicy{}, 20, time.Hour},
+		{RetryPolicy{InitialDelay: 10 * time.Second, Multiplier: 3}, 3, 90 * time.Second},
+		{RetryPolicy{InitialDelay: 10 * time.Second, Multiplier: 1}, 5, 10 * time.Second},
+		{RetryPolicy{InitialDelay: time.Minute, MaxDelay: 5 * time.Minute}, 4, 5 * time.Minute},
+	}
+	for _, testCase := range testCases {
+		if delay := testCase.policy.delayAfterFailedAttempts(testCase.failedAttempts); delay != testCase.expectedDelay {
+			t.Errorf("%+v after %v failed attempts: delay is %v, expected %v", testCase.policy, testCase.failedAttempts, delay, testCase.expectedDelay)
+		}
+	}
+}
+
+func TestDelayWithJitterStaysInRange(t *testing.T) {
+	policy := RetryPolicy{InitialDelay: 100 * time.Second, Jitter: 0.1}
+	for i := 0; i < 1000; i++ {
+		if delay := policy.delayAfterFailedAttempts(1); delay < 90*time.Second || delay > 110*time.Second {
+			t.Fatalf("delay %v is not within 10%% of 100s", delay)
+		}
+	}
+}
+
+func TestInvalidRetryPolicies(t *testing.T) {
+	for _, policy := range []RetryPolicy{
+		{MaxAttempts: -1},
+		{InitialDelay: -time.Second},
+		{MaxDelay: -time.Second},
+		{Multiplier: 0.5},
+		{Jitter: 1.5},
+	} {
+		if err := policy.validate(); err == nil {
+			t.Errorf("%+v: no error", policy)
+		}
+	}
+}
+
+func TestApplyRetryPolicyAfterRun(t *testing.T) {
+	now := time.Date(2026, time.March, 4, 12, 0, 0, 0, time.UTC)
+	command := CommandWithArguments{Name: "flaky", RetryPolicy: RetryPolicy{MaxAttempts: 3, InitialDelay: time.Minute}}
+
+	for attempt := 1; attempt <= 2; attempt++ {
+		command.State = CommandFailed
+		applyRetryPolicyAfterRun(&command, CommandFailed, now)
+		if command.FailedAttempts != attempt || command.State != CommandFailed || !command.NextAttemptAt.After(now) {
+			t.Fatalf("after failed attempt %v: %v failed attempts, state %v, next attempt at %v", attempt, command.FailedAttempts, command.State, command.NextAttemptAt)
+		}
+	}
+
+	command.State = CommandInterrupted
+	applyRetryPolicyAfterRun(&command, CommandInterrupted, now)
+	if command.State != CommandGaveUp {
+		t.Fatalf("state after the last allowed attempt is %v, expected %v", command.State, CommandGaveUp)
+	}
+
+	resetRetriesOfCommand(&command)
+	if command.State != CommandWaitingToBeRun || command.FailedAttempts != 0 || !command.NextAttemptAt.IsZero() {
+		t.Fatalf("after reset: state %v, %v failed attempts, next attempt at %v", command.State, command.FailedAttempts, command.NextAttemptAt)
+	}
+
+	command.FailedAttempts = 2
+	command.State = CommandSuccessful
+	applyRetryPolicyAfterRun(&command, CommandSuccessful, now)
+	if command.FailedAttempts != 0 {
+		t.Fatalf("%v failed attempts after a successful run", command.FailedAttempts)
+	}
+}
+
+func TestChangedConfigResetsRetries(t *testing.T) {
+	storedCommand := CommandWithArguments{
+		Name:                "backup",
+		AbsolutePath:        "/usr/bin/backup",
+		CommandArguments:    []string{""},
+		State:               CommandGaveUp,
+		FailedAttempts:      5,
+		RetryPolicy:         RetryPolicy{MaxAttempts: 5},
+		ConditionParameters: map[string]ConditionParameters{"ac_power": {"minimum_charge_percent": 50.0}},
+	}
+	// what the config gives, numbers are int64 there
+	commandFromConfig := CommandWithArguments{
+		Name:                "backup",
+		AbsolutePath:        "/usr/bin/backup",
+		CommandArguments:    []string{""},
+		RetryPolicy:         RetryPolicy{MaxAttempts: 5},
+		ConditionParameters: map[string]ConditionParameters{"ac_power": {"minimum_charge_percent": int64(50)}},
+	}
+
+	unchangedCommand := updateContentsOfCommand(storedCommand, commandFromConfig)
+	if unchangedCommand.State != CommandGaveUp || unchangedCommand.FailedAttempts != 5 {
+		t.Fatalf("unchanged config reset the retries: state %v, %v failed attempts", unchangedCommand.State, unchangedCommand.FailedAttempts)
+	}
+
+	commandFromConfig.AbsolutePath = "/usr/local/bin/backup"
+	changedCommand := updateContentsOfCommand(storedCommand, commandFromConfig)
+	if changedCommand.State != CommandWaitingToBeRun || changedCommand.FailedAttempts != 0 {
+		t.Fatalf("changed config kept the retries: state %v, %v failed attempts", changedCommand.State, changedCommand.FailedAttempts)
+	}
+}
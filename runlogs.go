@@ -0,0 +1,249 @@
+package main
+
+// Output of every run is written to its own log files instead of being buffered in memory,
+// so it can be looked at later and a chatty command can't fill up the memory
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+var runLogsDirectory = "./runLogs"
+
+// OutputSettings decides how the output of the runs of a command is stored, zero values mean the defaults
+type OutputSettings struct {
+	// standard error goes to its own file instead of being interleaved with standard out
+	SeparateStandardError bool `toml:"separate_stderr"`
+	// bytes per log file, output beyond that is dropped
+	MaxSize int64 `toml:"max_size"`
+}
+
+const defaultMaxSizeOfRunLog = 10 * 1024 * 1024
+
+func (settings OutputSettings) validate() error {
+	if settings.MaxSize < 0 {
+		return fmt.Errorf("max_size of output must not be negative, but is %v", settings.MaxSize)
+	}
+	return nil
+}
+
+// cappedLogWriter writes to a log file until it reaches its maximum size and drops everything after that,
+// everything written to the file is also sent to the followers of the run line by line
+type cappedLogWriter struct {
+	// shared by all writers of a run, it also protects the fields of the writer
+	live         *liveOutput
+	path         string
+	file         *os.File
+	maxSize      int64
+	writtenBytes int64
+	droppedBytes int64
+	// first error when writing, the command keeps running anyway
+	writeError error
+	// written to the file, but not sent to followers yet because the line is not finished
+	unfinishedLine []byte
+}
+
+func newCappedLogWriter(pathToLogFile string, maxSize int64, live *liveOutput) (*cappedLogWriter, error) {
+	// only readable and writeable by own user, output might contain secrets
+	file, err := os.OpenFile(pathToLogFile, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return &cappedLogWriter{live: live, path: pathToLogFile, file: file, maxSize: maxSize}, nil
+}
+
+// always reports the whole data as written, otherwise the command would get errors
+// when writing its output just because the log is full
+func (writer *cappedLogWriter) Write(data []byte) (int, error) {
+	writer.live.mutex.Lock()
+	defer writer.live.mutex.Unlock()
+
+	remainingBytes := writer.maxSize - writer.writtenBytes
+	if remainingBytes < 0 {
+		remainingBytes = 0
+	}
+	bytesToWrite := data
+	if int64(len(bytesToWrite)) > remainingBytes {
+		bytesToWrite = bytesToWrite[:remainingBytes]
+	}
+	writer.writeToFileAndFollowers(bytesToWrite)
+
+	droppedBytes := int64(len(data) - len(bytesToWrite))
+	if droppedBytes > 0 && writer.droppedBytes == 0 {
+		writer.writeToFileAndFollowers([]byte(fmt.Sprintf("\n======== Output truncated, log reached its maximum size of %v bytes ========\n", writer.maxSize)))
+	}
+	writer.droppedBytes += droppedBytes
+
+	return len(data), nil
+}
+
+// the mutex of the live output has to be held
+func (writer *cappedLogWriter) writeToFileAndFollowers(data []byte) {
+	if len(data) == 0 || writer.writeError != nil {
+		return
+	}
+	written, err := writer.file.Write(data)
+	writer.writtenBytes += int64(written)
+	writer.writeError = err
+
+	// followers get complete lines only, so output of standard out and error is not mixed within a line
+	writer.unfinishedLine = append(writer.unfinishedLine, data[:written]...)
+	for {
+		endOfLine := bytes.IndexByte(writer.unfinishedLine, '\n')
+		if endOfLine < 0 {
+			break
+		}
+		writer.live.sendToFollowers(writer.unfinishedLine[:endOfLine+1])
+		writer.unfinishedLine = writer.unfinishedLine[endOfLine+1:]
+	}
+}
+
+// bytes of the log file that were already sent to followers, a new follower reads them from the file
+func (writer *cappedLogWriter) bytesSentToFollowers() int64 {
+	return writer.writtenBytes - int64(len(writer.unfinishedLine))
+}
+
+// notes how much output was dropped at the end of the log and closes it
+func (writer *cappedLogWriter) Close() error {
+	writer.live.mutex.Lock()
+	defer writer.live.mutex.Unlock()
+
+	if writer.droppedBytes > 0 {
+		writer.writeToFileAndFollowers([]byte(fmt.Sprintf("======== %v bytes of output were dropped ========\n", writer.droppedBytes)))
+	}
+	// the last line of the command might not end with a newline
+	if len(writer.unfinishedLine) > 0 {
+		writer.live.sendToFollowers(writer.unfinishedLine)
+		writer.unfinishedLine = nil
+	}
+
+	closeError := writer.file.Close()
+	if writer.writeError != nil {
+		return writer.writeError
+	}
+	return closeError
+}
+
+// runOutput are the log files of one run
+type runOutput struct {
+	standardOut   *cappedLogWriter
+	standardError *cappedLogWriter
+	live          *liveOutput
+}
+
+// creates the log files of a run in the directory of its command and notes their location in the run
+func openRunOutput(command CommandWithArguments, run *RunRecord) (runOutput, error) {
+	output := runOutput{live: newLiveOutput()}
+
+	maxSize := command.OutputSettings.MaxSize
+	if maxSize == 0 {
+		maxSize = defaultMaxSizeOfRunLog
+	}
+
+	// names come from config file names or the command line, they must not lead out of the directory
+	commandLogsDirectory := filepath.Join(runLogsDirectory, filepath.Base(command.Name))
+	if err := os.MkdirAll(commandLogsDirectory, 0700); err != nil {
+		return output, err
+	}
+	// sorting the files by name sorts them by start time
+	baseNameOfLogs := filepath.Join(commandLogsDirectory, run.StartTime.Format("2006-01-02T15-04-05")+"_"+run.RunID.String())
+
+	var err error
+	if !command.OutputSettings.SeparateStandardError {
+		run.OutputLocation = baseNameOfLogs + ".log"
+		output.standardOut, err = newCappedLogWriter(run.OutputLocation, maxSize, output.live)
+		// the same writer for both, so exec.Cmd writes them interleaved from one goroutine
+		output.standardError = output.standardOut
+		output.live.writers = []*cappedLogWriter{output.standardOut}
+		return output, err
+	}
+
+	run.OutputLocation = baseNameOfLogs + ".stdout.log"
+	if output.standardOut, err = newCappedLogWriter(run.OutputLocation, maxSize, output.live); err != nil {
+		return output, err
+	}
+	run.ErrorOutputLocation = baseNameOfLogs + ".stderr.log"
+	if output.standardError, err = newCappedLogWriter(run.ErrorOutputLocation, maxSize, output.live); err != nil {
+		output.standardOut.Close()
+		return output, err
+	}
+	output.live.writers = []*cappedLogWriter{output.standardOut, output.standardError}
+	return output, nil
+}
+
+func (output runOutput) close() error {
+	err := output.standardOut.Close()
+	if output.standardError != output.standardOut {
+		if standardErrorCloseError := output.standardError.Close(); err == nil {
+			err = standardErrorCloseError
+		}
+	}
+	// after the writers sent their last lines
+	output.live.finish()
+	return err
+}
+
+// removes the log files of runs that are not in the history anymore
+func removeRunOutput(run RunRecord) {
+	for _, currentLocation := range []string{run.OutputLocation, run.ErrorOutputLocation} {
+		if currentLocation == "" {
+			continue
+		}
+		if err := os.Remove(currentLocation); err != nil && !os.IsNotExist(err) {
+			logWarning("Could not remove output of run", logFieldOf("command", run.CommandName), logFieldOf("uuid", run.CommandUUID), logFieldOf("run_id", run.RunID), errorLogField(err))
+		}
+	}
+}
+
+// errRunNotFound is returned when there is no run of a command with an id in the run history
+var errRunNotFound = errors.New("run not found in run history")
+
+// a run of a command by the start of its id, or the newest run if the start of the id is empty
+func findRunOfCommand(commandName string, runIDPrefix string) (RunRecord, error) {
+	runs, err := runsOfCommandSince(commandName, time.Time{})
+	if err != nil {
+		return RunRecord{}, err
+	}
+
+	// runs are sorted newest first
+	for _, currentRun := range runs {
+		if strings.HasPrefix(currentRun.RunID.String(), runIDPrefix) {
+			return currentRun, nil
+		}
+	}
+	if runIDPrefix == "" {
+		return RunRecord{}, fmt.Errorf("%w: command %v never ran", errRunNotFound, commandName)
+	}
+	return RunRecord{}, fmt.Errorf("%w: no run of command %v with id %v", errRunNotFound, commandName, runIDPrefix)
+}
+
+// prints the logs of a run, either one run by the start of its id or the newest run of a command
+func printRunLogs(commandName string, runIDPrefix string) error {
+	run, err := findRunOfCommand(commandName, runIDPrefix)
+	if err != nil {
+		return err
+	}
+	if run.OutputLocation == "" {
+		return fmt.Errorf("output of run %v was not stored", run.RunID)
+	}
+
+	for _, currentLocation := range []string{run.OutputLocation, run.ErrorOutputLocation} {
+		if currentLocation == "" {
+			continue
+		}
+		logContent, err := ioutil.ReadFile(currentLocation)
+		if err != nil {
+			return err
+		}
+		fmt.Println("======== Output of run", run.RunID, "started", run.StartTime.Format("2006-01-02 15:04:05"), "from", currentLocation, "========")
+		fmt.Print(string(logContent))
+		fmt.Println("======== End of output ========")
+	}
+	return nil
+}
@@ -0,0 +1,451 @@
+package main
+
+// Calendar schedules for commands, written as cron expressions ("0 3 * * *") or in the
+// OnCalendar format of systemd timers ("Mon..Fri *-*-* 03:00:00"). A schedule only decides the
+// earliest time a command may run again, the command still waits for its conditions after that.
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// how far into the future the next time of a schedule is searched, schedules that only
+// match further away, like a 30th of February, never match
+const maximumYearsToSearchForScheduledTime = 5
+
+// the years systemd accepts in OnCalendar, everything else is most likely a typo
+const (
+	minimumYearOfSchedule = 1970
+	maximumYearOfSchedule = 2199
+)
+
+// calendarSchedule is a set of allowed values for every part of a date and time,
+// both cron expressions and OnCalendar specifications are parsed into it
+type calendarSchedule struct {
+	// bit n set means value n is allowed
+	seconds     uint64
+	minutes     uint64
+	hours       uint64
+	daysOfMonth uint64
+	months      uint64
+	// 0 is sunday like in time.Weekday
+	weekdays uint64
+	// nil means every year
+	years []yearRange
+	// cron runs a command when either the day of month or the weekday matches if both are
+	// restricted, systemd requires both to match
+	dayOfMonthOrWeekday bool
+	location            *time.Location
+}
+
+// time after which a command with the given schedules may run again, the zero time when it may
+// run right away, the schedules are parsed when the command is checked, because they are only strings in the store
+func earliestScheduledTimeOfCommand(command CommandWithArguments) (time.Time, error) {
+	// the schedule counts from the last successful run, or from when the command was added if it never ran,
+	// commands that were added before the time was stored get it when the daemon starts
+	scheduledAfter := command.LastRun
+	if scheduledAfter.IsZero() {
+		scheduledAfter = command.AddedAt
+	}
+
+	earliestTime := time.Time{}
+	for _, currentSchedule := range []struct {
+		specification string
+		parse         func(string) (calendarSchedule, error)
+	}{
+		{command.CronSchedule, parseCronSchedule},
+		{command.OnCalendar, parseOnCalendarSchedule},
+	} {
+		if currentSchedule.specification == "" {
+			continue
+		}
+		schedule, err := currentSchedule.parse(currentSchedule.specification)
+		if err != nil {
+			return time.Time{}, err
+		}
+		nextTime, found := schedule.nextTimeAfter(scheduledAfter)
+		if !found {
+			return time.Time{}, fmt.Errorf("schedule %q never matches in the next %v years", currentSchedule.specification, maximumYearsToSearchForScheduledTime)
+		}
+		// with both schedules, both have to allow the command to run
+		if nextTime.After(earliestTime) {
+			earliestTime = nextTime
+		}
+	}
+	return earliestTime, nil
+}
+
+// first time strictly after the given one that matches the schedule, with full seconds
+func (schedule calendarSchedule) nextTimeAfter(after time.Time) (time.Time, bool) {
+	location := schedule.location
+	currentTime := after.In(location).Truncate(time.Second).Add(time.Second)
+	searchLimit := currentTime.AddDate(maximumYearsToSearchForScheduledTime, 0, 0)
+
+	// skip ahead by the biggest part of the date that doesn't match, starting the smaller parts at their beginning
+	for currentTime.Before(searchLimit) {
+		year, month, day := currentTime.Date()
+		hour, minute, second := currentTime.Clock()
+
+		var nextTime time.Time
+		switch {
+		case !schedule.matchesYear(year):
+			nextTime = time.Date(year+1, time.January, 1, 0, 0, 0, 0, location)
+		case !isBitSet(schedule.months, int(month)):
+			nextTime = time.Date(year, month+1, 1, 0, 0, 0, 0, location)
+		case !schedule.matchesDay(day, currentTime.Weekday()):
+			nextTime = time.Date(year, month, day+1, 0, 0, 0, 0, location)
+		case !isBitSet(schedule.hours, hour):
+			nextTime = time.Date(year, month, day, hour+1, 0, 0, 0, location)
+		case !isBitSet(schedule.minutes, minute):
+			nextTime = time.Date(year, month, day, hour, minute+1, 0, 0, location)
+		case !isBitSet(schedule.seconds, second):
+			nextTime = time.Date(year, month, day, hour, minute, second+1, 0, location)
+		default:
+			return currentTime, true
+		}
+
+		// when clocks are turned back, an hour exists twice and time.Date picks the first one,
+		// which would send the search back in time forever
+		if !nextTime.After(currentTime) {
+			nextTime = currentTime.Add(time.Second)
+		}
+		currentTime = nextTime
+	}
+	return time.Time{}, false
+}
+
+// yearRange are the years from first to last, both included
+type yearRange struct {
+	first int
+	last  int
+}
+
+func (schedule calendarSchedule) matchesYear(year int) bool {
+	if schedule.years == nil {
+		return true
+	}
+	for _, currentRange := range schedule.years {
+		if currentRange.first <= year && year <= currentRange.last {
+			return true
+		}
+	}
+	return false
+}
+
+func (schedule calendarSchedule) matchesDay(dayOfMonth int, weekday time.Weekday) bool {
+	dayOfMonthMatches := isBitSet(schedule.daysOfMonth, dayOfMonth)
+	weekdayMatches := isBitSet(schedule.weekdays, int(weekday))
+
+	if schedule.dayOfMonthOrWeekday {
+		dayOfMonthRestricted := schedule.daysOfMonth != allValuesBetween(1, 31)
+		weekdayRestricted := schedule.weekdays != allValuesBetween(0, 6)
+		if dayOfMonthRestricted && weekdayRestricted {
+			return dayOfMonthMatches || weekdayMatches
+		}
+	}
+	return dayOfMonthMatches && weekdayMatches
+}
+
+func isBitSet(bits uint64, bit int) bool {
+	return bits&(1<<uint(bit)) != 0
+}
+
+func allValuesBetween(minimum int, maximum int) uint64 {
+	var bits uint64
+	for value := minimum; value <= maximum; value++ {
+		bits |= 1 << uint(value)
+	}
+	return bits
+}
+
+// names that can be used instead of numbers, lower case
+var monthNames = map[string]int{
+	"jan": 1, "feb": 2, "mar": 3, "apr": 4, "may": 5, "jun": 6,
+	"jul": 7, "aug": 8, "sep": 9, "oct": 10, "nov": 11, "dec": 12,
+}
+var weekdayNames = map[string]int{
+	"sun": 0, "mon": 1, "tue": 2, "wed": 3, "thu": 4, "fri": 5, "sat": 6,
+}
+
+// how a list of values is written in one part of a schedule
+type scheduleFieldSyntax struct {
+	minimum int
+	maximum int
+	// names that can be used instead of numbers
+	names map[string]int
+	// "-" in cron and ".." in systemd
+	rangeSeparator string
+}
+
+// parses comma separated values, ranges and steps like "1,5-7,*/15" into a set of values
+func parseScheduleField(field string, syntax scheduleFieldSyntax) (uint64, error) {
+	var bits uint64
+	for _, currentPart := range strings.Split(field, ",") {
+		rangeSpecification := currentPart
+		step := 1
+
+		if stepIndex := strings.Index(currentPart, "/"); stepIndex >= 0 {
+			var err error
+			step, err = strconv.Atoi(currentPart[stepIndex+1:])
+			if err != nil || step <= 0 {
+				return 0, fmt.Errorf("invalid step in %q", currentPart)
+			}
+			rangeSpecification = currentPart[:stepIndex]
+		}
+
+		start, end := syntax.minimum, syntax.maximum
+		if rangeSpecification != "*" {
+			bounds := strings.SplitN(rangeSpecification, syntax.rangeSeparator, 2)
+			var err error
+			if start, err = parseScheduleValue(bounds[0], syntax); err != nil {
+				return 0, err
+			}
+			end = start
+			if len(bounds) == 2 {
+				if end, err = parseScheduleValue(bounds[1], syntax); err != nil {
+					return 0, err
+				}
+			} else if step > 1 {
+				// "5/10" means every 10 starting at 5
+				end = syntax.maximum
+			}
+		}
+		if start > end {
+			return 0, fmt.Errorf("range %q ends before it starts", currentPart)
+		}
+
+		for value := start; value <= end; value += step {
+			bits |= 1 << uint(value)
+		}
+	}
+	return bits, nil
+}
+
+func parseScheduleValue(value string, syntax scheduleFieldSyntax) (int, error) {
+	if number, found := syntax.names[strings.ToLower(value)]; found {
+		return number, nil
+	}
+	// systemd also allows full names like "Monday"
+	if len(value) > 3 {
+		if number, found := syntax.names[strings.ToLower(value[:3])]; found {
+			return number, nil
+		}
+	}
+	number, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid value %q", value)
+	}
+	if number < syntax.minimum || number > syntax.maximum {
+		return 0, fmt.Errorf("value %v is not between %v and %v", number, syntax.minimum, syntax.maximum)
+	}
+	return number, nil
+}
+
+// shortcuts for cron expressions
+var cronMacros = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+// parses the five fields "minute hour day-of-month month day-of-week" of a cron expression,
+// which are interpreted in local time
+func parseCronSchedule(specification string) (calendarSchedule, error) {
+	expression := strings.TrimSpace(specification)
+	if macroExpression, isMacro := cronMacros[strings.ToLower(expression)]; isMacro {
+		expression = macroExpression
+	}
+
+	fields := strings.Fields(expression)
+	if len(fields) != 5 {
+		return calendarSchedule{}, fmt.Errorf("cron schedule %q must have 5 fields: minute hour day-of-month month day-of-week", specification)
+	}
+
+	schedule := calendarSchedule{
+		seconds:             1,
+		dayOfMonthOrWeekday: true,
+		location:            time.Local,
+	}
+	fieldSyntaxes := []scheduleFieldSyntax{
+		{minimum: 0, maximum: 59, rangeSeparator: "-"},
+		{minimum: 0, maximum: 23, rangeSeparator: "-"},
+		{minimum: 1, maximum: 31, rangeSeparator: "-"},
+		{minimum: 1, maximum: 12, rangeSeparator: "-", names: monthNames},
+		// 7 is sunday, too
+		{minimum: 0, maximum: 7, rangeSeparator: "-", names: weekdayNames},
+	}
+	parsedFields := make([]uint64, len(fields))
+	for index, currentField := range fields {
+		bits, err := parseScheduleField(currentField, fieldSyntaxes[index])
+		if err != nil {
+			return calendarSchedule{}, fmt.Errorf("cron schedule %q: %v", specification, err)
+		}
+		parsedFields[index] = bits
+	}
+
+	schedule.minutes = parsedFields[0]
+	schedule.hours = parsedFields[1]
+	schedule.daysOfMonth = parsedFields[2]
+	schedule.months = parsedFields[3]
+	schedule.weekdays = parsedFields[4]
+	if isBitSet(schedule.weekdays, 7) {
+		schedule.weekdays = schedule.weekdays&^(1<<7) | 1
+	}
+	return schedule, nil
+}
+
+// shortcuts for OnCalendar specifications, see man systemd.time
+var onCalendarShortcuts = map[string]string{
+	"minutely":     "*-*-* *:*:00",
+	"hourly":       "*-*-* *:00:00",
+	"daily":        "*-*-* 00:00:00",
+	"monthly":      "*-*-01 00:00:00",
+	"weekly":       "Mon *-*-* 00:00:00",
+	"yearly":       "*-01-01 00:00:00",
+	"annually":     "*-01-01 00:00:00",
+	"quarterly":    "*-01,04,07,10-01 00:00:00",
+	"semiannually": "*-01,07-01 00:00:00",
+}
+
+// parses "[weekdays] [year-month-day] [hour:minute[:second]] [time zone]" like systemd timers do,
+// a missing date means every day and a missing time means midnight
+func parseOnCalendarSchedule(specification string) (calendarSchedule, error) {
+	schedule := calendarSchedule{
+		seconds:     1,
+		minutes:     1,
+		hours:       1,
+		daysOfMonth: allValuesBetween(1, 31),
+		months:      allValuesBetween(1, 12),
+		weekdays:    allValuesBetween(0, 6),
+		location:    time.Local,
+	}
+
+	fields := strings.Fields(specification)
+	if len(fields) == 0 {
+		return schedule, fmt.Errorf("calendar schedule is empty")
+	}
+
+	// a time zone is always last, like "Europe/Berlin" or "UTC"
+	if len(fields) > 1 {
+		lastField := fields[len(fields)-1]
+		if !strings.ContainsAny(lastField, ":-*") {
+			location, err := time.LoadLocation(lastField)
+			if err != nil {
+				return schedule, fmt.Errorf("calendar schedule %q: unknown time zone %q", specification, lastField)
+			}
+			schedule.location = location
+			fields = fields[:len(fields)-1]
+		}
+	}
+
+	if len(fields) == 1 {
+		if expandedSpecification, isShortcut := onCalendarShortcuts[strings.ToLower(fields[0])]; isShortcut {
+			fields = strings.Fields(expandedSpecification)
+		}
+	}
+
+	for index, currentField := range fields {
+		var err error
+		switch {
+		case index == 0 && !strings.ContainsAny(currentField, ":-*0123456789"):
+			schedule.weekdays, err = parseScheduleField(currentField, scheduleFieldSyntax{minimum: 0, maximum: 6, rangeSeparator: "..", names: weekdayNames})
+		case strings.Contains(currentField, ":"):
+			err = parseOnCalendarTime(currentField, &schedule)
+		case strings.Contains(currentField, "-"):
+			err = parseOnCalendarDate(currentField, &schedule)
+		default:
+			err = fmt.Errorf("don't know what %q is", currentField)
+		}
+		if err != nil {
+			return schedule, fmt.Errorf("calendar schedule %q: %v", specification, err)
+		}
+	}
+	return schedule, nil
+}
+
+// "year-month-day" or "month-day"
+func parseOnCalendarDate(date string, schedule *calendarSchedule) error {
+	parts := strings.Split(date, "-")
+	if len(parts) == 3 {
+		if parts[0] != "*" {
+			years, err := parseOnCalendarYears(parts[0])
+			if err != nil {
+				return err
+			}
+			schedule.years = years
+		}
+		parts = parts[1:]
+	}
+	if len(parts) != 2 {
+		return fmt.Errorf("date %q must look like year-month-day or month-day", date)
+	}
+
+	var err error
+	if schedule.months, err = parseScheduleField(parts[0], scheduleFieldSyntax{minimum: 1, maximum: 12, rangeSeparator: ".."}); err != nil {
+		return err
+	}
+	schedule.daysOfMonth, err = parseScheduleField(parts[1], scheduleFieldSyntax{minimum: 1, maximum: 31, rangeSeparator: ".."})
+	return err
+}
+
+// years don't fit into the bits of the other fields, so they are a list of ranges,
+// only values, ranges and lists are supported for them
+func parseOnCalendarYears(field string) ([]yearRange, error) {
+	years := make([]yearRange, 0)
+	for _, currentPart := range strings.Split(field, ",") {
+		bounds := strings.SplitN(currentPart, "..", 2)
+		first, err := parseOnCalendarYear(bounds[0])
+		if err != nil {
+			return years, err
+		}
+		last := first
+		if len(bounds) == 2 {
+			if last, err = parseOnCalendarYear(bounds[1]); err != nil {
+				return years, err
+			}
+		}
+		if last < first {
+			return years, fmt.Errorf("year range %q ends before it starts", currentPart)
+		}
+		years = append(years, yearRange{first: first, last: last})
+	}
+	return years, nil
+}
+
+func parseOnCalendarYear(value string) (int, error) {
+	year, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid year %q", value)
+	}
+	if year < minimumYearOfSchedule || year > maximumYearOfSchedule {
+		return 0, fmt.Errorf("year %v is not between %v and %v", year, minimumYearOfSchedule, maximumYearOfSchedule)
+	}
+	return year, nil
+}
+
+// "hour:minute" or "hour:minute:second"
+func parseOnCalendarTime(clockTime string, schedule *calendarSchedule) error {
+	parts := strings.Split(clockTime, ":")
+	if len(parts) == 2 {
+		parts = append(parts, "00")
+	}
+	if len(parts) != 3 {
+		return fmt.Errorf("time %q must look like hour:minute or hour:minute:second", clockTime)
+	}
+
+	var err error
+	if schedule.hours, err = parseScheduleField(parts[0], scheduleFieldSyntax{minimum: 0, maximum: 23, rangeSeparator: ".."}); err != nil {
+		return err
+	}
+	if schedule.minutes, err = parseScheduleField(parts[1], scheduleFieldSyntax{minimum: 0, maximum: 59, rangeSeparator: ".."}); err != nil {
+		return err
+	}
+	schedule.seconds, err = parseScheduleField(parts[2], scheduleFieldSyntax{minimum: 0, maximum: 59, rangeSeparator: ".."})
+	return err
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestNextTimeOfSchedule(t *testing.T) {
+	after := time.Date(2026, time.March, 4, 10, 30, 15, 0, time.Local) // a wednesday
+	testCases := []struct {
+		specification string
+		parse         func(string) (calendarSchedule, error)
+		expectedTime  time.Time
+	}{
+		{"0 3 * * *", parseCronSchedule, time.Date(2026, time.March, 5, 3, 0, 0, 0, time.Local)},
+		{"*/15 * * * *", parseCronSchedule, time.Date(2026, time.March, 4, 10, 45, 0, 0, time.Local)},
+		{"@hourly", parseCronSchedule, time.Date(2026, time.March, 4, 11, 0, 0, 0, time.Local)},
+		{"30 2 * * sat,sun", parseCronSchedule, time.Date(2026, time.March, 7, 2, 30, 0, 0, time.Local)},
+		{"0 0 * * 7", parseCronSchedule, time.Date(2026, time.March, 8, 0, 0, 0, 0, time.Local)},
+		// cron runs when either the day of month or the weekday matches
+		{"0 0 1 * fri", parseCronSchedule, time.Date(2026, time.March, 6, 0, 0, 0, 0, time.Local)},
+		{"0 12 1-7 jun *", parseCronSchedule, time.Date(2026, time.June, 1, 12, 0, 0, 0, time.Local)},
+		{"*-*-* 03:00", parseOnCalendarSchedule, time.Date(2026, time.March, 5, 3, 0, 0, 0, time.Local)},
+		{"Mon..Fri *-*-* 10:30:20", parseOnCalendarSchedule, time.Date(2026, time.March, 4, 10, 30, 20, 0, time.Local)},
+		// systemd requires both the day of month and the weekday to match
+		{"Fri *-*-01 00:00", parseOnCalendarSchedule, time.Date(2026, time.May, 1, 0, 0, 0, 0, time.Local)},
+		{"weekly", parseOnCalendarSchedule, time.Date(2026, time.March, 9, 0, 0, 0, 0, time.Local)},
+		{"quarterly", parseOnCalendarSchedule, time.Date(2026, time.April, 1, 0, 0, 0, 0, time.Local)},
+		{"2028-02-29 12:00", parseOnCalendarSchedule, time.Date(2028, time.February, 29, 12, 0, 0, 0, time.Local)},
+		{"2020..2025,2027..2199-*-* 03:00", parseOnCalendarSchedule, time.Date(2027, time.January, 1, 3, 0, 0, 0, time.Local)},
+		{"*-*-* 12:00 UTC", parseOnCalendarSchedule, nextNoonInUTC(after)},
+	}
+	for _, testCase := range testCases {
+		schedule, err := testCase.parse(testCase.specification)
+		if err != nil {
+			t.Errorf("%q: %v", testCase.specification, err)
+			continue
+		}
+		nextTime, found := schedule.nextTimeAfter(after)
+		if !found || !nextTime.Equal(testCase.expectedTime) {
+			t.Errorf("%q: next time is %v (found %v), expected %v", testCase.specification, nextTime, found, testCase.expectedTime)
+		}
+	}
+}
+
+func nextNoonInUTC(after time.Time) time.Time {
+	noon := time.Date(after.UTC().Year(), after.UTC().Month(), after.UTC().Day(), 12, 0, 0, 0, time.UTC)
+	if !noon.After(after) {
+		noon = noon.AddDate(0, 0, 1)
+	}
+	return noon
+}
+
+func TestScheduleThatNeverMatches(t *testing.T) {
+	for _, specification := range []string{"*-02-30 00:00", "2020-*-* 00:00"} {
+		schedule, err := parseOnCalendarSchedule(specification)
+		if err != nil {
+			t.Errorf("%q: %v", specification, err)
+			continue
+		}
+		if nextTime, found := schedule.nextTimeAfter(time.Now()); found {
+			t.Errorf("%q: matches at %v", specification, nextTime)
+		}
+	}
+}
+
+func TestInvalidSchedules(t *testing.T) {
+	testCases := []struct {
+		specification string
+		parse         func(string) (calendarSchedule, error)
+	}{
+		{"0 3 * *", parseCronSchedule},
+		{"60 * * * *", parseCronSchedule},
+		{"*/0 * * * *", parseCronSchedule},
+		{"5-1 * * * *", parseCronSchedule},
+		{"0 0 * foo *", parseCronSchedule},
+		{"", parseOnCalendarSchedule},
+		{"*-*-* 25:00", parseOnCalendarSchedule},
+		{"*-*-* 03:00 Mars/Olympus", parseOnCalendarSchedule},
+		{"sometimes", parseOnCalendarSchedule},
+		// only the years systemd accepts
+		{"2020..2000000000-*-* 03:00", parseOnCalendarSchedule},
+		{"1969-*-* 03:00", parseOnCalendarSchedule},
+		{"2030..2028-*-* 03:00", parseOnCalendarSchedule},
+		{"20x0-*-* 03:00", parseOnCalendarSchedule},
+	}
+	for _, testCase := range testCases {
+		if _, err := testCase.parse(testCase.specification); err == nil {
+			t.Errorf("%q: no error", testCase.specification)
+		}
+	}
+}
+
+func TestEarliestScheduledTimeCountsFromLastRunOrAddedAt(t *testing.T) {
+	addedAt := time.Date(2026, time.March, 4, 10, 0, 0, 0, time.Local)
+	command := CommandWithArguments{OnCalendar: "*-*-* 03:00", AddedAt: addedAt}
+
+	earliestTime, err := earliestScheduledTimeOfCommand(command)
+	if err != nil || !earliestTime.Equal(time.Date(2026, time.March, 5, 3, 0, 0, 0, time.Local)) {
+		t.Errorf("never run: earliest time %v, %v", earliestTime, err)
+	}
+
+	command.LastRun = time.Date(2026, time.March, 10, 4, 0, 0, 0, time.Local)
+	earliestTime, err = earliestScheduledTimeOfCommand(command)
+	if err != nil || !earliestTime.Equal(time.Date(2026, time.March, 11, 3, 0, 0, 0, time.Local)) {
+		t.Errorf("run before: earliest time %v, %v", earliestTime, err)
+	}
+
+	// both schedules have to allow it
+	command.CronSchedule = "0 5 12 * *"
+	earliestTime, err = earliestScheduledTimeOfCommand(command)
+	if err != nil || !earliestTime.Equal(time.Date(2026, time.March, 12, 5, 0, 0, 0, time.Local)) {
+		t.Errorf("two schedules: earliest time %v, %v", earliestTime, err)
+	}
+}
+
+// a year far from the year 1 must not be too far away to be found when the config is checked
+func TestConfigWithScheduleOfConcreteYear(t *testing.T) {
+	pathToConfigFile := filepath.Join(t.TempDir(), "yearly.toml")
+	config := fmt.Sprintf("AbsolutePath = \"/bin/true\"\non_calendar = \"%v-*-* 03:00\"\n", time.Now().Year()+1)
+	if err := ioutil.WriteFile(pathToConfigFile, []byte(config), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := commandFromConfigFile(pathToConfigFile); err != nil {
+		t.Fatalf("valid schedule rejected: %v", err)
+	}
+}
@@ -0,0 +1,213 @@
+package main
+
+// Status of the commands in the command store for the list and status subcommands,
+// as a table for humans or as JSON or YAML for scripts
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+// commandStatus is what list and status show about a command
+type commandStatus struct {
+	Name     string       `json:"name" yaml:"name"`
+	State    CommandState `json:"state" yaml:"state"`
+	Disabled bool         `json:"disabled" yaml:"disabled"`
+	// missing if the command never ran successfully
+	LastRun *time.Time `json:"last_run,omitempty" yaml:"last_run,omitempty"`
+	// earliest time the command may start when its conditions are met,
+	// missing if it is running or won't be started, e.g. because it is disabled
+	NextEligibleTime *time.Time `json:"next_eligible_time,omitempty" yaml:"next_eligible_time,omitempty"`
+	// why there is no next eligible time
+	NotEligibleBecause string `json:"not_eligible_because,omitempty" yaml:"not_eligible_because,omitempty"`
+	// conditions that were not met when the daemon evaluated them last with the explanation why
+	BlockingConditions []blockingCondition `json:"blocking_conditions" yaml:"blocking_conditions"`
+	// when the daemon evaluated the conditions last, missing if it didn't yet or no daemon runs,
+	// the blocking conditions are unknown then
+	ConditionsEvaluatedAt *time.Time `json:"conditions_evaluated_at,omitempty" yaml:"conditions_evaluated_at,omitempty"`
+}
+
+// blockingCondition is a condition that is not met right now
+type blockingCondition struct {
+	Name        string `json:"name" yaml:"name"`
+	Explanation string `json:"explanation" yaml:"explanation"`
+}
+
+// output formats of list and status
+const (
+	outputFormatTable = "table"
+	outputFormatJSON  = "json"
+	outputFormatYAML  = "yaml"
+)
+
+// the earliest time the command may start when its conditions are met, leaving out the conditions,
+// if there is none the reason why is returned instead
+func nextEligibleTimeOfCommand(command CommandWithArguments, now time.Time) (time.Time, string, error) {
+	switch {
+	case command.State == CommandRunning:
+		return time.Time{}, "running", nil
+	case command.State == CommandPaused:
+		return time.Time{}, "paused while running", nil
+	case command.RunRequested:
+		return now, "", nil
+	case command.Disabled:
+		return time.Time{}, "disabled", nil
+	case command.State == CommandGaveUp:
+		return time.Time{}, "gave up after failing too often", nil
+	}
+
+	eligibleTime := now
+	laterOf := func(otherTime time.Time) {
+		if otherTime.After(eligibleTime) {
+			eligibleTime = otherTime
+		}
+	}
+
+	if isWaitingForRetry(command.State) {
+		laterOf(command.NextAttemptAt)
+	}
+	if !command.LastRun.IsZero() {
+		laterOf(command.LastRun.Add(command.DurationBetweenRuns))
+	}
+	earliestScheduledTime, err := earliestScheduledTimeOfCommand(command)
+	if err != nil {
+		return time.Time{}, "", err
+	}
+	laterOf(earliestScheduledTime)
+
+	nextAllowedStart, foundAllowedStart, err := nextStartAllowedByTimeWindows(command, eligibleTime)
+	if err != nil {
+		return time.Time{}, "", err
+	}
+	if !foundAllowedStart {
+		return time.Time{}, "time windows don't allow a start in the next week", nil
+	}
+	return nextAllowedStart, "", nil
+}
+
+func statusOfCommand(command CommandWithArguments, now time.Time) commandStatus {
+	status := commandStatus{
+		Name:               command.Name,
+		State:              command.State,
+		Disabled:           command.Disabled,
+		BlockingConditions: []blockingCondition{},
+	}
+	if !command.LastRun.IsZero() {
+		lastRun := command.LastRun
+		status.LastRun = &lastRun
+	}
+
+	nextEligibleTime, notEligibleBecause, err := nextEligibleTimeOfCommand(command, now)
+	if err != nil {
+		status.NotEligibleBecause = err.Error()
+	} else if nextEligibleTime.IsZero() {
+		status.NotEligibleBecause = notEligibleBecause
+	} else {
+		status.NextEligibleTime = &nextEligibleTime
+	}
+
+	// listing commands must not probe the network or ask D-Bus, so only what the daemon found out
+	// is shown, which it only checks once all other gates of a command passed
+	evaluatedResults, found := lastConditionResultsOfCommand(command.UUID)
+	if !found {
+		return status
+	}
+	evaluatedAt := evaluatedResults.EvaluatedAt
+	status.ConditionsEvaluatedAt = &evaluatedAt
+	for _, currentResult := range evaluatedResults.Results {
+		if !currentResult.Met {
+			status.BlockingConditions = append(status.BlockingConditions,
+				blockingCondition{Name: currentResult.ConditionName, Explanation: currentResult.ExplanationWhyUnmet})
+		}
+	}
+	return status
+}
+
+// the status of the commands with the given names, or of all commands if there are none
+func statusesOfCommands(commandNames []string) ([]commandStatus, error) {
+	commandStore, err := readAndParseCommandStore()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	statuses := make([]commandStatus, 0, len(commandStore.Commands))
+	for _, currentName := range commandNames {
+		command, err := commandStore.findCommandByName(currentName)
+		if err != nil {
+			return nil, err
+		}
+		statuses = append(statuses, statusOfCommand(command, now))
+	}
+	if len(commandNames) == 0 {
+		for _, currentCommand := range commandStore.Commands {
+			statuses = append(statuses, statusOfCommand(currentCommand, now))
+		}
+	}
+	return statuses, nil
+}
+
+func printStatuses(statuses []commandStatus, outputFormat string) error {
+	switch outputFormat {
+	case outputFormatJSON:
+		marshalledJSONData, err := json.MarshalIndent(statuses, "", "\t")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(marshalledJSONData))
+	case outputFormatYAML:
+		marshalledYAMLData, err := yaml.Marshal(statuses)
+		if err != nil {
+			return err
+		}
+		fmt.Print(string(marshalledYAMLData))
+	default:
+		printStatusTable(statuses, time.Now())
+	}
+	return nil
+}
+
+func printStatusTable(statuses []commandStatus, now time.Time) {
+	table := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(table, "NAME\tSTATE\tLAST RUN\tNEXT ELIGIBLE\tBLOCKED BY")
+	for _, currentStatus := range statuses {
+		state := string(currentStatus.State)
+		if currentStatus.Disabled {
+			state += " (disabled)"
+		}
+
+		lastRun := "never"
+		if currentStatus.LastRun != nil {
+			lastRun = currentStatus.LastRun.Format("2006-01-02 15:04")
+		}
+
+		nextEligible := currentStatus.NotEligibleBecause
+		if currentStatus.NextEligibleTime != nil {
+			nextEligible = "now"
+			if currentStatus.NextEligibleTime.After(now) {
+				nextEligible = currentStatus.NextEligibleTime.Format("2006-01-02 15:04")
+			}
+		}
+
+		blockingConditionNames := make([]string, 0, len(currentStatus.BlockingConditions))
+		for _, currentResult := range currentStatus.BlockingConditions {
+			blockingConditionNames = append(blockingConditionNames, currentResult.Name)
+		}
+		blockedBy := strings.Join(blockingConditionNames, ", ")
+		switch {
+		case currentStatus.ConditionsEvaluatedAt == nil:
+			blockedBy = "not evaluated"
+		case blockedBy == "":
+			blockedBy = "-"
+		}
+
+		fmt.Fprintf(table, "%v\t%v\t%v\t%v\t%v\n", currentStatus.Name, state, lastRun, nextEligible, blockedBy)
+	}
+	table.Flush()
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// a condition that fails the test when it is evaluated
+type conditionThatMustNotBeEvaluated struct {
+	t *testing.T
+}
+
+func (condition conditionThatMustNotBeEvaluated) Name() string {
+	return "must_not_be_evaluated"
+}
+
+func (condition conditionThatMustNotBeEvaluated) Evaluate() ConditionResult {
+	condition.t.Errorf("condition was evaluated for the status of a command")
+	return ConditionResult{}
+}
+
+func TestStatusShowsLastConditionResults(t *testing.T) {
+	registeredConditions["must_not_be_evaluated"] = func(parameters ConditionParameters) (Condition, error) {
+		return conditionThatMustNotBeEvaluated{t: t}, nil
+	}
+	defer delete(registeredConditions, "must_not_be_evaluated")
+
+	now := time.Date(2026, time.March, 4, 12, 0, 0, 0, time.UTC)
+	testCases := []struct {
+		name                        string
+		lastResults                 []ConditionResult
+		expectedBlockingConditions  []string
+		expectedConditionsEvaluated bool
+	}{
+		{"never evaluated", nil, []string{}, false},
+		{"all met", []ConditionResult{{ConditionName: "must_not_be_evaluated", Met: true}}, []string{}, true},
+		{"one unmet", []ConditionResult{
+			{ConditionName: "ac_power", Met: true},
+			{ConditionName: "must_not_be_evaluated", Met: false, ExplanationWhyUnmet: "was not met"},
+		}, []string{"must_not_be_evaluated"}, true},
+	}
+	for _, testCase := range testCases {
+		command := CommandWithArguments{Name: "status", UUID: uuid.New(), RequiredConditions: []string{"must_not_be_evaluated"}}
+		if testCase.lastResults != nil {
+			rememberConditionResults(command.UUID, testCase.lastResults)
+		}
+
+		status := statusOfCommand(command, now)
+		if (status.ConditionsEvaluatedAt != nil) != testCase.expectedConditionsEvaluated {
+			t.Errorf("%v: conditions evaluated at %v", testCase.name, status.ConditionsEvaluatedAt)
+		}
+		blockingConditionNames := []string{}
+		for _, currentCondition := range status.BlockingConditions {
+			blockingConditionNames = append(blockingConditionNames, currentCondition.Name)
+		}
+		if !reflect.DeepEqual(blockingConditionNames, testCase.expectedBlockingConditions) {
+			t.Errorf("%v: blocking conditions %v, expected %v", testCase.name, blockingConditionNames, testCase.expectedBlockingConditions)
+		}
+	}
+}
+
+// a store that counts its reads
+type storeCountingReads struct {
+	Store
+	reads int
+}
+
+func (store *storeCountingReads) Read() (CommandStore, error) {
+	store.reads++
+	return store.Store.Read()
+}
+
+func TestStatusesOfCommandsReadTheStoreOnce(t *testing.T) {
+	useTemporaryStore(t)
+	err := activeStore.Update(func(commandStore *CommandStore) error {
+		commandStore.Commands = []CommandWithArguments{{Name: "backup", UUID: uuid.New()}, {Name: "sync", UUID: uuid.New()}}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	countingStore := &storeCountingReads{Store: activeStore}
+	activeStore = countingStore
+
+	testCases := []struct {
+		commandNames  []string
+		expectedNames []string
+		expectedError bool
+	}{
+		{nil, []string{"backup", "sync"}, false},
+		{[]string{"sync", "backup"}, []string{"sync", "backup"}, false},
+		{[]string{"backup", "unknown"}, nil, true},
+	}
+	for _, testCase := range testCases {
+		countingStore.reads = 0
+		statuses, err := statusesOfCommands(testCase.commandNames)
+		if (err != nil) != testCase.expectedError {
+			t.Errorf("%v: error %v", testCase.commandNames, err)
+		}
+		names := []string{}
+		for _, currentStatus := range statuses {
+			names = append(names, currentStatus.Name)
+		}
+		if err == nil && !reflect.DeepEqual(names, testCase.expectedNames) {
+			t.Errorf("%v: statuses of %v, expected %v", testCase.commandNames, names, testCase.expectedNames)
+		}
+		if countingStore.reads != 1 {
+			t.Errorf("%v: store read %v times", testCase.commandNames, countingStore.reads)
+		}
+	}
+}
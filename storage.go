@@ -3,26 +3,60 @@ package main
 // This is the persistant storage of program state such as which commands were executed when
 
 import (
+	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"io/ioutil"
 	"os"
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
+)
+
+// Store is where the commands and their state are kept together with the history of their runs,
+// either an embedded database or JSON files
+type Store interface {
+	// all commands as they are right now
+	Read() (CommandStore, error)
+	// lets change modify the commands and stores them afterwards, all in one transaction so nobody
+	// else changes them in between, nothing is stored if change returns an error
+	Update(change func(commandStore *CommandStore) error) error
+	// adds a finished run to the history and removes the runs of the same command the retention
+	// does not keep anymore, which are returned
+	AddRun(run RunRecord, retention HistoryRetention) ([]RunRecord, error)
+	// all runs of a command in the history, in the order they were added
+	RunsOfCommand(commandName string) ([]RunRecord, error)
+}
+
+// chooses the store, the database unless the JSON file is asked for
+const storeEnvironmentVariable = "WORKSCHEDULER_STORE"
 
-	// support for locking read/write access to a file within and across processes
-	"github.com/gofrs/flock"
+const (
+	storeKindDatabase = "database"
+	storeKindJSON     = "json"
 )
 
-// Note on file locking:
-// create file locks in functions and not once, so different goroutines
-// create and have different locks
-// and thus have to wait for the other lock on the same file being released
-// Waiting for other processes also is handled by that, because file lock
-// is visible to other processes through OS mechanisms
+var activeStore = storeFromEnvironment()
 
-var pathToCommandStoreFile = "./commandStore.json"
+func storeFromEnvironment() Store {
+	chosenStore := strings.ToLower(os.Getenv(storeEnvironmentVariable))
+	switch chosenStore {
+	case storeKindJSON:
+		return jsonFileStore{pathToCommandStoreFile: pathToCommandStoreFile, pathToRunHistoryFile: pathToRunHistoryFile}
+	case storeKindDatabase, "":
+	default:
+		logWarning("Unknown store, falling back to the database", logFieldOf("variable", storeEnvironmentVariable), logFieldOf("store", chosenStore))
+	}
+	return &databaseStore{pathToDatabaseFile: pathToCommandDatabaseFile, pathToJSONCommandStoreFile: pathToCommandStoreFile,
+		pathToJSONRunHistoryFile: pathToRunHistoryFile}
+}
+
+// called from main program to read command store to do something with the
+// commands in it
+func readAndParseCommandStore() (CommandStore, error) {
+	return activeStore.Read()
+}
 
 // CommandStore contains all commands to be executed some time
 type CommandStore struct {
@@ -40,6 +74,56 @@ type CommandWithArguments struct {
 	State               CommandState
 	DurationBetweenRuns time.Duration
 	LastRun             time.Time
+	// names of the conditions that have to be met before the command is started,
+	// empty means the default conditions apply
+	RequiredConditions []string
+	// parameters of the required conditions by condition name
+	ConditionParameters map[string]ConditionParameters
+	// what happens when a condition is not met anymore while the command is running
+	OnConditionLost ConditionLostPolicy
+	// how long a terminated command may take to exit before it is killed, 0 means the default
+	TerminateGracePeriod time.Duration
+	// calendar schedules that decide the earliest time the command may run again, empty means none
+	CronSchedule string
+	OnCalendar   string
+	// when the command was first added, schedules count from here until it ran the first time
+	AddedAt time.Time
+	// the command may only start inside one of the allowed windows, if there are any,
+	// and never inside a blocked one
+	AllowedWindows []TimeWindow
+	BlockedWindows []TimeWindow
+	// how long a run usually takes, so it is not started right before an allowed window closes
+	ExpectedDuration time.Duration
+	RetryPolicy      RetryPolicy
+	// failed runs in a row, reset by a successful one
+	FailedAttempts int
+	// a failed command is not run again before this time, zero means right away
+	NextAttemptAt    time.Time
+	HistoryRetention HistoryRetention
+	OutputSettings   OutputSettings
+	// added with the add subcommand instead of a config file, so it is kept without one
+	AddedFromCommandLine bool
+	// disabled commands are not run until they are enabled again
+	Disabled bool
+	// run as soon as possible, no matter what its interval, schedule, windows and conditions say
+	RunRequested bool
+	// the process of the run going on right now, so a restarted daemon can find it again, nil when not running
+	CurrentRun *CurrentRun
+}
+
+// CurrentRun is a started process of a command, as far as it is needed to recognize it after a restart
+type CurrentRun struct {
+	RunID     uuid.UUID
+	StartTime time.Time
+	ProcessID int
+	// when the process started as the operating system counts it, so a later process that got the
+	// same process id is not mistaken for it, empty if unknown on this operating system
+	ProcessStartTime string
+	// the boot the process was started in, process start times count from the boot,
+	// empty if unknown on this operating system
+	BootID              string
+	OutputLocation      string
+	ErrorOutputLocation string
 }
 
 // CommandState is one of the states for a command to be in, this will be saved to disk, too
@@ -51,110 +135,117 @@ const (
 	CommandRunning        CommandState = "Running"
 	CommandFailed         CommandState = "Failed"
 	CommandSuccessful     CommandState = "Successful"
+	// still running, but stopped until its conditions are met again
+	CommandPaused CommandState = "Paused"
+	// stopped because its conditions were not met anymore, will be run again
+	CommandTerminated CommandState = "Terminated"
+	CommandKilled     CommandState = "Killed"
+	// failed as often as its retry policy allows and is not run again
+	CommandGaveUp CommandState = "GaveUp"
+	// was running when the daemon stopped, how it ended is unknown, retried like a failed command
+	CommandInterrupted CommandState = "Interrupted"
 )
 
 func changeStateOfCommand(uuidOfCommandToChangeState uuid.UUID, newState CommandState) error {
 
-	var fileLockOnCommandStoreFile = flock.New(pathToCommandStoreFile)
-
-	// locking for reading, modifying and writing command store
-	// todo handle/relay errors when locking
-	fileLockOnCommandStoreFile.Lock()
-	defer fileLockOnCommandStoreFile.Unlock()
-
-	commandStore, readError := readAndParseCommandStoreAlreadyLocked()
-
-	if readError != nil {
-		return readError
-	}
-
-	foundCommandToChangeState := false
-	// remove command with specified uuid from list
-	for index, currentCommand := range commandStore.Commands {
-		if currentCommand.UUID == uuidOfCommandToChangeState {
-			//fmt.Println("Changing state of command:", currentCommand, "to state", newState)
-			commandStore.Commands[index].State = newState
-
-			if newState == CommandSuccessful {
-				commandStore.Commands[index].LastRun = time.Now()
+	nameOfCommand := ""
+	// reading, modifying and writing command store in one transaction
+	writeError := activeStore.Update(func(commandStore *CommandStore) error {
+		// remove command with specified uuid from list
+		for index, currentCommand := range commandStore.Commands {
+			if currentCommand.UUID == uuidOfCommandToChangeState {
+				nameOfCommand = currentCommand.Name
+				logDebug("Changing state of command", commandLogFields(currentCommand, logFieldOf("state", newState))...)
+				commandStore.Commands[index].State = newState
+
+				if newState == CommandSuccessful {
+					commandStore.Commands[index].LastRun = time.Now()
+				}
+				// the requested run started
+				if newState == CommandRunning {
+					commandStore.Commands[index].RunRequested = false
+				}
+				// the process of the run is gone
+				if newState != CommandRunning && newState != CommandPaused {
+					commandStore.Commands[index].CurrentRun = nil
+				}
+				applyRetryPolicyAfterRun(&commandStore.Commands[index], newState, time.Now())
+				return nil
 			}
-
-			foundCommandToChangeState = true
-			break
 		}
-	}
-	if !foundCommandToChangeState {
 		return fmt.Errorf("UUID %v of command to change state to %v not found", uuidOfCommandToChangeState, newState)
+	})
+	if writeError == nil {
+		publishStateChange(nameOfCommand, newState)
 	}
 
-	writeError := marshalAndWriteCommandStore(commandStore)
-
 	// is nil on success
 	return writeError
 }
 
-func addCommandToCommandStore(absolutePathToExecutable string, commandArguments []string, durationBetweenExecutions time.Duration, uniqueCommandName string) (bool, error) {
+// adds a command with the settings from a config or the command line, the unique name has to be set,
+// UUID, state and last run are filled in here
+func addCommandToCommandStore(newCommandWithArguments CommandWithArguments) (bool, error) {
 
 	hasUpdatedCommandInCommandStore := false
 
-	var fileLockOnCommandStoreFile = flock.New(pathToCommandStoreFile)
-
-	// locking for reading, modifying and writing command store
-	fileLockOnCommandStoreFile.Lock()
-	defer fileLockOnCommandStoreFile.Unlock()
-
-	commandStore, readError := readAndParseCommandStoreAlreadyLocked()
-
-	if readError != nil {
-		return hasUpdatedCommandInCommandStore, readError
-	}
-
-	uuidOfNewCommand := uuid.New()
-
-	newCommandWithArguments := CommandWithArguments{
-		Name:                uniqueCommandName,
-		UUID:                uuidOfNewCommand,
-		AbsolutePath:        absolutePathToExecutable,
-		CommandArguments:    commandArguments,
-		State:               CommandWaitingToBeRun,
-		DurationBetweenRuns: durationBetweenExecutions,
-		// zero value of time indicates was never run before, year 1 is unlikely to come up otherwise
-		LastRun: time.Time{},
-	}
-
-	// check if command with same name was already in command store
-	// That could be from last run or was added by other config file already
-	// Just update its contents in both cases and report it was updated, not added
-	for index, currentCommand := range commandStore.Commands {
-
-		if currentCommand.Name == newCommandWithArguments.Name {
-			// overwrite values that can be specified in config
-			updatedCommand := updateContentsOfCommand(currentCommand, newCommandWithArguments)
-			fmt.Println("Updated command:", updatedCommand)
-			commandStore.Commands[index] = updatedCommand
-			hasUpdatedCommandInCommandStore = true
-			break
+	newCommandWithArguments.UUID = uuid.New()
+	newCommandWithArguments.State = CommandWaitingToBeRun
+	// zero value of time indicates was never run before, year 1 is unlikely to come up otherwise
+	newCommandWithArguments.LastRun = time.Time{}
+	newCommandWithArguments.AddedAt = time.Now()
+
+	// reading, modifying and writing command store in one transaction
+	writeError := activeStore.Update(func(commandStore *CommandStore) error {
+		// check if command with same name was already in command store
+		// That could be from last run or was added by other config file already
+		// Just update its contents in both cases and report it was updated, not added
+		for index, currentCommand := range commandStore.Commands {
+
+			if currentCommand.Name == newCommandWithArguments.Name {
+				// overwrite values that can be specified in config
+				updatedCommand := updateContentsOfCommand(currentCommand, newCommandWithArguments)
+				logDebug("Updated command", commandLogFields(updatedCommand)...)
+				commandStore.Commands[index] = updatedCommand
+				hasUpdatedCommandInCommandStore = true
+				return nil
+			}
 		}
-	}
 
-	// if the command is new, we need to append it, otherwise, it was already updated
-	if !hasUpdatedCommandInCommandStore {
+		// the command is new, so we need to append it
 		commandStore.Commands = append(commandStore.Commands, newCommandWithArguments)
-	}
-
-	writeError := marshalAndWriteCommandStore(commandStore)
+		return nil
+	})
 
 	// writeError is nil on success
 	return hasUpdatedCommandInCommandStore, writeError
 }
 
-// update absolute path, command arguments and duration between runs of a CommandWithArguments
+// commands stored before the time they were added was stored have the zero time, their schedules
+// would be searched from the year 1, so they count as added when the daemon first sees them
+func fillInMissingAddedAtOfCommands() error {
+	now := time.Now()
+	return activeStore.Update(func(commandStore *CommandStore) error {
+		for index, currentCommand := range commandStore.Commands {
+			if currentCommand.AddedAt.IsZero() {
+				logInfo("Command has no time it was added, counting it as added now", commandLogFields(currentCommand)...)
+				commandStore.Commands[index].AddedAt = now
+			}
+		}
+		return nil
+	})
+}
+
+// update absolute path, command arguments, duration between runs and conditions of a CommandWithArguments
 func updateContentsOfCommand(oldCommand CommandWithArguments, newCommandFromConfig CommandWithArguments) CommandWithArguments {
 
 	// make real copy of struct values to keep in order to not influence values passed into this function
 
-	newCommandArguments := make([]string, len(oldCommand.CommandArguments))
-	copy(oldCommand.CommandArguments, newCommandArguments)
+	newCommandArguments := make([]string, len(newCommandFromConfig.CommandArguments))
+	copy(newCommandArguments, newCommandFromConfig.CommandArguments)
+
+	newRequiredConditions := make([]string, len(newCommandFromConfig.RequiredConditions))
+	copy(newRequiredConditions, newCommandFromConfig.RequiredConditions)
 
 	updatedCommand := CommandWithArguments{
 		// name should always be the same in new command anyway
@@ -166,157 +257,149 @@ func updateContentsOfCommand(oldCommand CommandWithArguments, newCommandFromConf
 		DurationBetweenRuns: time.Duration(newCommandFromConfig.DurationBetweenRuns.Nanoseconds()),
 		// LastRun should stay from the old value in case it was already run, the new value can only
 		// come from a config and is therefore always empty
-		LastRun: oldCommand.LastRun,
+		LastRun:            oldCommand.LastRun,
+		RequiredConditions: newRequiredConditions,
+		// parameters are only read and never modified, so sharing them is fine
+		ConditionParameters:  newCommandFromConfig.ConditionParameters,
+		OnConditionLost:      newCommandFromConfig.OnConditionLost,
+		TerminateGracePeriod: newCommandFromConfig.TerminateGracePeriod,
+		CronSchedule:         newCommandFromConfig.CronSchedule,
+		OnCalendar:           newCommandFromConfig.OnCalendar,
+		AllowedWindows:       newCommandFromConfig.AllowedWindows,
+		BlockedWindows:       newCommandFromConfig.BlockedWindows,
+		ExpectedDuration:     newCommandFromConfig.ExpectedDuration,
+		RetryPolicy:          newCommandFromConfig.RetryPolicy,
+		HistoryRetention:     newCommandFromConfig.HistoryRetention,
+		OutputSettings:       newCommandFromConfig.OutputSettings,
+		// the retry state is not part of the config
+		FailedAttempts: oldCommand.FailedAttempts,
+		NextAttemptAt:  oldCommand.NextAttemptAt,
+		// the command stays the same one, so it was still added back then
+		AddedAt:              oldCommand.AddedAt,
+		AddedFromCommandLine: newCommandFromConfig.AddedFromCommandLine,
+		// set on the command line and not in the config
+		Disabled:     oldCommand.Disabled,
+		RunRequested: oldCommand.RunRequested,
+		// might still be running
+		CurrentRun: oldCommand.CurrentRun,
+	}
+
+	// a changed config is a new chance for a command that failed or gave up
+	if hasConfigOfCommandChanged(oldCommand, updatedCommand) {
+		resetRetriesOfCommand(&updatedCommand)
 	}
 
 	return updatedCommand
 }
 
-// not needed anymore if all uuid code is removed
-func removeCommandFromCommandStore(uuidOfCommandToRemove uuid.UUID) error {
-
-	var fileLockOnCommandStoreFile = flock.New(pathToCommandStoreFile)
-
-	// locking for reading, modifying and writing command store
-	fileLockOnCommandStoreFile.Lock()
-	defer fileLockOnCommandStoreFile.Unlock()
-
-	commandStore, readError := readAndParseCommandStoreAlreadyLocked()
+// the updated command only differs from the old one in what the config sets, compared as JSON because
+// numbers in condition parameters are int64 from a config, but float64 from the store
+func hasConfigOfCommandChanged(oldCommand CommandWithArguments, updatedCommand CommandWithArguments) bool {
+	marshalledOldCommand, oldError := json.Marshal(withoutEmptyLists(oldCommand))
+	marshalledUpdatedCommand, updatedError := json.Marshal(withoutEmptyLists(updatedCommand))
+	return oldError != nil || updatedError != nil || !bytes.Equal(marshalledOldCommand, marshalledUpdatedCommand)
+}
 
-	if readError != nil {
-		return readError
+// empty lists and nil are the same to the daemon, but not in JSON, and newly added commands have nil
+// where updated ones have empty lists
+func withoutEmptyLists(command CommandWithArguments) CommandWithArguments {
+	if len(command.CommandArguments) == 0 {
+		command.CommandArguments = nil
 	}
-
-	foundCommandToRemove := false
-	// remove command with specified uuid from list
-	for index, currentCommand := range commandStore.Commands {
-		if currentCommand.UUID == uuidOfCommandToRemove {
-			//fmt.Println("Removing command:", currentCommand)
-			// overwrite current element with last element of list
-			commandStore.Commands[index] = commandStore.Commands[len(commandStore.Commands)-1]
-			// take list without the last element
-			commandStore.Commands = commandStore.Commands[:len(commandStore.Commands)-1]
-			foundCommandToRemove = true
-			break
-		}
+	if len(command.RequiredConditions) == 0 {
+		command.RequiredConditions = nil
 	}
-	if !foundCommandToRemove {
-		return fmt.Errorf("UUID %v of command to remove not found", uuidOfCommandToRemove)
+	if len(command.ConditionParameters) == 0 {
+		command.ConditionParameters = nil
 	}
-
-	writeError := marshalAndWriteCommandStore(commandStore)
-
-	// is nil on success
-	return writeError
+	if len(command.AllowedWindows) == 0 {
+		command.AllowedWindows = nil
+	}
+	if len(command.BlockedWindows) == 0 {
+		command.BlockedWindows = nil
+	}
+	return command
 }
 
-func removeCommandFromCommandStoreByName(commandNameToRemove string) error {
-	var fileLockOnCommandStoreFile = flock.New(pathToCommandStoreFile)
-
-	// locking for reading, modifying and writing command store
-	fileLockOnCommandStoreFile.Lock()
-	defer fileLockOnCommandStoreFile.Unlock()
-
-	commandStore, readError := readAndParseCommandStoreAlreadyLocked()
-
-	if readError != nil {
-		return readError
-	}
+// not needed anymore if all uuid code is removed
+func removeCommandFromCommandStore(uuidOfCommandToRemove uuid.UUID) error {
 
-	foundCommandToRemove := false
-	// remove command with specified name from list
-	for index, currentCommand := range commandStore.Commands {
-		if currentCommand.Name == commandNameToRemove {
-			//fmt.Println("Removing command:", currentCommand)
-			// overwrite current element with last element of list
-			commandStore.Commands[index] = commandStore.Commands[len(commandStore.Commands)-1]
-			// take list without the last element
-			commandStore.Commands = commandStore.Commands[:len(commandStore.Commands)-1]
-			foundCommandToRemove = true
-			break
+	// reading, modifying and writing command store in one transaction
+	writeError := activeStore.Update(func(commandStore *CommandStore) error {
+		// remove command with specified uuid from list
+		for index, currentCommand := range commandStore.Commands {
+			if currentCommand.UUID == uuidOfCommandToRemove {
+				logDebug("Removing command", commandLogFields(currentCommand)...)
+				// overwrite current element with last element of list
+				commandStore.Commands[index] = commandStore.Commands[len(commandStore.Commands)-1]
+				// take list without the last element
+				commandStore.Commands = commandStore.Commands[:len(commandStore.Commands)-1]
+				return nil
+			}
 		}
-	}
-	if !foundCommandToRemove {
-		return fmt.Errorf("Name %v of command to remove not found", commandNameToRemove)
-	}
-
-	writeError := marshalAndWriteCommandStore(commandStore)
+		return fmt.Errorf("UUID %v of command to remove not found", uuidOfCommandToRemove)
+	})
 
 	// is nil on success
 	return writeError
 }
 
-// called internally by storage functions when changing state of command, adding or removing commands
-// because these actions need to lock the file from before reading until after
-// writing their changes so the read function should not take a lock again
-func readAndParseCommandStoreAlreadyLocked() (CommandStore, error) {
-	return readAndParseCommandStoreFromFile(pathToCommandStoreFile, true)
-}
+// errCommandNotFound is returned when there is no command with a name in the command store
+var errCommandNotFound = errors.New("command not found in command store")
+
+// changes a command in the command store with the given function, e.g. to disable it
+func modifyCommandInCommandStoreByName(commandNameToModify string, modify func(command *CommandWithArguments)) error {
+	// reading, modifying and writing command store in one transaction
+	writeError := activeStore.Update(func(commandStore *CommandStore) error {
+		for index, currentCommand := range commandStore.Commands {
+			if currentCommand.Name == commandNameToModify {
+				modify(&commandStore.Commands[index])
+				return nil
+			}
+		}
+		return fmt.Errorf("%w: %v", errCommandNotFound, commandNameToModify)
+	})
 
-// called from main program to read command store to do something with the
-// commands in it
-func readAndParseCommandStore() (CommandStore, error) {
-	return readAndParseCommandStoreFromFile(pathToCommandStoreFile, false)
+	// is nil on success
+	return writeError
 }
 
-func readAndParseCommandStoreFromFile(pathToCommandStoreFile string, alreadyLocked bool) (CommandStore, error) {
-
-	var fileLockOnCommandStoreFile = flock.New(pathToCommandStoreFile)
-
-	if !alreadyLocked {
-		fileLockOnCommandStoreFile.Lock()
-	}
-
-	commandStore := CommandStore{}
-	// empty file is created by this, if it does not exist
-	marshalledJSONData, readingError := ioutil.ReadFile(pathToCommandStoreFile)
-	if readingError != nil {
-		return commandStore, readingError
-	}
-	if !alreadyLocked {
-		fileLockOnCommandStoreFile.Unlock()
-	}
-
-	// Empty file is not valid json, so just return empty command store here before trying to unmarshal.
-	// A write to the command store will create valid json in the future.
-	if len(marshalledJSONData) == 0 {
-		return commandStore, readingError
-	}
-
-	unmarshalError := json.Unmarshal(marshalledJSONData, &commandStore)
-	if unmarshalError != nil {
-		return commandStore, unmarshalError
+// the command with the given name from the command store
+func findCommandInCommandStoreByName(commandName string) (CommandWithArguments, error) {
+	commandStore, err := readAndParseCommandStore()
+	if err != nil {
+		return CommandWithArguments{}, err
 	}
-
-	return commandStore, nil
-}
-
-// never called directly from scheduling logic, only through add command and change command functions
-// so the command store file will be already locked
-func marshalAndWriteCommandStore(commandStore CommandStore) error {
-	return marshalAndWriteCommandStoreToFile(pathToCommandStoreFile, commandStore)
+	return commandStore.findCommandByName(commandName)
 }
 
-func marshalAndWriteCommandStoreToFile(pathToCommandStoreFile string, commandStore CommandStore) error {
-
-	// prefix new lines with nothing, indent with tabs
-	marshalledJSONData, marshalError := json.MarshalIndent(&commandStore, "", "\t")
-
-	if marshalError != nil {
-		return marshalError
+// looks the command up in a command store that was already read, so several names don't read the store again each
+func (commandStore CommandStore) findCommandByName(commandName string) (CommandWithArguments, error) {
+	for _, currentCommand := range commandStore.Commands {
+		if currentCommand.Name == commandName {
+			return currentCommand, nil
+		}
 	}
+	return CommandWithArguments{}, fmt.Errorf("%w: %v", errCommandNotFound, commandName)
+}
 
-	// write to / overwrite configured data store file with provided data:
-
-	// file mode is 0 meaning regular file and 600 means only readable and writeable by own user
-	// and executable by no one
-	// umask gets applied afterwards and might change permissions of created file
-	var permissionsForNewFileBeforeUmask os.FileMode = 0600
+func removeCommandFromCommandStoreByName(commandNameToRemove string) error {
+	// reading, modifying and writing command store in one transaction
+	writeError := activeStore.Update(func(commandStore *CommandStore) error {
+		// remove command with specified name from list
+		for index, currentCommand := range commandStore.Commands {
+			if currentCommand.Name == commandNameToRemove {
+				logDebug("Removing command", commandLogFields(currentCommand)...)
+				// overwrite current element with last element of list
+				commandStore.Commands[index] = commandStore.Commands[len(commandStore.Commands)-1]
+				// take list without the last element
+				commandStore.Commands = commandStore.Commands[:len(commandStore.Commands)-1]
+				return nil
+			}
+		}
+		return fmt.Errorf("%w: %v", errCommandNotFound, commandNameToRemove)
+	})
 
-	// when overwriting file, permissions are not changed
-	writeError := ioutil.WriteFile(pathToCommandStoreFile, marshalledJSONData, permissionsForNewFileBeforeUmask)
-	if writeError != nil {
-		return writeError
-	}
-	return nil
+	// is nil on success
+	return writeError
 }
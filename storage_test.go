@@ -0,0 +1,43 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// lets the storage functions use a JSON store and a run history in a temporary directory for the rest of the test
+func useTemporaryStore(t *testing.T) {
+	t.Helper()
+	earlierStore := activeStore
+	temporaryDirectory := t.TempDir()
+	activeStore = jsonFileStore{pathToCommandStoreFile: filepath.Join(temporaryDirectory, "commandStore.json"),
+		pathToRunHistoryFile: filepath.Join(temporaryDirectory, "runHistory.json")}
+	t.Cleanup(func() { activeStore = earlierStore })
+}
+
+func TestFillInMissingAddedAtOfCommands(t *testing.T) {
+	useTemporaryStore(t)
+	addedAt := time.Date(2026, time.March, 4, 10, 0, 0, 0, time.UTC)
+	err := activeStore.Update(func(commandStore *CommandStore) error {
+		commandStore.Commands = []CommandWithArguments{{Name: "old"}, {Name: "new", AddedAt: addedAt}}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	before := time.Now()
+	if err := fillInMissingAddedAtOfCommands(); err != nil {
+		t.Fatal(err)
+	}
+
+	oldCommand, _ := findCommandInCommandStoreByName("old")
+	if oldCommand.AddedAt.Before(before) {
+		t.Errorf("added at of old command is %v, expected now", oldCommand.AddedAt)
+	}
+	newCommand, _ := findCommandInCommandStoreByName("new")
+	if !newCommand.AddedAt.Equal(addedAt) {
+		t.Errorf("added at of new command changed to %v", newCommand.AddedAt)
+	}
+}
@@ -0,0 +1,16 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import "os"
+
+// makes sure files created, renamed or removed in the directory are on disk
+func syncDirectory(pathToDirectory string) error {
+	directory, err := os.Open(pathToDirectory)
+	if err != nil {
+		return err
+	}
+	defer directory.Close()
+	return directory.Sync()
+}
@@ -0,0 +1,9 @@
+//go:build windows
+// +build windows
+
+package main
+
+// directories can't be opened to sync them on windows, it is up to the file system when a rename is on disk there
+func syncDirectory(pathToDirectory string) error {
+	return nil
+}
@@ -0,0 +1,260 @@
+package main
+
+// Times of day a command may or may not run in, like "only between 22:00 and 06:00"
+// or "not during working hours on weekdays"
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// TimeWindow is a range of time of day on some days of the week, the end may be on the next day
+type TimeWindow struct {
+	// weekdays the window starts on like "Mon", "Sat,Sun" or "Mon..Fri", empty means every day
+	Days []string `toml:"days"`
+	// "22:00", the end may be before the start when the window crosses midnight
+	Start string `toml:"start"`
+	End   string `toml:"end"`
+	// time zone of start and end like "Europe/Berlin", empty means local time
+	TimeZone string `toml:"time_zone"`
+}
+
+// TimeWindow ready to be checked
+type parsedTimeWindow struct {
+	// bit n set means windows start on time.Weekday n
+	weekdays uint64
+	// offsets from midnight
+	start    time.Duration
+	end      time.Duration
+	location *time.Location
+}
+
+func (window TimeWindow) parse() (parsedTimeWindow, error) {
+	parsedWindow := parsedTimeWindow{weekdays: allValuesBetween(0, 6), location: time.Local}
+
+	if len(window.Days) > 0 {
+		parsedWindow.weekdays = 0
+		for _, currentDays := range window.Days {
+			weekdays, err := parseScheduleField(currentDays, scheduleFieldSyntax{minimum: 0, maximum: 6, rangeSeparator: "..", names: weekdayNames})
+			if err != nil {
+				return parsedWindow, fmt.Errorf("invalid days of time window: %v", err)
+			}
+			parsedWindow.weekdays |= weekdays
+		}
+	}
+
+	var err error
+	if parsedWindow.start, err = parseTimeOfDay(window.Start); err != nil {
+		return parsedWindow, fmt.Errorf("invalid start of time window: %v", err)
+	}
+	if parsedWindow.end, err = parseTimeOfDay(window.End); err != nil {
+		return parsedWindow, fmt.Errorf("invalid end of time window: %v", err)
+	}
+	if parsedWindow.start == parsedWindow.end {
+		return parsedWindow, fmt.Errorf("time window from %v to %v is empty", window.Start, window.End)
+	}
+
+	if window.TimeZone != "" {
+		if parsedWindow.location, err = time.LoadLocation(window.TimeZone); err != nil {
+			return parsedWindow, fmt.Errorf("invalid time zone of time window: %v", err)
+		}
+	}
+	return parsedWindow, nil
+}
+
+// "hour:minute" as offset from midnight, "24:00" is allowed as the end of a day
+func parseTimeOfDay(timeOfDay string) (time.Duration, error) {
+	var hours, minutes int
+	if _, err := fmt.Sscanf(timeOfDay, "%d:%d", &hours, &minutes); err != nil || strings.Count(timeOfDay, ":") != 1 {
+		return 0, fmt.Errorf("%q must look like hour:minute", timeOfDay)
+	}
+	if hours < 0 || minutes < 0 || minutes > 59 || hours > 24 || (hours == 24 && minutes != 0) {
+		return 0, fmt.Errorf("%q is not a time of day", timeOfDay)
+	}
+	return time.Duration(hours)*time.Hour + time.Duration(minutes)*time.Minute, nil
+}
+
+// the occurrence of the window that contains the given time, if there is one,
+// windows crossing midnight might have started on the day before
+func (window parsedTimeWindow) occurrenceContaining(moment time.Time) (time.Time, time.Time, bool) {
+	for _, currentOccurrence := range window.occurrencesBetween(moment, moment) {
+		if currentOccurrence.contains(moment) {
+			return currentOccurrence.start, currentOccurrence.end, true
+		}
+	}
+	return time.Time{}, time.Time{}, false
+}
+
+// one time a window is open
+type windowOccurrence struct {
+	start time.Time
+	end   time.Time
+}
+
+func (occurrence windowOccurrence) contains(moment time.Time) bool {
+	return !moment.Before(occurrence.start) && moment.Before(occurrence.end)
+}
+
+// every occurrence of the window that is open at some point between from and to, ordered by start,
+// windows are at most a day long, so only the ones starting from the day before on can reach from
+func (window parsedTimeWindow) occurrencesBetween(from time.Time, to time.Time) []windowOccurrence {
+	occurrences := make([]windowOccurrence, 0)
+	year, month, day := from.In(window.location).Date()
+
+	for midnight := time.Date(year, month, day-1, 0, 0, 0, 0, window.location); !midnight.After(to); midnight = midnight.AddDate(0, 0, 1) {
+		if !isBitSet(window.weekdays, int(midnight.Weekday())) {
+			continue
+		}
+
+		// time.Date instead of adding durations keeps the time of day right on days clocks are turned
+		occurrence := windowOccurrence{start: addTimeOfDay(midnight, window.start), end: addTimeOfDay(midnight, window.end)}
+		if window.end < window.start {
+			occurrence.end = addTimeOfDay(midnight.AddDate(0, 0, 1), window.end)
+		}
+		if occurrence.end.After(from) && !occurrence.start.After(to) {
+			occurrences = append(occurrences, occurrence)
+		}
+	}
+	return occurrences
+}
+
+func addTimeOfDay(midnight time.Time, timeOfDay time.Duration) time.Time {
+	year, month, day := midnight.Date()
+	hours := int(timeOfDay / time.Hour)
+	minutes := int(timeOfDay % time.Hour / time.Minute)
+	return time.Date(year, month, day, hours, minutes, 0, 0, midnight.Location())
+}
+
+func parseTimeWindows(windows []TimeWindow) ([]parsedTimeWindow, error) {
+	parsedWindows := make([]parsedTimeWindow, 0, len(windows))
+	for _, currentWindow := range windows {
+		parsedWindow, err := currentWindow.parse()
+		if err != nil {
+			return parsedWindows, err
+		}
+		parsedWindows = append(parsedWindows, parsedWindow)
+	}
+	return parsedWindows, nil
+}
+
+// checks whether the command may start at the given time according to its time windows and explains
+// why not, a command with an expected duration has to fit into an allowed window before it closes
+// and must not run into a blocked window
+func isStartAllowedByTimeWindows(command CommandWithArguments, startTime time.Time) (bool, string, error) {
+	allowedWindows, blockedWindows, err := parseTimeWindowsOfCommand(command)
+	if err != nil {
+		return false, "", err
+	}
+	isStartAllowed, reasonWhyNotAllowed := isStartAllowedByParsedTimeWindows(allowedWindows, blockedWindows, command.ExpectedDuration, startTime)
+	return isStartAllowed, reasonWhyNotAllowed, nil
+}
+
+func parseTimeWindowsOfCommand(command CommandWithArguments) ([]parsedTimeWindow, []parsedTimeWindow, error) {
+	allowedWindows, err := parseTimeWindows(command.AllowedWindows)
+	if err != nil {
+		return nil, nil, err
+	}
+	blockedWindows, err := parseTimeWindows(command.BlockedWindows)
+	if err != nil {
+		return nil, nil, err
+	}
+	return allowedWindows, blockedWindows, nil
+}
+
+// how far ahead the next allowed start is searched, a week and a day covers every window
+// including those crossing midnight
+const timeWindowSearchDuration = 8 * 24 * time.Hour
+
+// the earliest time from the given one on that the time windows of the command allow it to start,
+// false if there is none in the next week, e.g. because the expected duration never fits
+func nextStartAllowedByTimeWindows(command CommandWithArguments, earliestTime time.Time) (time.Time, bool, error) {
+	allowedWindows, blockedWindows, err := parseTimeWindowsOfCommand(command)
+	if err != nil {
+		return time.Time{}, false, err
+	}
+
+	// a start only becomes allowed when an allowed window opens or a blocked one closes, the expected
+	// duration fits best right when the window opens, so only those times have to be checked
+	lastCheckedTime := earliestTime.Add(timeWindowSearchDuration)
+	candidateTimes := []time.Time{earliestTime}
+	for _, currentWindow := range allowedWindows {
+		for _, currentOccurrence := range currentWindow.occurrencesBetween(earliestTime, lastCheckedTime) {
+			candidateTimes = append(candidateTimes, currentOccurrence.start)
+		}
+	}
+	for _, currentWindow := range blockedWindows {
+		for _, currentOccurrence := range currentWindow.occurrencesBetween(earliestTime, lastCheckedTime) {
+			candidateTimes = append(candidateTimes, currentOccurrence.end)
+		}
+	}
+	sort.Slice(candidateTimes, func(i, j int) bool { return candidateTimes[i].Before(candidateTimes[j]) })
+
+	for _, candidateTime := range candidateTimes {
+		if candidateTime.Before(earliestTime) || candidateTime.After(lastCheckedTime) {
+			continue
+		}
+		if isStartAllowed, _ := isStartAllowedByParsedTimeWindows(allowedWindows, blockedWindows, command.ExpectedDuration, candidateTime); isStartAllowed {
+			return candidateTime, true, nil
+		}
+	}
+	return time.Time{}, false, nil
+}
+
+func isStartAllowedByParsedTimeWindows(allowedWindows []parsedTimeWindow, blockedWindows []parsedTimeWindow, expectedDuration time.Duration, startTime time.Time) (bool, string) {
+	expectedEndTime := startTime.Add(expectedDuration)
+
+	if len(allowedWindows) > 0 {
+		endOfAllowedTime := endOfAllowedTimeFrom(allowedWindows, startTime, expectedEndTime)
+		if !endOfAllowedTime.After(startTime) {
+			return false, "outside of all allowed time windows"
+		}
+		if expectedEndTime.After(endOfAllowedTime) {
+			return false, fmt.Sprintf("expected to take %v, but the allowed time window closes at %v",
+				expectedDuration, endOfAllowedTime.Format("15:04 MST"))
+		}
+	}
+
+	for _, currentWindow := range blockedWindows {
+		for _, currentOccurrence := range currentWindow.occurrencesBetween(startTime, expectedEndTime) {
+			if currentOccurrence.contains(startTime) {
+				return false, fmt.Sprintf("inside a blocked time window from %v to %v",
+					currentOccurrence.start.Format("Mon 15:04"), currentOccurrence.end.Format("Mon 15:04 MST"))
+			}
+			// the run would reach into the occurrence
+			if currentOccurrence.start.Before(expectedEndTime) {
+				return false, fmt.Sprintf("expected to take %v, but a blocked time window starts at %v",
+					expectedDuration, currentOccurrence.start.Format("Mon 15:04 MST"))
+			}
+		}
+	}
+
+	return true, ""
+}
+
+// until when the allowed windows allow running without a break from the start time on, the start time
+// itself if it is outside of all of them, windows that touch or overlap count as one, like 22:00 to 24:00
+// and 00:00 to 06:00
+func endOfAllowedTimeFrom(allowedWindows []parsedTimeWindow, startTime time.Time, expectedEndTime time.Time) time.Time {
+	occurrences := make([]windowOccurrence, 0)
+	for _, currentWindow := range allowedWindows {
+		occurrences = append(occurrences, currentWindow.occurrencesBetween(startTime, expectedEndTime)...)
+	}
+
+	endOfAllowedTime := startTime
+	for extended := true; extended; {
+		extended = false
+		for _, currentOccurrence := range occurrences {
+			if !currentOccurrence.start.After(endOfAllowedTime) && currentOccurrence.end.After(endOfAllowedTime) {
+				endOfAllowedTime = currentOccurrence.end
+				extended = true
+			}
+		}
+		// occurrences after the expected end don't matter, but the one containing it does
+		if endOfAllowedTime.After(expectedEndTime) {
+			break
+		}
+	}
+	return endOfAllowedTime
+}
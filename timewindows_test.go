@@ -0,0 +1,139 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// 2026-03-04 is a wednesday
+func utcTime(day int, hour int, minute int) time.Time {
+	return time.Date(2026, time.March, day, hour, minute, 0, 0, time.UTC)
+}
+
+func utcWindow(start string, end string, days ...string) TimeWindow {
+	return TimeWindow{Days: days, Start: start, End: end, TimeZone: "UTC"}
+}
+
+func TestIsStartAllowedByTimeWindows(t *testing.T) {
+	nightly := []TimeWindow{utcWindow("22:00", "06:00")}
+	beforeAndAfterMidnight := []TimeWindow{utcWindow("22:00", "24:00"), utcWindow("00:00", "06:00")}
+	workingHours := []TimeWindow{utcWindow("09:00", "17:00", "Mon..Fri")}
+
+	testCases := []struct {
+		name             string
+		allowedWindows   []TimeWindow
+		blockedWindows   []TimeWindow
+		expectedDuration time.Duration
+		startTime        time.Time
+		expectedAllowed  bool
+	}{
+		{"no windows", nil, nil, time.Hour, utcTime(4, 12, 0), true},
+		{"inside window crossing midnight before it", nightly, nil, 0, utcTime(4, 23, 0), true},
+		{"inside window crossing midnight after it", nightly, nil, 0, utcTime(5, 5, 59), true},
+		{"end of window is outside", nightly, nil, 0, utcTime(5, 6, 0), false},
+		{"outside allowed window", nightly, nil, 0, utcTime(4, 12, 0), false},
+		{"run fits before window closes", nightly, nil, 2 * time.Hour, utcTime(5, 4, 0), true},
+		{"run does not fit before window closes", nightly, nil, 3 * time.Hour, utcTime(5, 4, 0), false},
+		{"adjacent windows count as one", beforeAndAfterMidnight, nil, 4 * time.Hour, utcTime(4, 23, 0), true},
+		{"adjacent windows still close", beforeAndAfterMidnight, nil, 8 * time.Hour, utcTime(4, 23, 0), false},
+		{"overlapping windows count as one", []TimeWindow{utcWindow("20:00", "23:00"), utcWindow("22:00", "02:00")}, nil,
+			5 * time.Hour, utcTime(4, 20, 30), true},
+		{"inside blocked window", nil, workingHours, 0, utcTime(4, 10, 0), false},
+		{"blocked window only on weekdays", nil, workingHours, 0, utcTime(7, 10, 0), true},
+		{"run reaches into blocked window", nil, workingHours, 2 * time.Hour, utcTime(4, 8, 0), false},
+		{"run ends when blocked window starts", nil, workingHours, time.Hour, utcTime(4, 8, 0), true},
+		{"run reaches into blocked window of next day", nil, workingHours, 24 * time.Hour, utcTime(5, 18, 0), false},
+		{"allowed but blocked", nightly, []TimeWindow{utcWindow("02:00", "03:00")}, 0, utcTime(5, 2, 30), false},
+	}
+	for _, testCase := range testCases {
+		command := CommandWithArguments{AllowedWindows: testCase.allowedWindows, BlockedWindows: testCase.blockedWindows,
+			ExpectedDuration: testCase.expectedDuration}
+		isStartAllowed, reasonWhyNotAllowed, err := isStartAllowedByTimeWindows(command, testCase.startTime)
+		if err != nil {
+			t.Errorf("%v: %v", testCase.name, err)
+			continue
+		}
+		if isStartAllowed != testCase.expectedAllowed {
+			t.Errorf("%v: allowed is %v, expected %v (%v)", testCase.name, isStartAllowed, testCase.expectedAllowed, reasonWhyNotAllowed)
+		}
+	}
+}
+
+func TestNextStartAllowedByTimeWindows(t *testing.T) {
+	testCases := []struct {
+		name              string
+		allowedWindows    []TimeWindow
+		blockedWindows    []TimeWindow
+		expectedDuration  time.Duration
+		earliestTime      time.Time
+		expectedStartTime time.Time
+		expectedFound     bool
+	}{
+		{"allowed right away", []TimeWindow{utcWindow("22:00", "06:00")}, nil, 0, utcTime(4, 23, 17), utcTime(4, 23, 17), true},
+		{"next allowed window", []TimeWindow{utcWindow("22:00", "06:00")}, nil, 0, utcTime(4, 12, 0), utcTime(4, 22, 0), true},
+		{"only on the weekend", []TimeWindow{utcWindow("10:00", "12:00", "Sat,Sun")}, nil, 0, utcTime(4, 12, 0), utcTime(7, 10, 0), true},
+		{"after blocked window", nil, []TimeWindow{utcWindow("09:00", "17:00", "Mon..Fri")}, 0, utcTime(4, 10, 0), utcTime(4, 17, 0), true},
+		{"blocked window on friday ends before the weekend", nil, []TimeWindow{utcWindow("09:00", "17:00", "Mon..Fri")}, 20 * time.Hour,
+			utcTime(6, 10, 0), utcTime(6, 17, 0), true},
+		{"long run only fits into the weekend", nil, []TimeWindow{utcWindow("09:00", "17:00", "Mon..Fri")}, 24 * time.Hour,
+			utcTime(4, 10, 0), utcTime(6, 17, 0), true},
+		{"too short window is skipped", []TimeWindow{utcWindow("01:00", "02:00", "Wed"), utcWindow("01:00", "05:00", "Fri")}, nil, 3 * time.Hour,
+			utcTime(4, 0, 0), utcTime(6, 1, 0), true},
+		{"adjacent windows fit a run crossing midnight", []TimeWindow{utcWindow("22:00", "24:00"), utcWindow("00:00", "06:00")}, nil, 7 * time.Hour,
+			utcTime(4, 12, 0), utcTime(4, 22, 0), true},
+		{"run never fits", []TimeWindow{utcWindow("01:00", "02:00")}, nil, 2 * time.Hour, utcTime(4, 0, 0), time.Time{}, false},
+	}
+	for _, testCase := range testCases {
+		command := CommandWithArguments{AllowedWindows: testCase.allowedWindows, BlockedWindows: testCase.blockedWindows,
+			ExpectedDuration: testCase.expectedDuration}
+		startTime, found, err := nextStartAllowedByTimeWindows(command, testCase.earliestTime)
+		if err != nil {
+			t.Errorf("%v: %v", testCase.name, err)
+			continue
+		}
+		if found != testCase.expectedFound || !startTime.Equal(testCase.expectedStartTime) {
+			t.Errorf("%v: next start %v (found %v), expected %v (found %v)", testCase.name, startTime, found,
+				testCase.expectedStartTime, testCase.expectedFound)
+		}
+	}
+}
+
+func TestTimeWindowsAcrossChangeOfClocks(t *testing.T) {
+	// clocks in Berlin were turned forward from 02:00 to 03:00 on 2026-03-29
+	window := TimeWindow{Start: "01:00", End: "04:00", TimeZone: "Europe/Berlin"}
+	parsedWindow, err := window.parse()
+	if err != nil {
+		t.Skipf("no time zone data: %v", err)
+	}
+	berlin := parsedWindow.location
+	occurrenceStart, occurrenceEnd, found := parsedWindow.occurrenceContaining(time.Date(2026, time.March, 29, 3, 30, 0, 0, berlin))
+	if !found || occurrenceEnd.Sub(occurrenceStart) != 2*time.Hour {
+		t.Fatalf("occurrence on the day clocks were turned is %v to %v (found %v), expected two hours", occurrenceStart, occurrenceEnd, found)
+	}
+}
+
+func TestInvalidTimeWindows(t *testing.T) {
+	for _, window := range []TimeWindow{
+		{Start: "22:00", End: "22:00"},
+		{Start: "25:00", End: "06:00"},
+		{Start: "24:30", End: "06:00"},
+		{Start: "10", End: "12:00"},
+		{Start: "10:00", End: "12:00", Days: []string{"Someday"}},
+		{Start: "10:00", End: "12:00", TimeZone: "Mars/Olympus"},
+	} {
+		if _, err := window.parse(); err == nil {
+			t.Errorf("%+v: no error", window)
+		}
+	}
+}
+
+func BenchmarkNextStartAllowedByTimeWindows(b *testing.B) {
+	command := CommandWithArguments{
+		AllowedWindows:   []TimeWindow{utcWindow("01:00", "02:00", "Sun")},
+		BlockedWindows:   []TimeWindow{utcWindow("09:00", "17:00", "Mon..Fri")},
+		ExpectedDuration: 2 * time.Hour,
+	}
+	for i := 0; i < b.N; i++ {
+		nextStartAllowedByTimeWindows(command, utcTime(4, 12, 0))
+	}
+}